@@ -0,0 +1,104 @@
+package calendar
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+)
+
+// * a closed week would never let a date roll forward, a year is as far as anybody should look
+const searchDays = 366
+
+// * LIBRARY_TIMEZONE decides which day it is at the library, the local time zone of the server when empty
+func LocationFromEnv() *time.Location {
+	name := os.Getenv("LIBRARY_TIMEZONE")
+	if name == "" {
+		return time.Local
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("unknown LIBRARY_TIMEZONE %q, using the local time zone: %v", name, err)
+		return time.Local
+	}
+	return location
+}
+
+// * the days the library is open, made of the regular hours and the closures
+type Calendar struct {
+	location *time.Location
+	open     map[time.Weekday]bool
+	closures []types.Closure
+}
+
+// * without any opening hours every day of the week counts as open, only closures are skipped
+func New(location *time.Location, hours []types.OpeningHours, closures []types.Closure) *Calendar {
+	var open map[time.Weekday]bool
+	if len(hours) > 0 {
+		open = make(map[time.Weekday]bool)
+		for _, h := range hours {
+			open[h.Weekday] = true
+		}
+	}
+	return &Calendar{location: location, open: open, closures: closures}
+}
+
+// * reads what is needed to answer for the days from the given time on
+func Load(cr types.CalendarRepository, location *time.Location, from time.Time) (*Calendar, error) {
+	hours, err := cr.GetHours()
+	if err != nil {
+		return nil, err
+	}
+
+	closures, err := cr.GetClosures(types.ClosureFilter{From: &from})
+	if err != nil {
+		return nil, err
+	}
+	return New(location, hours, closures), nil
+}
+
+func (c *Calendar) IsOpen(t time.Time) bool {
+	local := t.In(c.location)
+	if c.open != nil && !c.open[local.Weekday()] {
+		return false
+	}
+
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	for _, closure := range c.closures {
+		if covers(&closure, day) {
+			return false
+		}
+	}
+	return true
+}
+
+// * the same time of day on the first open day from t on, t itself when the library never opens
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	local := t.In(c.location)
+	for i := 0; i < searchDays; i++ {
+		day := local.AddDate(0, 0, i)
+		if c.IsOpen(day) {
+			return day
+		}
+	}
+	return t
+}
+
+// * day is midnight UTC of a date like the dates of the closure
+func covers(closure *types.Closure, day time.Time) bool {
+	if !closure.Yearly {
+		return !day.Before(closure.StartDate) && !day.After(closure.EndDate)
+	}
+
+	// * a closure over new year started in the year before
+	length := closure.EndDate.Sub(closure.StartDate)
+	for _, year := range []int{day.Year(), day.Year() - 1} {
+		start := time.Date(year, closure.StartDate.Month(), closure.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+		if !day.Before(start) && !day.After(start.Add(length)) {
+			return true
+		}
+	}
+	return false
+}
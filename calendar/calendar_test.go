@@ -0,0 +1,150 @@
+package calendar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestCalendar(t *testing.T) {
+	weekdays := []types.OpeningHours{
+		{Weekday: time.Monday, Opens: "09:00", Closes: "18:00"},
+		{Weekday: time.Tuesday, Opens: "09:00", Closes: "18:00"},
+		{Weekday: time.Wednesday, Opens: "09:00", Closes: "18:00"},
+		{Weekday: time.Thursday, Opens: "09:00", Closes: "18:00"},
+		{Weekday: time.Friday, Opens: "09:00", Closes: "18:00"},
+	}
+	closures := []types.Closure{
+		{Name: "christmas", StartDate: date(2000, 12, 24), EndDate: date(2000, 12, 26), Yearly: true},
+		{Name: "new year", StartDate: date(2000, 12, 31), EndDate: date(2001, 1, 1), Yearly: true},
+		{Name: "renovation", Kind: types.TemporaryClosure, StartDate: date(2024, 3, 4), EndDate: date(2024, 3, 6)},
+	}
+	cal := New(time.UTC, weekdays, closures)
+
+	t.Run("ClosedOnDaysWithoutHours", func(t *testing.T) {
+		assert.True(t, cal.IsOpen(date(2024, 3, 1)))
+		assert.False(t, cal.IsOpen(date(2024, 3, 2)))
+		assert.False(t, cal.IsOpen(date(2024, 3, 3)))
+	})
+
+	t.Run("ClosedDuringClosures", func(t *testing.T) {
+		assert.False(t, cal.IsOpen(date(2024, 3, 4)))
+		assert.False(t, cal.IsOpen(date(2024, 3, 6)))
+		assert.True(t, cal.IsOpen(date(2024, 3, 7)))
+	})
+
+	t.Run("YearlyClosuresRepeat", func(t *testing.T) {
+		assert.False(t, cal.IsOpen(date(2025, 12, 25)))
+		assert.False(t, cal.IsOpen(date(2025, 12, 31)))
+		assert.False(t, cal.IsOpen(date(2026, 1, 1)))
+		assert.True(t, cal.IsOpen(date(2026, 1, 2)))
+	})
+
+	t.Run("NextOpenKeepsTheTimeOfDay", func(t *testing.T) {
+		saturday := time.Date(2024, 3, 2, 15, 30, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2024, 3, 7, 15, 30, 0, 0, time.UTC), cal.NextOpen(saturday))
+
+		friday := time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC)
+		assert.Equal(t, friday, cal.NextOpen(friday))
+	})
+
+	t.Run("DaysAreCountedInTheLibraryTimeZone", func(t *testing.T) {
+		location := time.FixedZone("UTC+3", 3*60*60)
+		local := New(location, weekdays, nil)
+
+		// * late friday in UTC is already saturday at the library
+		friday := time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)
+		assert.False(t, local.IsOpen(friday))
+		assert.Equal(t, time.Date(2024, 3, 4, 1, 0, 0, 0, location), local.NextOpen(friday))
+	})
+
+	t.Run("EveryDayIsOpenWithoutHours", func(t *testing.T) {
+		open := New(time.UTC, nil, nil)
+		assert.True(t, open.IsOpen(date(2024, 3, 2)))
+	})
+
+	t.Run("NeverOpenLeavesTheDateAlone", func(t *testing.T) {
+		closed := New(time.UTC, nil, []types.Closure{{StartDate: date(2024, 1, 1), EndDate: date(2026, 1, 1)}})
+		assert.Equal(t, date(2024, 3, 2), closed.NextOpen(date(2024, 3, 2)))
+	})
+}
+
+const holidays = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:christmas@example.com\r\n" +
+	"DTSTART;VALUE=DATE:20241224\r\n" +
+	"DTEND;VALUE=DATE:20241227\r\n" +
+	"SUMMARY:Christmas\\, and boxing day\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:training@example.com\r\n" +
+	"DTSTART;TZID=Europe/Vilnius:20240315T090000\r\n" +
+	"DTEND;TZID=Europe/Vilnius:20240315T170000\r\n" +
+	"SUMMARY:Staff train\r\n" +
+	" ing\r\n" +
+	"CATEGORIES:CLOSURE\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestImport(t *testing.T) {
+	closures, err := Import(strings.NewReader(holidays))
+	assert.NoError(t, err)
+
+	if assert.Len(t, closures, 2) {
+		assert.Equal(t, "christmas@example.com", closures[0].UID)
+		assert.Equal(t, "Christmas, and boxing day", closures[0].Name)
+		assert.Equal(t, date(2024, 12, 24), closures[0].StartDate)
+		assert.Equal(t, date(2024, 12, 26), closures[0].EndDate)
+		assert.Equal(t, types.HolidayClosure, closures[0].Kind)
+		assert.True(t, closures[0].Yearly)
+
+		assert.Equal(t, "Staff training", closures[1].Name)
+		assert.Equal(t, date(2024, 3, 15), closures[1].StartDate)
+		assert.Equal(t, date(2024, 3, 15), closures[1].EndDate)
+		assert.Equal(t, types.TemporaryClosure, closures[1].Kind)
+		assert.False(t, closures[1].Yearly)
+	}
+
+	t.Run("RefusesOtherRecurrence", func(t *testing.T) {
+		weekly := strings.Replace(holidays, "FREQ=YEARLY", "FREQ=WEEKLY", 1)
+		_, err := Import(strings.NewReader(weekly))
+		assert.Error(t, err)
+	})
+
+	t.Run("RefusesEventsWithoutStart", func(t *testing.T) {
+		_, err := Import(strings.NewReader("BEGIN:VEVENT\r\nSUMMARY:when\r\nEND:VEVENT\r\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestExport(t *testing.T) {
+	closures := []types.Closure{
+		{UID: "christmas@example.com", Kind: types.HolidayClosure, Name: "Christmas, and boxing day", StartDate: date(2024, 12, 24), EndDate: date(2024, 12, 26), Yearly: true},
+		{UID: "long@example.com", Kind: types.TemporaryClosure, Name: strings.Repeat("renovation of the reading room ", 5), StartDate: date(2024, 3, 4), EndDate: date(2024, 3, 4)},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Export(&buf, closures, date(2024, 1, 1)))
+
+	t.Run("FoldsLongLines", func(t *testing.T) {
+		for _, line := range strings.Split(buf.String(), "\r\n") {
+			assert.LessOrEqual(t, len(line), icalLineSize)
+		}
+	})
+
+	t.Run("ImportsBackTheSameClosures", func(t *testing.T) {
+		imported, err := Import(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, closures, imported)
+	})
+}
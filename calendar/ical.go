@@ -0,0 +1,220 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/google/uuid"
+)
+
+const (
+	icalDate     = "20060102"
+	icalDateTime = "20060102T150405"
+	icalLineSize = 75 // * octets, longer lines are folded
+)
+
+// * the closures of an iCalendar file, every all-day or timed event closes the days it touches,
+// * a yearly rule makes a yearly closure and other rules are refused
+func Import(r io.Reader) ([]types.Closure, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		closures []types.Closure
+		event    *types.Closure
+		endSet   bool
+	)
+	for n, line := range lines {
+		name, params, value := splitLine(line)
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &types.Closure{Kind: types.HolidayClosure}
+			endSet = false
+		case event == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if event.StartDate.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no start date", n+1, event.Name)
+			}
+			// * a timed event ending at midnight of its start day still closes that day
+			if !endSet || event.EndDate.Before(event.StartDate) {
+				event.EndDate = event.StartDate
+			}
+			if event.UID == "" {
+				event.UID = uuid.NewString()
+			}
+			if event.Name == "" {
+				event.Name = "closed"
+			}
+			if err := event.Validate(); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			closures = append(closures, *event)
+			event = nil
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Name = unescape(value)
+		case name == "CATEGORIES":
+			for _, category := range strings.Split(unescape(value), ",") {
+				if strings.EqualFold(strings.TrimSpace(category), string(types.TemporaryClosure)) {
+					event.Kind = types.TemporaryClosure
+				}
+			}
+		case name == "DTSTART":
+			start, _, err := parseDate(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			event.StartDate = start
+		case name == "DTEND":
+			end, exclusive, err := parseDate(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			if exclusive {
+				end = end.AddDate(0, 0, -1)
+			}
+			event.EndDate = end
+			endSet = true
+		case name == "RRULE":
+			if !strings.Contains(strings.ToUpper(value), "FREQ=YEARLY") {
+				return nil, fmt.Errorf("line %d: only yearly recurrence is supported", n+1)
+			}
+			event.Yearly = true
+		}
+	}
+	return closures, nil
+}
+
+// * writes the closures as all-day events, importing the file again updates them instead of adding new ones
+func Export(w io.Writer, closures []types.Closure, now time.Time) error {
+	bw := bufio.NewWriter(w)
+
+	write := func(line string) {
+		bw.WriteString(fold(line))
+	}
+
+	write("BEGIN:VCALENDAR")
+	write("VERSION:2.0")
+	write("PRODID:-//go-library-project//opening calendar//EN")
+	write("CALSCALE:GREGORIAN")
+
+	stamp := now.UTC().Format(icalDateTime) + "Z"
+	for _, closure := range closures {
+		write("BEGIN:VEVENT")
+		write("UID:" + closure.UID)
+		write("DTSTAMP:" + stamp)
+		write("DTSTART;VALUE=DATE:" + closure.StartDate.Format(icalDate))
+		write("DTEND;VALUE=DATE:" + closure.EndDate.AddDate(0, 0, 1).Format(icalDate))
+		write("SUMMARY:" + escape(closure.Name))
+		write("CATEGORIES:" + string(closure.Kind))
+		if closure.Yearly {
+			write("RRULE:FREQ=YEARLY")
+		}
+		write("TRANSP:TRANSPARENT")
+		write("END:VEVENT")
+	}
+
+	write("END:VCALENDAR")
+	return bw.Flush()
+}
+
+// * joins the continuation lines, which start with a space or a tab, to the line before them
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// * "DTSTART;VALUE=DATE:20241225" is the name DTSTART, the parameter VALUE=DATE and the value 20241225
+func splitLine(line string) (string, map[string]string, string) {
+	head, value, _ := strings.Cut(line, ":")
+
+	parts := strings.Split(head, ";")
+	params := make(map[string]string)
+	for _, param := range parts[1:] {
+		key, val, _ := strings.Cut(param, "=")
+		params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// * the date the event starts or ends on, in the time zone the file gives for it,
+// * exclusive tells that an end on that date is the first moment after the event, like the end of an all-day event
+func parseDate(params map[string]string, value string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(icalDate) {
+		date, err := time.Parse(icalDate, value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+		}
+		return date, true, nil
+	}
+
+	location := time.UTC
+	if strings.HasSuffix(value, "Z") {
+		value = strings.TrimSuffix(value, "Z")
+	} else if name, ok := params["TZID"]; ok {
+		if found, err := time.LoadLocation(name); err == nil {
+			location = found
+		}
+	}
+
+	moment, err := time.ParseInLocation(icalDateTime, value, location)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+	}
+	midnight := moment.Hour() == 0 && moment.Minute() == 0 && moment.Second() == 0
+	return time.Date(moment.Year(), moment.Month(), moment.Day(), 0, 0, 0, 0, time.UTC), midnight, nil
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+func escape(text string) string {
+	return escaper.Replace(text)
+}
+
+func unescape(text string) string {
+	return unescaper.Replace(text)
+}
+
+// * breaks the line every 75 octets without splitting a character, every line ends with CRLF
+func fold(line string) string {
+	var b strings.Builder
+
+	size := 0
+	for _, r := range line {
+		width := len(string(r))
+		if size+width > icalLineSize {
+			b.WriteString("\r\n ")
+			size = 1
+		}
+		b.WriteRune(r)
+		size += width
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
@@ -0,0 +1,785 @@
+package circulation
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func setupTestDB() *gorm.DB {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", os.Getenv("DB_TEST_HOST"), os.Getenv("DB_TEST_PORT"), os.Getenv("DB_TEST_USER"), os.Getenv("DB_TEST_PASSWORD"), os.Getenv("DB_TEST_NAME"))
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		log.Fatalf("failed to connect to test database: %v", err)
+	}
+
+	db.AutoMigrate(&types.Role{})
+	db.FirstOrCreate(&types.Role{ID: types.MemberRoleID, Name: "member", Permissions: types.MemberPermissions, Builtin: true})
+	db.AutoMigrate(&types.Branch{})
+	db.FirstOrCreate(&types.Branch{ID: types.MainBranchID, Name: "main"})
+	db.Exec("SELECT setval(pg_get_serial_sequence('branches', 'id'), (SELECT MAX(id) FROM branches))")
+	db.AutoMigrate(&types.User{}, &types.Item{}, &types.Kind{}, &types.Copy{}, &types.Hold{}, &types.Loan{}, &types.Fine{}, &types.Notification{}, &types.AuditEntry{}, &types.CirculationRule{}, &types.OpeningHours{}, &types.Closure{})
+
+	return db
+}
+
+func TestMain(m *testing.M) {
+	if err := godotenv.Load("../.env"); err != nil {
+		log.Fatalf("error loading .env file: %v", err)
+	}
+
+	set := setupTestDB()
+	exitCode := m.Run()
+
+	if sqlDB, err := set.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "error closing test database: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "error getting underlying database connection: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(exitCode)
+}
+
+const testActor = "test_circulation_actor"
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+// * the rows one test created, removed again when it ends
+type fixture struct {
+	t         *testing.T
+	db        *gorm.DB
+	itemIDs   []uint
+	userIDs   []string
+	ruleIDs   []uint
+	kindIDs   []uint
+	branchIDs []uint
+}
+
+func newFixture(t *testing.T, db *gorm.DB) *fixture {
+	f := &fixture{t: t, db: db}
+	t.Cleanup(f.cleanup)
+	return f
+}
+
+func (f *fixture) item(copies int) *types.Item {
+	item := types.Item{Title: "test_circulation_item"}
+	if err := f.db.Omit("Copies").Create(&item).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	f.itemIDs = append(f.itemIDs, item.ID)
+
+	for n := 1; n <= copies; n++ {
+		cp := types.Copy{ItemID: item.ID, Barcode: fmt.Sprintf("TEST-CIRC-%d-%d", item.ID, n), Status: types.CopyAvailable}
+		if err := f.db.Create(&cp).Error; err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	return &item
+}
+
+func (f *fixture) users(n int) []types.User {
+	users := make([]types.User, n)
+	for i := range users {
+		id := fmt.Sprintf("test_circ_%d_%d", time.Now().UnixNano(), i)
+		users[i] = types.User{ID: id, Username: id, Email: id + "@test.com", LibraryCard: id, RoleID: types.MemberRoleID, Verified: time.Now().Format(time.RFC3339)}
+		if err := f.db.Omit("Role").Create(&users[i]).Error; err != nil {
+			f.t.Fatal(err)
+		}
+		f.userIDs = append(f.userIDs, id)
+	}
+	return users
+}
+
+func (f *fixture) kind() *types.Kind {
+	kind := types.Kind{Name: "test_circulation_kind"}
+	if err := f.db.Create(&kind).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	f.kindIDs = append(f.kindIDs, kind.ID)
+	return &kind
+}
+
+func (f *fixture) branch() *types.Branch {
+	branch := types.Branch{Name: fmt.Sprintf("test_branch_%d", time.Now().UnixNano())}
+	if err := f.db.Create(&branch).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	f.branchIDs = append(f.branchIDs, branch.ID)
+	return &branch
+}
+
+func (f *fixture) rule(rule types.CirculationRule) {
+	if err := f.db.Create(&rule).Error; err != nil {
+		f.t.Fatal(err)
+	}
+	f.ruleIDs = append(f.ruleIDs, rule.ID)
+}
+
+func (f *fixture) cleanup() {
+	f.db.Where("id IN ?", f.ruleIDs).Delete(&types.CirculationRule{})
+	f.db.Where("item_id IN ?", f.itemIDs).Delete(&types.Loan{})
+	f.db.Where("item_id IN ?", f.itemIDs).Delete(&types.Hold{})
+	f.db.Where("item_id IN ?", f.itemIDs).Delete(&types.Copy{})
+	f.db.Exec("DELETE FROM item_kinds WHERE item_id IN ?", f.itemIDs)
+	f.db.Where("id IN ?", f.itemIDs).Delete(&types.Item{})
+	f.db.Where("id IN ?", f.kindIDs).Delete(&types.Kind{})
+	f.db.Where("user_id IN ?", f.userIDs).Delete(&types.Fine{})
+	f.db.Where("user_id IN ?", f.userIDs).Delete(&types.Notification{})
+	f.db.Where("id IN ?", f.userIDs).Delete(&types.User{})
+	f.db.Where("id IN ?", f.branchIDs).Delete(&types.Branch{})
+	f.db.Where("actor_id = ?", testActor).Delete(&types.AuditEntry{})
+}
+
+// * starts every call at once and waits for all of them
+func hammer(n int, op func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = op(i)
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// * every error must be a refusal of the given kind, anything else means the locking broke
+func countSucceeded(t *testing.T, errs []error, refusal ErrorKind) int {
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.Equal(t, refusal, KindOf(err), err.Error())
+	}
+	return succeeded
+}
+
+func holdsOf(t *testing.T, db *gorm.DB, itemID uint) []types.Hold {
+	var holds []types.Hold
+	if err := db.Where("item_id = ?", itemID).Order("in_line_position").Find(&holds).Error; err != nil {
+		t.Fatal(err)
+	}
+	return holds
+}
+
+func copiesWithStatus(t *testing.T, db *gorm.DB, itemID uint, status types.CopyStatus) int64 {
+	var count int64
+	if err := db.Model(&types.Copy{}).Where("item_id = ? AND status = ?", itemID, status).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// * every hold has its own place in line and every shelved copy belongs to exactly one available hold
+func assertHoldInvariants(t *testing.T, db *gorm.DB, itemID uint) {
+	holds := holdsOf(t, db, itemID)
+
+	shelved := make(map[uint]bool)
+	available := 0
+	for i, hold := range holds {
+		assert.Equal(t, uint(i+1), hold.InLinePosition)
+
+		if hold.IsAvailable {
+			available++
+			if assert.NotNil(t, hold.CopyID) {
+				assert.False(t, shelved[*hold.CopyID], "copy %d is shelved for two holds", *hold.CopyID)
+				shelved[*hold.CopyID] = true
+			}
+		}
+	}
+
+	assert.Equal(t, int64(available), copiesWithStatus(t, db, itemID, types.CopyOnHoldShelf))
+}
+
+func TestConcurrentCheckout(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	item := f.item(3)
+	users := f.users(12)
+
+	errs := hammer(len(users), func(i int) error {
+		_, err := service.Checkout(users[i].ID, item.ID, 0, types.Actor{})
+		return err
+	})
+
+	t.Run("NeverLendsMoreCopiesThanThereAre", func(t *testing.T) {
+		assert.Equal(t, 3, countSucceeded(t, errs, Conflict))
+
+		var loans []types.Loan
+		assert.NoError(t, set.Where("item_id = ?", item.ID).Find(&loans).Error)
+		assert.Len(t, loans, 3)
+
+		lent := make(map[uint]bool)
+		for _, loan := range loans {
+			assert.False(t, lent[loan.CopyID], "copy %d is lent twice", loan.CopyID)
+			lent[loan.CopyID] = true
+		}
+		assert.Equal(t, int64(3), copiesWithStatus(t, set, item.ID, types.CopyOnLoan))
+	})
+}
+
+func TestConcurrentDeleteCopyAndCheckout(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	for round := 0; round < 5; round++ {
+		item := f.item(1)
+		users := f.users(1)
+
+		var cp types.Copy
+		if err := set.Where("item_id = ?", item.ID).First(&cp).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		errs := hammer(2, func(i int) error {
+			if i == 0 {
+				return service.DeleteCopy(cp.ID, types.Actor{})
+			}
+			_, err := service.Checkout(users[0].ID, item.ID, 0, types.Actor{})
+			return err
+		})
+
+		// * whichever comes second finds the copy gone or lent
+		if errs[0] == nil {
+			assert.Equal(t, Conflict, KindOf(errs[1]))
+		} else {
+			assert.Equal(t, Invalid, KindOf(errs[0]))
+			assert.NoError(t, errs[1])
+		}
+
+		var loans []types.Loan
+		assert.NoError(t, set.Where("item_id = ?", item.ID).Find(&loans).Error)
+		for _, loan := range loans {
+			var lent types.Copy
+			assert.NoError(t, set.First(&lent, loan.CopyID).Error, "the lent copy was deleted")
+		}
+	}
+}
+
+func TestConcurrentLoanLimit(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	user := f.users(1)[0]
+	items := make([]*types.Item, DefaultPolicy().MaxLoans+5)
+	for i := range items {
+		items[i] = f.item(1)
+	}
+
+	errs := hammer(len(items), func(i int) error {
+		_, err := service.Checkout(user.ID, items[i].ID, 0, types.Actor{})
+		return err
+	})
+
+	assert.Equal(t, DefaultPolicy().MaxLoans, countSucceeded(t, errs, Invalid))
+}
+
+func TestConcurrentPlaceHold(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	item := f.item(1)
+	users := f.users(12)
+
+	errs := hammer(len(users), func(i int) error {
+		_, err := service.PlaceHold(&users[i], item.ID, 0)
+		return err
+	})
+
+	t.Run("EveryHoldGetsItsOwnPlaceInLine", func(t *testing.T) {
+		assert.Equal(t, len(users), countSucceeded(t, errs, Conflict))
+		assert.Len(t, holdsOf(t, set, item.ID), len(users))
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("OnlyOneHoldGetsTheCopy", func(t *testing.T) {
+		available := 0
+		for _, hold := range holdsOf(t, set, item.ID) {
+			if hold.IsAvailable {
+				available++
+			}
+		}
+		assert.Equal(t, 1, available)
+	})
+
+	t.Run("RefusesTheSameHoldTwice", func(t *testing.T) {
+		errs := hammer(5, func(i int) error {
+			_, err := service.PlaceHold(&users[0], item.ID, 0)
+			return err
+		})
+		assert.Equal(t, 0, countSucceeded(t, errs, Conflict))
+	})
+}
+
+func TestConcurrentHoldLimit(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	user := f.users(1)[0]
+	items := make([]*types.Item, DefaultPolicy().MaxHolds+5)
+	for i := range items {
+		items[i] = f.item(0)
+	}
+
+	errs := hammer(len(items), func(i int) error {
+		_, err := service.PlaceHold(&user, items[i].ID, 0)
+		return err
+	})
+
+	assert.Equal(t, DefaultPolicy().MaxHolds, countSucceeded(t, errs, Invalid))
+}
+
+func TestConcurrentReturnAndResolve(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+	actor := types.Actor{UserID: testActor}
+
+	item := f.item(2)
+	users := f.users(6)
+
+	var loans []*types.Loan
+	for _, user := range users[:2] {
+		loan, err := service.Checkout(user.ID, item.ID, 0, types.Actor{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		loans = append(loans, loan)
+	}
+
+	for i := range users[2:] {
+		if _, err := service.PlaceHold(&users[2+i], item.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("ReturnsEachLoanOnce", func(t *testing.T) {
+		errs := hammer(len(loans)*4, func(i int) error {
+			_, err := service.Return(loans[i%len(loans)].ID, actor)
+			return err
+		})
+
+		assert.Equal(t, len(loans), countSucceeded(t, errs, NotFound))
+		assert.Equal(t, int64(0), copiesWithStatus(t, set, item.ID, types.CopyOnLoan))
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("ResolvesEachHoldOnce", func(t *testing.T) {
+		var available []types.Hold
+		for _, hold := range holdsOf(t, set, item.ID) {
+			if hold.IsAvailable {
+				available = append(available, hold)
+			}
+		}
+		if !assert.Len(t, available, 2) {
+			return
+		}
+
+		errs := hammer(len(available)*4, func(i int) error {
+			_, err := service.ResolveHold(available[i%len(available)].ID, actor)
+			return err
+		})
+
+		assert.Equal(t, len(available), countSucceeded(t, errs, NotFound))
+		assert.Equal(t, int64(2), copiesWithStatus(t, set, item.ID, types.CopyOnLoan))
+		assert.Len(t, holdsOf(t, set, item.ID), 2)
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("AuditsEveryOperationOnce", func(t *testing.T) {
+		var count int64
+		assert.NoError(t, set.Model(&types.AuditEntry{}).Where("actor_id = ?", testActor).Count(&count).Error)
+		assert.Equal(t, int64(4), count)
+	})
+}
+
+func TestRenew(t *testing.T) {
+	set := setupTestDB()
+	clock := &fakeClock{now: time.Now()}
+	policy := DefaultPolicy()
+	service := NewService(types.NewStore(set), clock, policy, time.UTC)
+	f := newFixture(t, set)
+
+	item := f.item(1)
+	users := f.users(2)
+
+	loan, err := service.Checkout(users[0].ID, item.ID, 0, types.Actor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OnlyTheBorrowerRenews", func(t *testing.T) {
+		_, err := service.Renew(loan.ID, users[1].ID)
+		assert.Equal(t, Forbidden, KindOf(err))
+	})
+
+	t.Run("RefusesBeforeTheRenewalWindow", func(t *testing.T) {
+		_, err := service.Renew(loan.ID, users[0].ID)
+		assert.Equal(t, Invalid, KindOf(err))
+	})
+
+	t.Run("ProlongsByOneLoanPeriod", func(t *testing.T) {
+		clock.now = loan.RenewableOn.Add(time.Hour)
+
+		renewed, err := service.Renew(loan.ID, users[0].ID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint(1), renewed.Renewals)
+			assert.WithinDuration(t, loan.ExpireDate.Add(policy.LoanPeriod), renewed.ExpireDate, time.Second)
+			assert.WithinDuration(t, renewed.ExpireDate.Add(-policy.RenewalWindow), renewed.RenewableOn, time.Second)
+			loan = renewed
+		}
+	})
+
+	t.Run("StopsAtTheRenewalLimit", func(t *testing.T) {
+		other, err := service.Checkout(users[1].ID, f.item(1).ID, 0, types.Actor{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, policy.MaxRenewals, other.MaxRenewals)
+
+		for other.Renewals < other.MaxRenewals {
+			clock.now = other.RenewableOn.Add(time.Hour)
+
+			renewed, err := service.Renew(other.ID, users[1].ID)
+			if !assert.NoError(t, err) {
+				return
+			}
+			other = renewed
+		}
+
+		clock.now = other.RenewableOn.Add(time.Hour)
+		_, err = service.Renew(other.ID, users[1].ID)
+		assert.Equal(t, Invalid, KindOf(err))
+	})
+
+	t.Run("RefusesWhileSomebodyWaits", func(t *testing.T) {
+		clock.now = loan.RenewableOn.Add(time.Hour)
+		if _, err := service.PlaceHold(&users[1], item.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := service.Renew(loan.ID, users[0].ID)
+		assert.Equal(t, Conflict, KindOf(err))
+	})
+}
+
+func TestCancelHold(t *testing.T) {
+	set := setupTestDB()
+	clock := &fakeClock{now: time.Now()}
+	policy := DefaultPolicy()
+	service := NewService(types.NewStore(set), clock, policy, time.UTC)
+	f := newFixture(t, set)
+
+	item := f.item(1)
+	users := f.users(2)
+
+	first, err := service.PlaceHold(&users[0], item.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.PlaceHold(&users[1], item.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("RefusesHoldsOfOtherPatrons", func(t *testing.T) {
+		assert.Equal(t, Forbidden, KindOf(service.CancelHold(first.ID, &users[1])))
+		assert.Len(t, holdsOf(t, set, item.ID), 2)
+	})
+
+	t.Run("HandsTheCopyToTheNextInLine", func(t *testing.T) {
+		clock.now = clock.now.Add(time.Hour)
+		assert.NoError(t, service.CancelHold(first.ID, &users[0]))
+
+		holds := holdsOf(t, set, item.ID)
+		if assert.Len(t, holds, 1) {
+			assert.Equal(t, second.ID, holds[0].ID)
+			assert.True(t, holds[0].IsAvailable)
+			assert.WithinDuration(t, clock.now.Add(policy.PickupPeriod), holds[0].ExpiryDate, time.Second)
+		}
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("RefusesTheSameCancelTwice", func(t *testing.T) {
+		assert.Equal(t, NotFound, KindOf(service.CancelHold(first.ID, &users[0])))
+	})
+}
+
+func TestChangeDeliveryDate(t *testing.T) {
+	set := setupTestDB()
+	clock := &fakeClock{now: time.Now()}
+	service := NewService(types.NewStore(set), clock, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	item := f.item(1)
+	users := f.users(2)
+
+	first, err := service.PlaceHold(&users[0], item.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.PlaceHold(&users[1], item.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveryDate := clock.now.Add(7 * 24 * time.Hour)
+
+	t.Run("OnlyTheOwnerPostpones", func(t *testing.T) {
+		_, err := service.ChangeDeliveryDate(first.ID, users[1].ID, deliveryDate)
+		assert.Equal(t, Forbidden, KindOf(err))
+	})
+
+	t.Run("FreesTheCopyForTheNextInLine", func(t *testing.T) {
+		hold, err := service.ChangeDeliveryDate(first.ID, users[0].ID, deliveryDate)
+		if assert.NoError(t, err) {
+			assert.True(t, hold.IsPostponed)
+			assert.False(t, hold.IsAvailable)
+			assert.Nil(t, hold.CopyID)
+		}
+
+		holds := holdsOf(t, set, item.ID)
+		if assert.Len(t, holds, 2) {
+			assert.Equal(t, second.ID, holds[0].ID)
+			assert.True(t, holds[0].IsAvailable)
+		}
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("ReturnsToTheLineAfterTheDate", func(t *testing.T) {
+		clock.now = deliveryDate.Add(time.Hour)
+		assert.NoError(t, service.ReleasePostponedHolds(clock.now))
+
+		holds := holdsOf(t, set, item.ID)
+		if assert.Len(t, holds, 2) {
+			assert.Equal(t, first.ID, holds[1].ID)
+			assert.False(t, holds[1].IsPostponed)
+		}
+
+		assert.NoError(t, service.CancelHold(second.ID, &users[1]))
+
+		holds = holdsOf(t, set, item.ID)
+		if assert.Len(t, holds, 1) {
+			assert.True(t, holds[0].IsAvailable)
+		}
+		assertHoldInvariants(t, set, item.ID)
+	})
+}
+
+func uintPtr(n uint) *uint {
+	return &n
+}
+
+func TestPolicyMatrix(t *testing.T) {
+	dvd, book := uint(1), uint(2)
+	rules := newMatrix(DefaultPolicy(), []types.CirculationRule{
+		{PatronCategory: "child", KindID: &dvd, LoanDays: uintPtr(3)},
+		{KindID: &dvd, LoanDays: uintPtr(7), MaxRenewals: uintPtr(0)},
+		{PatronCategory: "child", MaxLoans: uintPtr(5), LoanDays: uintPtr(21)},
+		{MaxHolds: uintPtr(20)},
+	})
+
+	t.Run("FallsBackToTheDefaults", func(t *testing.T) {
+		policy := rules.forItem("adult", &types.Item{})
+		assert.Equal(t, DefaultPolicy().LoanPeriod, policy.LoanPeriod)
+		assert.Equal(t, DefaultPolicy().MaxLoans, policy.MaxLoans)
+		assert.Equal(t, 20, policy.MaxHolds)
+	})
+
+	t.Run("CategoryRulesApplyToEveryItem", func(t *testing.T) {
+		policy := rules.forItem("child", &types.Item{Kinds: []types.Kind{{ID: book}}})
+		assert.Equal(t, 5, policy.MaxLoans)
+		assert.Equal(t, 21*24*time.Hour, policy.LoanPeriod)
+		assert.Equal(t, 20, policy.MaxHolds)
+	})
+
+	t.Run("KindBeatsCategory", func(t *testing.T) {
+		policy := rules.forItem("adult", &types.Item{Kinds: []types.Kind{{ID: dvd}}})
+		assert.Equal(t, 7*24*time.Hour, policy.LoanPeriod)
+		assert.Equal(t, uint(0), policy.MaxRenewals)
+
+		policy = rules.forItem("child", &types.Item{Kinds: []types.Kind{{ID: dvd}}})
+		assert.Equal(t, 3*24*time.Hour, policy.LoanPeriod)
+		assert.Equal(t, 5, policy.MaxLoans)
+	})
+
+	t.Run("StrictestKindWins", func(t *testing.T) {
+		policy := rules.forItem("adult", &types.Item{Kinds: []types.Kind{{ID: book}, {ID: dvd}}})
+		assert.Equal(t, 7*24*time.Hour, policy.LoanPeriod)
+		assert.Equal(t, uint(0), policy.MaxRenewals)
+	})
+}
+
+func TestCheckoutFollowsThePolicy(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	kind := f.kind()
+	category := fmt.Sprintf("test_category_%d", time.Now().UnixNano())
+	f.rule(types.CirculationRule{PatronCategory: category, MaxLoans: uintPtr(1)})
+	f.rule(types.CirculationRule{PatronCategory: category, KindID: &kind.ID, LoanDays: uintPtr(7)})
+
+	user := f.users(1)[0]
+	if err := set.Model(&types.User{}).Where("id = ?", user.ID).Update("category", category).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*types.Item{f.item(1), f.item(1)}
+	if err := set.Model(items[0]).Association("Kinds").Append(kind); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("LendsForTheLoanPeriodOfTheKind", func(t *testing.T) {
+		loan, err := service.Checkout(user.ID, items[0].ID, 0, types.Actor{})
+		if assert.NoError(t, err) {
+			assert.WithinDuration(t, loan.CheckoutDate.Add(7*24*time.Hour), loan.ExpireDate, time.Second)
+		}
+	})
+
+	t.Run("KeepsToTheLoanLimitOfTheCategory", func(t *testing.T) {
+		_, err := service.Checkout(user.ID, items[1].ID, 0, types.Actor{})
+		assert.Equal(t, Invalid, KindOf(err))
+	})
+}
+
+func TestBranches(t *testing.T) {
+	set := setupTestDB()
+	service := NewService(types.NewStore(set), help.RealClock{}, DefaultPolicy(), time.UTC)
+	f := newFixture(t, set)
+
+	branch := f.branch()
+	item := f.item(1)
+	users := f.users(2)
+	barcode := fmt.Sprintf("TEST-CIRC-%d-%d", item.ID, 1)
+
+	mainBranch := types.MainBranchID
+	mainDesk := types.Actor{UserID: testActor, BranchID: &mainBranch}
+	branchDesk := types.Actor{UserID: testActor, BranchID: &branch.ID}
+
+	hold, err := service.PlaceHold(&users[0], item.ID, branch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("SendsTheCopyToThePickupBranch", func(t *testing.T) {
+		assert.False(t, hold.IsAvailable)
+		assert.NotNil(t, hold.CopyID)
+		assert.Equal(t, int64(1), copiesWithStatus(t, set, item.ID, types.CopyInTransit))
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("RefusesToLendTheCopyOnItsWay", func(t *testing.T) {
+		_, err := service.CheckoutCopy(barcode, users[1].LibraryCard, branchDesk)
+		assert.Equal(t, Conflict, KindOf(err))
+	})
+
+	t.Run("ShelvesTheCopyOnceItArrives", func(t *testing.T) {
+		res, err := service.CheckinCopy(barcode, branchDesk)
+		if assert.NoError(t, err) {
+			assert.Nil(t, res.Loan)
+			assert.Equal(t, types.ToHoldShelf, res.Destination)
+			if assert.NotNil(t, res.HoldID) {
+				assert.Equal(t, hold.ID, *res.HoldID)
+			}
+		}
+
+		holds := holdsOf(t, set, item.ID)
+		if assert.Len(t, holds, 1) {
+			assert.True(t, holds[0].IsAvailable)
+		}
+		assertHoldInvariants(t, set, item.ID)
+	})
+
+	t.Run("OnlyThePickupBranchHandsItOut", func(t *testing.T) {
+		_, err := service.ResolveHold(hold.ID, mainDesk)
+		assert.Equal(t, Forbidden, KindOf(err))
+
+		_, err = service.ResolveHold(hold.ID, branchDesk)
+		assert.NoError(t, err)
+	})
+
+	t.Run("SendsTheCopyHomeWhenReturnedElsewhere", func(t *testing.T) {
+		res, err := service.CheckinCopy(barcode, branchDesk)
+		if assert.NoError(t, err) {
+			assert.NotNil(t, res.Loan)
+			assert.Equal(t, types.ToTransit, res.Destination)
+			if assert.NotNil(t, res.BranchID) {
+				assert.Equal(t, types.MainBranchID, *res.BranchID)
+			}
+		}
+	})
+
+	t.Run("LendsOnlyTheCopiesOfTheBranch", func(t *testing.T) {
+		_, err := service.CheckinCopy(barcode, mainDesk)
+		assert.NoError(t, err)
+
+		_, err = service.Checkout(users[1].ID, item.ID, 0, branchDesk)
+		assert.Equal(t, Conflict, KindOf(err))
+
+		_, err = service.Checkout(users[1].ID, item.ID, 0, mainDesk)
+		assert.NoError(t, err)
+	})
+
+	t.Run("OnlyTheLendingBranchReturnsTheLoan", func(t *testing.T) {
+		var loan types.Loan
+		if err := set.Where("item_id = ?", item.ID).First(&loan).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := service.Return(loan.ID, branchDesk)
+		assert.Equal(t, Forbidden, KindOf(err))
+
+		_, err = service.Return(loan.ID, mainDesk)
+		assert.NoError(t, err)
+	})
+
+	t.Run("OnlyThePickupBranchCancelsForThePatron", func(t *testing.T) {
+		hold, err := service.PlaceHold(&users[1], item.ID, branch.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		role := &types.Role{Permissions: []types.Permission{types.HoldsManage}}
+		mainStaff := &types.User{ID: testActor, Role: role, BranchID: &mainBranch}
+		branchStaff := &types.User{ID: testActor, Role: role, BranchID: &branch.ID}
+
+		assert.Equal(t, Forbidden, KindOf(service.CancelHold(hold.ID, mainStaff)))
+		assert.NoError(t, service.CancelHold(hold.ID, branchStaff))
+		assert.Empty(t, holdsOf(t, set, item.ID))
+	})
+}
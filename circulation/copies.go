@@ -0,0 +1,185 @@
+package circulation
+
+import (
+	"errors"
+
+	"github.com/gimtwi/go-library-project/types"
+	"gorm.io/gorm"
+)
+
+// * staff of one branch only add copies to it, a new copy starts on the shelves of the branch owning it
+// * and can go straight to the first hold in line
+func (s *Service) CreateCopy(cp types.Copy, actor types.Actor) (*types.Copy, error) {
+	var created *types.Copy
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		if err := lock(tx, "", cp.ItemID); err != nil {
+			return err
+		}
+
+		if cp.HomeBranchID == 0 {
+			cp.HomeBranchID = types.MainBranchID
+			if actor.BranchID != nil {
+				cp.HomeBranchID = *actor.BranchID
+			}
+		}
+
+		if !actor.WorksAt(cp.HomeBranchID) {
+			return errorf(Forbidden, "you can only add copies to your branch")
+		}
+
+		if _, err := tx.Branches.GetByID(cp.HomeBranchID); err != nil {
+			return errorf(NotFound, "branch not found")
+		}
+		cp.BranchID = cp.HomeBranchID
+
+		if err := checkBarcode(tx, cp.Barcode); err != nil {
+			return err
+		}
+
+		if cp.Status == "" {
+			cp.Status = types.CopyAvailable
+		}
+
+		if !isManualCopyStatus(cp.Status) {
+			return errorf(Invalid, "invalid copy status")
+		}
+
+		if err := tx.Copies.Create(&cp); err != nil {
+			return err
+		}
+
+		if err := s.rearrange(tx, cp.ItemID, s.clock.Now()); err != nil {
+			return err
+		}
+
+		var err error
+		created, err = tx.Copies.GetByID(cp.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// * staff of one branch only change the copies it owns, where a copy is shelved changes at the desk
+func (s *Service) UpdateCopy(copyID uint, req types.Copy, actor types.Actor) (*types.Copy, error) {
+	var updated *types.Copy
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		cp, err := tx.Copies.GetByID(copyID)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		if err := lock(tx, "", cp.ItemID); err != nil {
+			return err
+		}
+
+		// * the copy may have been lent or shelved for a hold while waiting for the lock
+		cp, err = tx.Copies.GetByID(copyID)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		if !actor.WorksAt(cp.HomeBranchID) {
+			return errorf(Forbidden, "copy belongs to another branch")
+		}
+
+		// * the copy goes to its new branch the next time it's checked in
+		if req.HomeBranchID != 0 && req.HomeBranchID != cp.HomeBranchID {
+			if !actor.WorksAt(req.HomeBranchID) {
+				return errorf(Forbidden, "you can't give copies to another branch")
+			}
+
+			if _, err := tx.Branches.GetByID(req.HomeBranchID); err != nil {
+				return errorf(NotFound, "branch not found")
+			}
+			cp.HomeBranchID = req.HomeBranchID
+		}
+
+		if req.Barcode != cp.Barcode {
+			if err := checkBarcode(tx, req.Barcode); err != nil {
+				return err
+			}
+		}
+
+		if req.Status != "" && req.Status != cp.Status {
+			if !isManualCopyStatus(req.Status) {
+				return errorf(Invalid, "invalid copy status")
+			}
+
+			if cp.Status == types.CopyOnLoan {
+				return errorf(Invalid, "copy is on loan, return it first")
+			}
+			cp.Status = req.Status
+		}
+
+		cp.Barcode = req.Barcode
+		cp.Condition = req.Condition
+		cp.ShelfLocation = req.ShelfLocation
+
+		if err := tx.Copies.Update(cp); err != nil {
+			return err
+		}
+
+		if err := s.rearrange(tx, cp.ItemID, s.clock.Now()); err != nil {
+			return err
+		}
+
+		updated, err = tx.Copies.GetByID(cp.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// * a copy on loan has to be returned first, a hold it waited for gets another copy if there is one
+func (s *Service) DeleteCopy(copyID uint, actor types.Actor) error {
+	return s.store.Transaction(func(tx *types.Store) error {
+		cp, err := tx.Copies.GetByID(copyID)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		if err := lock(tx, "", cp.ItemID); err != nil {
+			return err
+		}
+
+		// * a parallel checkout may have lent it while waiting for the lock
+		cp, err = tx.Copies.GetByID(copyID)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		if !actor.WorksAt(cp.HomeBranchID) {
+			return errorf(Forbidden, "copy belongs to another branch")
+		}
+
+		if cp.Status == types.CopyOnLoan {
+			return errorf(Invalid, "copy is on loan, return it first")
+		}
+
+		if err := tx.Copies.Delete(cp.ID); err != nil {
+			return err
+		}
+		return s.rearrange(tx, cp.ItemID, s.clock.Now())
+	})
+}
+
+func checkBarcode(tx *types.Store, barcode string) error {
+	if _, err := tx.Copies.GetByBarcode(barcode); err == nil {
+		return errorf(Conflict, "barcode is already in use")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// * loans and holds move copies on loan and to the hold shelf
+func isManualCopyStatus(status types.CopyStatus) bool {
+	return status == types.CopyAvailable || status == types.CopyLost || status == types.CopyWithdrawn
+}
@@ -0,0 +1,38 @@
+package circulation
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorKind int
+
+const (
+	NotFound  ErrorKind = iota + 1 // * the hold, loan, item or patron doesn't exist
+	Forbidden                      // * the patron isn't allowed to borrow right now
+	Conflict                       // * the state of the item doesn't allow it
+	Invalid                        // * the request breaks a rule of the library
+)
+
+// * an operation refused by the rules of the library, the message is meant for whoever asked
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// * the kind of a refusal, zero for unexpected errors
+func KindOf(err error) ErrorKind {
+	var refused *Error
+	if errors.As(err, &refused) {
+		return refused.Kind
+	}
+	return 0
+}
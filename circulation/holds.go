@@ -0,0 +1,567 @@
+package circulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gimtwi/go-library-project/calendar"
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/notify"
+	"github.com/gimtwi/go-library-project/types"
+	"gorm.io/gorm"
+)
+
+// * patrons pick their holds up at their home branch unless they choose another one
+func (s *Service) PlaceHold(user *types.User, itemID, pickupBranchID uint) (*types.Hold, error) {
+	if err := help.CheckVerifiedEmail(user); err != nil {
+		return nil, errorf(Forbidden, err.Error())
+	}
+
+	var hold types.Hold
+	err := s.store.Transaction(func(tx *types.Store) error {
+		if err := lock(tx, user.ID, itemID); err != nil {
+			return err
+		}
+
+		rules, err := s.loadRules(tx)
+		if err != nil {
+			return err
+		}
+
+		item, err := tx.Items.GetByID(itemID)
+		if err != nil {
+			return err
+		}
+
+		policy := rules.forItem(user.Category, item)
+		if err := checkFineBalance(tx, user.ID, policy); err != nil {
+			return err
+		}
+
+		if pickupBranchID == 0 {
+			pickupBranchID = types.MainBranchID
+			if user.BranchID != nil {
+				pickupBranchID = *user.BranchID
+			}
+		}
+
+		if _, err := tx.Branches.GetByID(pickupBranchID); err != nil {
+			return errorf(NotFound, "pickup branch not found")
+		}
+
+		userHolds, err := tx.Holds.GetByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range userHolds {
+			if h.ItemID == itemID {
+				return errorf(Conflict, "user has already placed a hold on this item")
+			}
+		}
+
+		if len(userHolds) >= policy.MaxHolds {
+			return errorf(Invalid, "users are not allowed to have more than %d holds at the time", policy.MaxHolds)
+		}
+
+		holds, err := tx.Holds.GetByItemID(itemID)
+		if err != nil {
+			return err
+		}
+
+		copies, err := countCirculatingCopies(tx, itemID)
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		hold = types.Hold{ItemID: itemID, UserID: user.ID, PickupBranchID: pickupBranchID, PlacedDate: now, DeliveryDate: now}
+		hold.InLinePosition = uint(len(holds) + 1)
+
+		// * a free copy goes straight to the new hold, one at the pickup branch saves a trip
+		cp, err := tx.Copies.GetFirstAvailable(itemID, &pickupBranchID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cp, err = tx.Copies.GetFirstAvailable(itemID, nil)
+		}
+		if err == nil {
+			if err := shelveCopy(tx, &hold, cp); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if hold.IsAvailable {
+			cal, err := s.loadCalendar(tx, now)
+			if err != nil {
+				return err
+			}
+			hold.ExpiryDate = cal.NextOpen(now.Add(policy.PickupPeriod))
+		} else if hold.CopyID == nil {
+			hold.EstimatedWeeksToWait = rules.forItem(types.DefaultPatronCategory, item).estimateWeeksToWait(hold.InLinePosition-1, copies)
+		}
+
+		return tx.Holds.Create(&hold)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// * by design supposed to be used when the hold is available to the patron, but they want to postpone the delivery
+func (s *Service) ChangeDeliveryDate(holdID uint, userID string, deliveryDate time.Time) (*types.Hold, error) {
+	var hold *types.Hold
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		found, err := tx.Holds.GetByID(holdID)
+		if err != nil {
+			return errorf(NotFound, "this hold doesn't exist")
+		}
+
+		if found.UserID != userID {
+			return errorf(Forbidden, "you can't perform this action")
+		}
+
+		if err := lock(tx, "", found.ItemID); err != nil {
+			return err
+		}
+
+		// * the hold may have been resolved or expired while waiting for the lock
+		hold, err = tx.Holds.GetByID(holdID)
+		if err != nil {
+			return errorf(NotFound, "this hold doesn't exist")
+		}
+
+		if !hold.IsAvailable {
+			return errorf(Conflict, "hold is not available")
+		}
+
+		now := s.clock.Now()
+		if !deliveryDate.After(now) {
+			return errorf(Invalid, "delivery date must be in the future")
+		}
+
+		hold.DeliveryDate = deliveryDate
+		hold.IsAvailable = false
+		hold.IsPostponed = true
+		hold.CopyID = nil // * the copy is handed to the next in line
+
+		if err := tx.Holds.Update(hold); err != nil {
+			return err
+		}
+
+		if err := s.rearrange(tx, hold.ItemID, now); err != nil {
+			return err
+		}
+
+		hold, err = tx.Holds.GetByID(holdID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// * patrons cancel their own holds, staff managing holds any of them
+func (s *Service) CancelHold(holdID uint, by *types.User) error {
+	return s.store.Transaction(func(tx *types.Store) error {
+		hold, err := tx.Holds.GetByID(holdID)
+		if err != nil {
+			return errorf(NotFound, "hold doesn't exist")
+		}
+
+		if hold.UserID != by.ID && !by.Can(types.HoldsManage) {
+			return errorf(Forbidden, "you can't perform this action")
+		}
+
+		if err := lock(tx, "", hold.ItemID); err != nil {
+			return err
+		}
+
+		// * a parallel cancel or pickup may have removed it while waiting for the lock
+		hold, err = tx.Holds.GetByID(holdID)
+		if err != nil {
+			return errorf(NotFound, "hold doesn't exist")
+		}
+
+		// * staff only cancel the holds picked up at their branch
+		staff := types.Actor{BranchID: by.BranchID}
+		if hold.UserID != by.ID && !staff.WorksAt(hold.PickupBranchID) {
+			return errorf(Forbidden, "hold is picked up at another branch")
+		}
+
+		if err := tx.Holds.Delete(holdID); err != nil {
+			return err
+		}
+
+		return s.rearrange(tx, hold.ItemID, s.clock.Now())
+	})
+}
+
+// * lends the copy waiting on the hold shelf to the patron of the hold
+func (s *Service) ResolveHold(holdID uint, actor types.Actor) (*types.Loan, error) {
+	var loan types.Loan
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		hold, err := tx.Holds.GetByID(holdID)
+		if err != nil {
+			return errorf(NotFound, "hold doesn't exist")
+		}
+
+		if err := lock(tx, "", hold.ItemID); err != nil {
+			return err
+		}
+
+		// * the hold may have changed while waiting for the lock
+		hold, err = tx.Holds.GetByID(holdID)
+		if err != nil {
+			return errorf(NotFound, "hold doesn't exist")
+		}
+
+		if !hold.IsAvailable {
+			return errorf(Conflict, "hold is not available")
+		}
+
+		if !actor.WorksAt(hold.PickupBranchID) {
+			return errorf(Forbidden, "hold is picked up at another branch")
+		}
+
+		patron, err := tx.Users.GetByID(hold.UserID)
+		if err != nil {
+			return errorf(NotFound, "user not found")
+		}
+
+		policy, err := s.policyFor(tx, patron.Category, hold.ItemID)
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		loan = types.Loan{ItemID: hold.ItemID, UserID: hold.UserID}
+		if err := s.startLoan(tx, policy, &loan, now); err != nil {
+			return err
+		}
+
+		if err := lendShelvedCopy(tx, &loan, hold); err != nil {
+			return err
+		}
+
+		if err := tx.Loans.Create(&loan); err != nil {
+			return err
+		}
+
+		if err := tx.Holds.Delete(hold.ID); err != nil {
+			return err
+		}
+
+		if err := s.rearrange(tx, hold.ItemID, now); err != nil {
+			return err
+		}
+
+		entry := actor.Entry(types.AuditHoldResolved, "hold", strconv.FormatUint(uint64(hold.ID), 10))
+		entry.Changes = types.AuditDiff(hold, nil)
+		entry.Detail = fmt.Sprintf("lent as loan %d", loan.ID)
+		return tx.Audit.Record(entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+// * puts postponed holds whose delivery date has passed back in line, they may get a copy right away
+func (s *Service) ReleasePostponedHolds(now time.Time) error {
+	due, err := s.store.Holds.GetPostponedDue(now)
+	if err != nil {
+		return err
+	}
+
+	items := make(map[uint]bool)
+	for _, hold := range due {
+		items[hold.ItemID] = true
+	}
+
+	for itemID := range items {
+		err := s.store.Transaction(func(tx *types.Store) error {
+			if err := lock(tx, "", itemID); err != nil {
+				return err
+			}
+			return s.rearrange(tx, itemID, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * deletes available holds that were not picked up before the expiry date and hands the copies to the next in line
+func (s *Service) ExpireHolds(now time.Time) error {
+	expired, err := s.store.Holds.GetExpired(now)
+	if err != nil {
+		return err
+	}
+
+	items := make(map[uint]bool)
+	for _, hold := range expired {
+		items[hold.ItemID] = true
+	}
+
+	for itemID := range items {
+		err := s.store.Transaction(func(tx *types.Store) error {
+			if err := lock(tx, "", itemID); err != nil {
+				return err
+			}
+
+			// * a hold picked up while waiting for the lock is gone already
+			holds, err := tx.Holds.GetByItemID(itemID)
+			if err != nil {
+				return err
+			}
+
+			for _, hold := range holds {
+				if hold.IsAvailable && hold.ExpiryDate.Before(now) {
+					if err := tx.Holds.Delete(hold.ID); err != nil {
+						return err
+					}
+				}
+			}
+
+			return s.rearrange(tx, itemID, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * the copy waits on the hold shelf when it's at the pickup branch already, otherwise it's sent there first
+func shelveCopy(tx *types.Store, hold *types.Hold, cp *types.Copy) error {
+	if cp.BranchID == hold.PickupBranchID {
+		cp.Status = types.CopyOnHoldShelf
+	} else {
+		cp.Status = types.CopyInTransit
+		cp.BranchID = hold.PickupBranchID
+	}
+
+	if err := tx.Copies.Update(cp); err != nil {
+		return err
+	}
+
+	copyID := cp.ID
+	hold.CopyID = &copyID
+	hold.IsAvailable = cp.Status == types.CopyOnHoldShelf
+
+	return nil
+}
+
+// * a copy keeps waiting for the hold on the hold shelf of the pickup branch, on the way there or once it arrived
+func waitsFor(cp *types.Copy, hold *types.Hold) bool {
+	if cp.BranchID != hold.PickupBranchID {
+		return false
+	}
+	return cp.Status == types.CopyOnHoldShelf || cp.Status == types.CopyInTransit || cp.Status == types.CopyAvailable
+}
+
+// * takes a free copy for the hold, preferably one already at the pickup branch
+func takeCopy(free []*types.Copy, branchID uint) (*types.Copy, []*types.Copy) {
+	if len(free) == 0 {
+		return nil, free
+	}
+
+	pick := 0
+	for i, cp := range free {
+		if cp.BranchID == branchID {
+			pick = i
+			break
+		}
+	}
+
+	cp := free[pick]
+	return cp, append(free[:pick], free[pick+1:]...)
+}
+
+func countCirculatingCopies(tx *types.Store, itemID uint) (uint, error) {
+	copies, err := tx.Copies.GetByItemID(itemID)
+	if err != nil {
+		return 0, err
+	}
+
+	var circulating uint
+	for _, cp := range copies {
+		if cp.IsCirculating() {
+			circulating++
+		}
+	}
+	return circulating, nil
+}
+
+// * every copy lends to one patron per loan period, ahead counts the holds in line before this one
+func (p Policy) estimateWeeksToWait(ahead, copies uint) uint {
+	if copies == 0 {
+		return 0
+	}
+
+	fullCycles := float64(ahead) / float64(copies)
+	return uint(math.Round(fullCycles)) * p.loanDays() / 7
+}
+
+// * a hold already in line waits at least one loan period until a copy comes back
+func (p Policy) reestimateWeeksToWait(position, copies uint) uint {
+	if copies == 0 {
+		return 0
+	}
+
+	fullCycles := float64(position) / float64(copies)
+	if uint(fullCycles) == 0 {
+		return uint(math.Ceil(fullCycles)) * p.loanDays() / 7
+	}
+	return uint(math.Round(fullCycles)) * p.loanDays() / 7
+}
+
+// * numbers the line of the item again and hands its free copies to the first active holds,
+// * the caller holds the lock on the item
+func (s *Service) rearrange(tx *types.Store, itemID uint, now time.Time) error {
+	holds, err := tx.Holds.GetByItemID(itemID)
+	if err != nil {
+		return err
+	}
+
+	item, err := tx.Items.GetByID(itemID)
+	if err != nil {
+		return err
+	}
+
+	rules, err := s.loadRules(tx)
+	if err != nil {
+		return err
+	}
+	// * the copies ahead in line go to all sorts of patrons, the wait is estimated with the loans of the usual one
+	estimates := rules.forItem(types.DefaultPatronCategory, item)
+
+	copies, err := tx.Copies.GetByItemID(itemID)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[uint]*types.Copy)
+	for i := range copies {
+		byID[copies[i].ID] = &copies[i]
+	}
+
+	// * a copy stays reserved only while an active hold is waiting for it
+	reserved := make(map[uint]bool)
+	for i := range holds {
+		hold := &holds[i]
+		if hold.CopyID == nil {
+			continue
+		}
+
+		cp, ok := byID[*hold.CopyID]
+		if hold.DeliveryDate.After(now) || !ok || !waitsFor(cp, hold) {
+			hold.CopyID = nil
+		} else {
+			reserved[cp.ID] = true
+		}
+	}
+
+	var (
+		circulating uint
+		free        []*types.Copy
+	)
+	for i := range copies {
+		cp := &copies[i]
+		if cp.IsCirculating() {
+			circulating++
+		}
+
+		if reserved[cp.ID] {
+			continue
+		}
+
+		if cp.Status == types.CopyOnHoldShelf {
+			cp.Status = types.CopyAvailable
+			if err := tx.Copies.Update(cp); err != nil {
+				return err
+			}
+		}
+
+		// * copies in transit are free once they arrive
+		if cp.Status == types.CopyAvailable {
+			free = append(free, cp)
+		}
+	}
+
+	// * postponed holds wait at the end of the line until their delivery date passes
+	queue := make([]types.Hold, 0, len(holds))
+	var postponed []types.Hold
+	for _, hold := range holds {
+		if hold.DeliveryDate.After(now) {
+			postponed = append(postponed, hold)
+		} else {
+			queue = append(queue, hold)
+		}
+	}
+	active := len(queue)
+	queue = append(queue, postponed...)
+
+	// * the calendar is only needed when a copy goes to the hold shelf
+	var cal *calendar.Calendar
+	for i, hold := range queue {
+		wasAvailable := hold.IsAvailable
+		hold.InLinePosition = uint(i + 1)
+		hold.IsPostponed = i >= active
+
+		var cp *types.Copy
+		if hold.CopyID != nil {
+			cp = byID[*hold.CopyID]
+		} else if !hold.IsPostponed {
+			cp, free = takeCopy(free, hold.PickupBranchID)
+		}
+
+		// * a new copy, or one that arrived at the pickup branch, goes to the hold shelf
+		if cp != nil && cp.Status == types.CopyAvailable {
+			if err := shelveCopy(tx, &hold, cp); err != nil {
+				return err
+			}
+		}
+		hold.IsAvailable = cp != nil && cp.Status == types.CopyOnHoldShelf
+
+		if hold.IsAvailable && !wasAvailable {
+			// * the patron decides how long the copy waits for them
+			patron, err := tx.Users.GetByID(hold.UserID)
+			if err != nil {
+				return err
+			}
+			if cal == nil {
+				if cal, err = s.loadCalendar(tx, now); err != nil {
+					return err
+				}
+			}
+			hold.ExpiryDate = cal.NextOpen(now.Add(rules.forItem(patron.Category, item).PickupPeriod))
+		}
+
+		if hold.CopyID != nil {
+			hold.EstimatedWeeksToWait = 0
+		} else {
+			hold.EstimatedWeeksToWait = estimates.reestimateWeeksToWait(hold.InLinePosition, circulating)
+		}
+
+		if err := tx.Holds.Update(&hold); err != nil {
+			return err
+		}
+
+		if hold.IsAvailable && !wasAvailable {
+			if err := tx.Notifications.Enqueue(notify.NewHoldAvailable(&hold, item)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,499 @@
+package circulation
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/types"
+	"gorm.io/gorm"
+)
+
+// * lends the copy the loan names, or any free copy of the item at the branch of the staff member,
+// * copies on the hold shelf are kept for the holds
+func (s *Service) Checkout(userID string, itemID, copyID uint, actor types.Actor) (*types.Loan, error) {
+	loan := types.Loan{UserID: userID, ItemID: itemID, CopyID: copyID}
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		if err := lock(tx, userID, itemID); err != nil {
+			return err
+		}
+
+		user, err := tx.Users.GetByID(userID)
+		if err != nil {
+			return errorf(NotFound, "user not found")
+		}
+
+		policy, err := s.policyFor(tx, user.Category, itemID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkFineBalance(tx, userID, policy); err != nil {
+			return err
+		}
+
+		if err := checkLoanLimit(tx, userID, policy); err != nil {
+			return err
+		}
+
+		if err := s.startLoan(tx, policy, &loan, s.clock.Now()); err != nil {
+			return err
+		}
+
+		if err := lendCopy(tx, &loan, actor); err != nil {
+			return err
+		}
+
+		return tx.Loans.Create(&loan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+// * prolongs the loan of the patron by another loan period, unless somebody is waiting for the item
+func (s *Service) Renew(loanID uint, userID string) (*types.Loan, error) {
+	var loan *types.Loan
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		found, err := tx.Loans.GetByID(loanID)
+		if err != nil {
+			return errorf(NotFound, "loan not found")
+		}
+
+		if found.UserID != userID {
+			return errorf(Forbidden, "you can't perform this action")
+		}
+
+		// * keeps new holds out until the renewal is done
+		if err := lock(tx, "", found.ItemID); err != nil {
+			return err
+		}
+
+		loan, err = tx.Loans.GetByID(loanID)
+		if err != nil {
+			return errorf(NotFound, "loan not found")
+		}
+
+		user, err := tx.Users.GetByID(loan.UserID)
+		if err != nil {
+			return errorf(NotFound, "user not found")
+		}
+
+		policy, err := s.policyFor(tx, user.Category, loan.ItemID)
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		if now.Before(loan.RenewableOn) {
+			return errorf(Invalid, "loan can't be renewed before %s", loan.RenewableOn.Format(time.DateOnly))
+		}
+
+		if help.OverdueDays(loan, now) > 0 {
+			return errorf(Invalid, "overdue loans can't be renewed")
+		}
+
+		if loan.Renewals >= loan.MaxRenewals {
+			return errorf(Invalid, "loan can't be renewed more than %d times", loan.MaxRenewals)
+		}
+
+		holds, err := tx.Holds.GetByItemID(loan.ItemID)
+		if err != nil {
+			return err
+		}
+
+		if len(holds) > 0 {
+			return errorf(Conflict, "item has pending holds")
+		}
+
+		cal, err := s.loadCalendar(tx, s.clock.Now())
+		if err != nil {
+			return err
+		}
+
+		loan.ExpireDate = cal.NextOpen(loan.ExpireDate.Add(policy.LoanPeriod))
+		loan.RenewableOn = loan.ExpireDate.Add(-policy.RenewalWindow)
+		loan.Renewals++
+
+		return tx.Loans.Update(loan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+// * closes the loan, charges what is overdue and hands the copy to the next hold in line, only the branch that lent
+// * the copy takes it back this way and other branches check it in by its barcode
+func (s *Service) Return(loanID uint, actor types.Actor) (*types.Loan, error) {
+	var loan *types.Loan
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		found, err := tx.Loans.GetByID(loanID)
+		if err != nil {
+			return errorf(NotFound, "loan not found")
+		}
+
+		if err := lock(tx, "", found.ItemID); err != nil {
+			return err
+		}
+
+		// * a parallel return may have closed it while waiting for the lock
+		loan, err = tx.Loans.GetByID(loanID)
+		if err != nil {
+			return errorf(NotFound, "loan not found")
+		}
+
+		if loan.CopyID != 0 {
+			cp, err := tx.Copies.GetByID(loan.CopyID)
+			if err != nil {
+				return err
+			}
+
+			if !actor.WorksAt(cp.BranchID) {
+				return errorf(Forbidden, "copy was lent at another branch")
+			}
+		}
+
+		if err := s.closeLoan(tx, loan, actor.BranchID, s.clock.Now()); err != nil {
+			return err
+		}
+
+		entry := actor.Entry(types.AuditLoanReturned, "loan", strconv.FormatUint(uint64(loan.ID), 10))
+		entry.Changes = types.AuditDiff(loan, nil)
+		return tx.Audit.Record(entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+// * lends the scanned copy to the owner of the library card, a copy on the hold shelf only to the patron it waits for
+func (s *Service) CheckoutCopy(barcode, libraryCard string, actor types.Actor) (*types.Loan, error) {
+	var loan types.Loan
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		cp, err := tx.Copies.GetByBarcode(barcode)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		user, err := tx.Users.GetByUniqueField("library_card", libraryCard)
+		if err != nil {
+			return errorf(NotFound, "library card not found")
+		}
+
+		if err := lock(tx, user.ID, cp.ItemID); err != nil {
+			return err
+		}
+
+		// * the copy may have been lent while waiting for the lock
+		if cp, err = tx.Copies.GetByID(cp.ID); err != nil {
+			return err
+		}
+
+		if !actor.WorksAt(cp.BranchID) {
+			return errorf(Forbidden, "copy is at another branch")
+		}
+
+		policy, err := s.policyFor(tx, user.Category, cp.ItemID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkFineBalance(tx, user.ID, policy); err != nil {
+			return err
+		}
+
+		if err := checkLoanLimit(tx, user.ID, policy); err != nil {
+			return err
+		}
+
+		holds, err := tx.Holds.GetByItemID(cp.ItemID)
+		if err != nil {
+			return err
+		}
+
+		// * the patron's hold on the item is fulfilled by this loan
+		var ownHold *types.Hold
+		for i := range holds {
+			if holds[i].UserID == user.ID {
+				ownHold = &holds[i]
+				break
+			}
+		}
+
+		now := s.clock.Now()
+		loan = types.Loan{ItemID: cp.ItemID, UserID: user.ID, CopyID: cp.ID}
+		if err := s.startLoan(tx, policy, &loan, now); err != nil {
+			return err
+		}
+
+		switch cp.Status {
+		case types.CopyAvailable:
+			if err := lendCopy(tx, &loan, actor); err != nil {
+				return err
+			}
+		case types.CopyOnHoldShelf:
+			if ownHold == nil || ownHold.CopyID == nil || *ownHold.CopyID != cp.ID {
+				return errorf(Conflict, "copy is waiting on the hold shelf for another patron")
+			}
+
+			if err := lendShelvedCopy(tx, &loan, ownHold); err != nil {
+				return err
+			}
+		default:
+			return errorf(Conflict, "copy is not available")
+		}
+
+		if err := tx.Loans.Create(&loan); err != nil {
+			return err
+		}
+
+		if ownHold != nil {
+			if err := tx.Holds.Delete(ownHold.ID); err != nil {
+				return err
+			}
+
+			if err := s.rearrange(tx, cp.ItemID, now); err != nil {
+				return err
+			}
+		}
+
+		entry := actor.Entry(types.AuditCopyCheckedOut, "loan", strconv.FormatUint(uint64(loan.ID), 10))
+		entry.Changes = types.AuditDiff(nil, loan)
+		return tx.Audit.Record(entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+// * closes the loan of the scanned copy, or receives a copy in transit, at the branch of the staff member
+// * and tells the desk whether it goes to the hold shelf, back to the stacks or on to another branch
+func (s *Service) CheckinCopy(barcode string, actor types.Actor) (*types.CheckinResponse, error) {
+	var res types.CheckinResponse
+
+	err := s.store.Transaction(func(tx *types.Store) error {
+		cp, err := tx.Copies.GetByBarcode(barcode)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		if err := lock(tx, "", cp.ItemID); err != nil {
+			return err
+		}
+
+		if cp, err = tx.Copies.GetByID(cp.ID); err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		var entry *types.AuditEntry
+
+		loan, err := tx.Loans.GetByCopyID(cp.ID)
+		switch {
+		case err == nil:
+			if err := s.closeLoan(tx, loan, actor.BranchID, now); err != nil {
+				return err
+			}
+
+			res.Loan = loan
+			entry = actor.Entry(types.AuditCopyCheckedIn, "loan", strconv.FormatUint(uint64(loan.ID), 10))
+			entry.Changes = types.AuditDiff(loan, nil)
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		case cp.Status == types.CopyInTransit:
+			before := *cp
+			if err := s.receiveCopy(tx, cp, actor.BranchID, now); err != nil {
+				return err
+			}
+
+			entry = actor.Entry(types.AuditCopyCheckedIn, "copy", strconv.FormatUint(uint64(cp.ID), 10))
+			entry.Changes = types.AuditDiff(before, cp)
+		default:
+			return errorf(Invalid, "copy is not on loan or in transit")
+		}
+
+		// * the rearrangement decides whether a hold gets the copy
+		cp, err = tx.Copies.GetByID(cp.ID)
+		if err != nil {
+			return err
+		}
+
+		res.Copy = *cp
+		res.Destination = types.ToStacks
+		res.ShelfLocation = cp.ShelfLocation
+
+		switch cp.Status {
+		case types.CopyInTransit:
+			branchID := cp.BranchID
+			res.Destination = types.ToTransit
+			res.BranchID = &branchID
+			res.ShelfLocation = ""
+		case types.CopyOnHoldShelf:
+			res.Destination = types.ToHoldShelf
+			res.ShelfLocation = ""
+		}
+
+		if cp.Status == types.CopyInTransit || cp.Status == types.CopyOnHoldShelf {
+			holds, err := tx.Holds.GetByItemID(cp.ItemID)
+			if err != nil {
+				return err
+			}
+
+			for _, hold := range holds {
+				if hold.CopyID != nil && *hold.CopyID == cp.ID {
+					holdID := hold.ID
+					res.HoldID = &holdID
+					break
+				}
+			}
+		}
+
+		return tx.Audit.Record(entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// * the loan is due on the first open day after its loan period
+func (s *Service) startLoan(tx *types.Store, policy Policy, loan *types.Loan, now time.Time) error {
+	cal, err := s.loadCalendar(tx, now)
+	if err != nil {
+		return err
+	}
+
+	loan.CheckoutDate = now
+	loan.ExpireDate = cal.NextOpen(now.Add(policy.LoanPeriod))
+	loan.RenewableOn = loan.ExpireDate.Add(-policy.RenewalWindow)
+	loan.MaxRenewals = policy.MaxRenewals
+	return nil
+}
+
+// * charges the fine if the loan is overdue, puts the copy back at the branch it's returned to
+// * and lets the holds of the item move up, the copy stays where it was recorded when the branch is nil
+func (s *Service) closeLoan(tx *types.Store, loan *types.Loan, at *uint, now time.Time) error {
+	if err := help.AssessFine(loan, now, tx.Fines, tx.Items); err != nil {
+		return err
+	}
+
+	if err := tx.Loans.Delete(loan.ID); err != nil {
+		return err
+	}
+
+	if loan.CopyID == 0 {
+		return s.rearrange(tx, loan.ItemID, now)
+	}
+
+	cp, err := tx.Copies.GetByID(loan.CopyID)
+	if err != nil {
+		return err
+	}
+
+	// * a copy reported lost is back once it's returned
+	if cp.Status == types.CopyWithdrawn {
+		return s.rearrange(tx, loan.ItemID, now)
+	}
+	return s.receiveCopy(tx, cp, at, now)
+}
+
+// * the copy is free at the branch again, a hold may take it and otherwise it goes back to the branch owning it
+func (s *Service) receiveCopy(tx *types.Store, cp *types.Copy, at *uint, now time.Time) error {
+	if at != nil {
+		cp.BranchID = *at
+	}
+
+	cp.Status = types.CopyAvailable
+	if err := tx.Copies.Update(cp); err != nil {
+		return err
+	}
+
+	if err := s.rearrange(tx, cp.ItemID, now); err != nil {
+		return err
+	}
+
+	found, err := tx.Copies.GetByID(cp.ID)
+	if err != nil {
+		return err
+	}
+	*cp = *found
+
+	if cp.Status != types.CopyAvailable || cp.BranchID == cp.HomeBranchID {
+		return nil
+	}
+
+	cp.Status = types.CopyInTransit
+	cp.BranchID = cp.HomeBranchID
+	return tx.Copies.Update(cp)
+}
+
+// * marks the copy of the loan as loaned, any free copy of the item at the branch of the staff member is picked
+// * when the loan doesn't name one
+func lendCopy(tx *types.Store, loan *types.Loan, actor types.Actor) error {
+	var cp *types.Copy
+
+	if loan.CopyID != 0 {
+		found, err := tx.Copies.GetByID(loan.CopyID)
+		if err != nil {
+			return errorf(NotFound, "copy not found")
+		}
+
+		if found.ItemID != loan.ItemID {
+			return errorf(Invalid, "copy doesn't belong to the item")
+		}
+
+		if found.Status != types.CopyAvailable {
+			return errorf(Conflict, "copy is not available")
+		}
+
+		if !actor.WorksAt(found.BranchID) {
+			return errorf(Forbidden, "copy is at another branch")
+		}
+		cp = found
+	} else {
+		found, err := tx.Copies.GetFirstAvailable(loan.ItemID, actor.BranchID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errorf(Conflict, "item is not available")
+		} else if err != nil {
+			return err
+		}
+		cp = found
+	}
+
+	cp.Status = types.CopyOnLoan
+	if err := tx.Copies.Update(cp); err != nil {
+		return err
+	}
+
+	loan.CopyID = cp.ID
+	return nil
+}
+
+// * the copy waiting on the hold shelf goes to the patron who placed the hold
+func lendShelvedCopy(tx *types.Store, loan *types.Loan, hold *types.Hold) error {
+	if hold.CopyID == nil {
+		return errorf(Conflict, "there is no copy on the hold shelf for this hold")
+	}
+
+	cp, err := tx.Copies.GetByID(*hold.CopyID)
+	if err != nil {
+		return errorf(NotFound, "copy not found")
+	}
+
+	cp.Status = types.CopyOnLoan
+	if err := tx.Copies.Update(cp); err != nil {
+		return err
+	}
+
+	loan.CopyID = cp.ID
+	return nil
+}
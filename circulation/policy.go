@@ -0,0 +1,54 @@
+package circulation
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxRenewals    = 2
+	defaultMaxFineBalance = 1000 // * 10.00
+)
+
+// * the numbers the rules of the library are made of
+type Policy struct {
+	MaxHolds       int           // * holds a patron may have at the time
+	MaxLoans       int           // * loans a patron may have at the time
+	LoanPeriod     time.Duration // * a new loan and every renewal run this long
+	RenewalWindow  time.Duration // * how long before the expire date a loan can be renewed
+	MaxRenewals    uint          // * how many times a new loan can be prolonged
+	PickupPeriod   time.Duration // * an available hold expires when it isn't picked up in time
+	MaxFineBalance int64         // * unpaid amount in cents above which patrons can't borrow or place holds
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxHolds:       10,
+		MaxLoans:       10,
+		LoanPeriod:     14 * 24 * time.Hour,
+		RenewalWindow:  3 * 24 * time.Hour,
+		MaxRenewals:    defaultMaxRenewals,
+		PickupPeriod:   3 * 24 * time.Hour,
+		MaxFineBalance: defaultMaxFineBalance,
+	}
+}
+
+// * MAX_LOAN_RENEWALS and MAX_FINE_BALANCE override the defaults
+func PolicyFromEnv() Policy {
+	policy := DefaultPolicy()
+
+	if value, err := strconv.ParseUint(os.Getenv("MAX_LOAN_RENEWALS"), 10, 32); err == nil {
+		policy.MaxRenewals = uint(value)
+	}
+
+	if value, err := strconv.ParseInt(os.Getenv("MAX_FINE_BALANCE"), 10, 64); err == nil {
+		policy.MaxFineBalance = value
+	}
+	return policy
+}
+
+// * the loan period in days, the estimates of the hold line count in whole loans
+func (p Policy) loanDays() uint {
+	return uint(p.LoanPeriod.Hours() / 24)
+}
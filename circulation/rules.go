@@ -0,0 +1,124 @@
+package circulation
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+)
+
+// * the policy matrix as one operation reads it, the defaults of the library below the rules of the admins
+type matrix struct {
+	defaults Policy
+	rules    []types.CirculationRule
+}
+
+func (s *Service) loadRules(tx *types.Store) (*matrix, error) {
+	rules, err := tx.Rules.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return newMatrix(s.defaults, rules), nil
+}
+
+// * the rules are kept from the least to the most specific, so applying them in order lets the specific ones win:
+// * every patron and item, a category, a kind and finally a category and a kind
+func newMatrix(defaults Policy, rules []types.CirculationRule) *matrix {
+	sorted := append([]types.CirculationRule{}, rules...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return specificity(&sorted[i]) < specificity(&sorted[j])
+	})
+	return &matrix{defaults: defaults, rules: sorted}
+}
+
+func specificity(rule *types.CirculationRule) int {
+	score := 0
+	if rule.PatronCategory != "" {
+		score++
+	}
+	if rule.KindID != nil {
+		score += 2
+	}
+	return score
+}
+
+func (m *matrix) matches(rule *types.CirculationRule, category string, kindID *uint) bool {
+	if rule.PatronCategory != "" && rule.PatronCategory != category {
+		return false
+	}
+	if rule.KindID == nil {
+		return true
+	}
+	return kindID != nil && *rule.KindID == *kindID
+}
+
+func (m *matrix) resolve(category string, kindID *uint) Policy {
+	policy := m.defaults
+	for i := range m.rules {
+		if m.matches(&m.rules[i], category, kindID) {
+			policy.apply(&m.rules[i])
+		}
+	}
+	return policy
+}
+
+// * the loan rules of an item for the patron, an item of several kinds gets the strictest of them,
+// * rules with a kind never set the limits of the patron so those are the same for every item
+func (m *matrix) forItem(category string, item *types.Item) Policy {
+	if len(item.Kinds) == 0 {
+		return m.resolve(category, nil)
+	}
+
+	var policy Policy
+	for i, kind := range item.Kinds {
+		kindID := kind.ID
+		found := m.resolve(category, &kindID)
+		if i == 0 {
+			policy = found
+			continue
+		}
+
+		policy.LoanPeriod = minDuration(policy.LoanPeriod, found.LoanPeriod)
+		policy.RenewalWindow = minDuration(policy.RenewalWindow, found.RenewalWindow)
+		policy.PickupPeriod = minDuration(policy.PickupPeriod, found.PickupPeriod)
+		if found.MaxRenewals < policy.MaxRenewals {
+			policy.MaxRenewals = found.MaxRenewals
+		}
+	}
+	return policy
+}
+
+func (p *Policy) apply(rule *types.CirculationRule) {
+	if rule.LoanDays != nil {
+		p.LoanPeriod = days(*rule.LoanDays)
+	}
+	if rule.RenewalWindowDays != nil {
+		p.RenewalWindow = days(*rule.RenewalWindowDays)
+	}
+	if rule.MaxRenewals != nil {
+		p.MaxRenewals = *rule.MaxRenewals
+	}
+	if rule.PickupDays != nil {
+		p.PickupPeriod = days(*rule.PickupDays)
+	}
+	if rule.MaxHolds != nil {
+		p.MaxHolds = int(*rule.MaxHolds)
+	}
+	if rule.MaxLoans != nil {
+		p.MaxLoans = int(*rule.MaxLoans)
+	}
+	if rule.MaxFineBalance != nil {
+		p.MaxFineBalance = *rule.MaxFineBalance
+	}
+}
+
+func days(n uint) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if b < a {
+		return b
+	}
+	return a
+}
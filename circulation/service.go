@@ -0,0 +1,85 @@
+package circulation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gimtwi/go-library-project/calendar"
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/types"
+	"gorm.io/gorm"
+)
+
+// * the hold and loan operations, each one runs in a single transaction that first locks the rows its checks depend on
+type Service struct {
+	store    *types.Store
+	clock    help.Clock
+	defaults Policy         // * what the rules of the admins leave open
+	location *time.Location // * where the days of the opening calendar begin and end
+}
+
+func NewService(store *types.Store, clock help.Clock, defaults Policy, location *time.Location) *Service {
+	return &Service{store: store, clock: clock, defaults: defaults, location: location}
+}
+
+// * the patron row keeps their limits honest and the item row the line of holds and its copies,
+// * the patron always goes first so two operations never wait on each other
+func lock(tx *types.Store, userID string, itemID uint) error {
+	if userID != "" {
+		if err := tx.Users.Lock(userID); errors.Is(err, gorm.ErrRecordNotFound) {
+			return errorf(NotFound, "user not found")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Items.Lock(itemID); errors.Is(err, gorm.ErrRecordNotFound) {
+		return errorf(NotFound, "item not found")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// * the policy of a loan or hold of a patron of the category on the item
+func (s *Service) policyFor(tx *types.Store, category string, itemID uint) (Policy, error) {
+	rules, err := s.loadRules(tx)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	item, err := tx.Items.GetByID(itemID)
+	if err != nil {
+		return Policy{}, err
+	}
+	return rules.forItem(category, item), nil
+}
+
+// * the opening calendar from now on, due dates and pickup deadlines never fall on a closed day
+func (s *Service) loadCalendar(tx *types.Store, now time.Time) (*calendar.Calendar, error) {
+	return calendar.Load(tx.Calendar, s.location, now)
+}
+
+func checkFineBalance(tx *types.Store, userID string, policy Policy) error {
+	balance, err := tx.Fines.GetBalance(userID)
+	if err != nil {
+		return err
+	}
+
+	if balance > policy.MaxFineBalance {
+		return errorf(Forbidden, "user has unpaid fines over the allowed limit")
+	}
+	return nil
+}
+
+func checkLoanLimit(tx *types.Store, userID string, policy Policy) error {
+	loans, err := tx.Loans.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	if len(loans) >= policy.MaxLoans {
+		return errorf(Invalid, "user can't loan more than %d items", policy.MaxLoans)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllAPIKeys(kr types.APIKeyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := bindPageRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		keys, err := kr.GetAll(*req)
+		if err != nil {
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// * must be performed by admin, the key can only be scoped to permissions its user already has
+func CreateAPIKey(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.APIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiry date must be in the future"})
+			return
+		}
+
+		owner, err := s.Users.GetByID(req.UserID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		for _, permission := range req.Permissions {
+			if !permission.IsValid() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown permission " + string(permission)})
+				return
+			}
+
+			if !owner.Can(permission) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the user doesn't have the permission " + string(permission)})
+				return
+			}
+		}
+
+		rawKey, hash, err := middleware.NewAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create key"})
+			return
+		}
+
+		key := types.APIKey{
+			Name:        req.Name,
+			UserID:      owner.ID,
+			CreatedByID: middleware.GetUserIDFromTheToken(c),
+			Prefix:      rawKey[:len(middleware.APIKeyPrefix)+6],
+			KeyHash:     hash,
+			Permissions: req.Permissions,
+			ExpiresAt:   req.ExpiresAt,
+		}
+
+		err = s.Transaction(func(tx *types.Store) error {
+			if err := tx.APIKeys.Create(&key); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditAPIKeyCreated, "api_key", strconv.FormatUint(uint64(key.ID), 10))
+			entry.Changes = types.AuditDiff(nil, key)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, types.CreatedAPIKey{APIKey: key, Key: rawKey})
+	}
+}
+
+func RevokeAPIKey(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key id"})
+			return
+		}
+
+		err = s.Transaction(func(tx *types.Store) error {
+			key, err := tx.APIKeys.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "api key not found")
+			}
+
+			if err := tx.APIKeys.Revoke(key.ID, time.Now()); err != nil {
+				return err
+			}
+
+			revoked, err := tx.APIKeys.GetByID(key.ID)
+			if err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditAPIKeyRevoked, "api_key", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(key, revoked)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "api key was revoked!"})
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * the caller, the api key is kept when the caller used one
+func actorFromContext(c *gin.Context) types.Actor {
+	actor := types.Actor{UserID: middleware.GetUserIDFromTheToken(c), IP: c.ClientIP()}
+
+	if user := middleware.GetUserFromContext(c); user != nil {
+		actor.BranchID = user.BranchID
+	}
+
+	if key := middleware.GetAPIKeyFromContext(c); key != nil {
+		keyID := key.ID
+		actor.APIKeyID = &keyID
+	}
+	return actor
+}
+
+func auditEntry(c *gin.Context, action types.AuditAction, targetType, targetID string) *types.AuditEntry {
+	return actorFromContext(c).Entry(action, targetType, targetID)
+}
+
+// * logins change nothing to roll back, a failing audit table must not lock everybody out
+func auditLogin(c *gin.Context, ar types.AuditRepository, action types.AuditAction, userID, detail string) {
+	entry := auditEntry(c, action, "user", userID)
+	entry.Detail = detail
+
+	// * only a successful login tells who is acting
+	if action == types.AuditLogin {
+		entry.ActorID = userID
+	}
+
+	if err := ar.Record(entry); err != nil {
+		log.Printf("failed to record %s: %v", action, err)
+	}
+}
+
+// * must be performed by admin, answers with csv instead of a page when asked for format=csv
+func GetAuditLog(ar types.AuditRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var filter types.AuditFilter
+		if err := c.ShouldBindQuery(&filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.Query("format") == "csv" {
+			exportAuditLog(c, filter, ar)
+			return
+		}
+
+		req, err := bindPageRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		entries, err := ar.List(filter, *req)
+		if err != nil {
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
+// * usernames of failed logins are typed by anybody, spreadsheets must not run them as formulas
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func exportAuditLog(c *gin.Context, filter types.AuditFilter, ar types.AuditRepository) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("20060102-150405")+`.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "createdAt", "actorID", "apiKeyID", "action", "targetType", "targetID", "changes", "detail", "ip"})
+
+	err := ar.Export(filter, func(entries []types.AuditEntry) error {
+		for _, entry := range entries {
+			apiKeyID := ""
+			if entry.APIKeyID != nil {
+				apiKeyID = strconv.FormatUint(uint64(*entry.APIKeyID), 10)
+			}
+
+			changes := ""
+			if len(entry.Changes) > 0 {
+				raw, _ := json.Marshal(entry.Changes)
+				changes = string(raw)
+			}
+
+			w.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10),
+				entry.CreatedAt.UTC().Format(time.RFC3339),
+				entry.ActorID,
+				apiKeyID,
+				string(entry.Action),
+				entry.TargetType,
+				csvCell(entry.TargetID),
+				csvCell(changes),
+				csvCell(entry.Detail),
+				entry.IP,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	})
+
+	// * the header is already out, all that is left is to cut the file short
+	if err != nil {
+		log.Printf("audit export failed: %v", err)
+		c.Abort()
+	}
+}
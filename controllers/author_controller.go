@@ -11,15 +11,15 @@ import (
 
 func GetOrderedFilteredAuthorsByName(ar types.AuthorRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var filters types.FilteredRequestBody
-		if err := c.ShouldBindJSON(&filters); err != nil {
+		req, err := bindPageRequest(c)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		authors, err := ar.GetAll(string(filters.Order), filters.Filter, filters.Limit)
+		authors, err := ar.GetAll(*req)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, authors)
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetBranches(br types.BranchRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		branches, err := br.GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch branches"})
+			return
+		}
+		c.JSON(http.StatusOK, branches)
+	}
+}
+
+func GetBranchByID(br types.BranchRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid branch id"})
+			return
+		}
+
+		branch, err := br.GetByID(uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "branch not found"})
+			return
+		}
+		c.JSON(http.StatusOK, branch)
+	}
+}
+
+// * every branch has its own name
+func checkBranchName(tx *types.Store, branch *types.Branch) error {
+	branch.Name = strings.TrimSpace(branch.Name)
+	if branch.Name == "" {
+		return fail(http.StatusBadRequest, "invalid branch name")
+	}
+
+	existing, err := tx.Branches.GetByName(branch.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if existing.ID != branch.ID {
+		return fail(http.StatusConflict, "there is already a branch with this name")
+	}
+	return nil
+}
+
+// * must be performed by admin
+func CreateBranch(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var branch types.Branch
+		if err := c.ShouldBindJSON(&branch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		branch.ID = 0
+
+		err := s.Transaction(func(tx *types.Store) error {
+			if err := checkBranchName(tx, &branch); err != nil {
+				return err
+			}
+
+			if err := tx.Branches.Create(&branch); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditBranchCreated, "branch", strconv.FormatUint(uint64(branch.ID), 10))
+			entry.Changes = types.AuditDiff(nil, branch)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, branch)
+	}
+}
+
+// * must be performed by admin
+func UpdateBranch(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid branch id"})
+			return
+		}
+
+		var req types.Branch
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var branch *types.Branch
+		err = s.Transaction(func(tx *types.Store) error {
+			branch, err = tx.Branches.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "branch not found")
+			}
+
+			before := *branch
+			branch.Name = req.Name
+			branch.Address = req.Address
+
+			if err := checkBranchName(tx, branch); err != nil {
+				return err
+			}
+
+			if err := tx.Branches.Update(branch); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditBranchUpdated, "branch", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(before, branch)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, branch)
+	}
+}
+
+// * must be performed by admin, only a branch nothing refers to anymore can go
+func DeleteBranch(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid branch id"})
+			return
+		}
+
+		if uint(id) == types.MainBranchID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the main branch can't be deleted"})
+			return
+		}
+
+		err = s.Transaction(func(tx *types.Store) error {
+			branch, err := tx.Branches.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "branch not found")
+			}
+
+			inUse, err := tx.Branches.IsInUse(branch.ID)
+			if err != nil {
+				return err
+			}
+
+			if inUse {
+				return fail(http.StatusConflict, "branch still has copies, holds or staff")
+			}
+
+			if err := tx.Branches.Delete(branch.ID); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditBranchDeleted, "branch", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(branch, nil)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gimtwi/go-library-project/calendar"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// * an iCalendar file of a whole country's holidays for several years is still far below this
+const maxCalendarImportSize = 1 << 20
+
+func GetOpeningHours(cr types.CalendarRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hours, err := cr.GetHours()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch opening hours"})
+			return
+		}
+		c.JSON(http.StatusOK, hours)
+	}
+}
+
+// * must be performed by admin, the request is the whole week and the days left out are closed
+func SetOpeningHours(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var hours []types.OpeningHours
+		if err := c.ShouldBindJSON(&hours); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		seen := make(map[time.Weekday]bool)
+		for i := range hours {
+			if err := hours[i].Validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if seen[hours[i].Weekday] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is given more than once", hours[i].Weekday)})
+				return
+			}
+			seen[hours[i].Weekday] = true
+		}
+
+		err := s.Transaction(func(tx *types.Store) error {
+			before, err := tx.Calendar.GetHours()
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Calendar.ReplaceHours(hours); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditHoursChanged, "opening_hours", "")
+			entry.Changes = types.AuditDiff(gin.H{"hours": before}, gin.H{"hours": hours})
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, hours)
+	}
+}
+
+// * answers with an iCalendar file instead of json when asked for format=ics
+func GetClosures(cr types.CalendarRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var filter types.ClosureFilter
+		if err := c.ShouldBindQuery(&filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		closures, err := cr.GetClosures(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch closures"})
+			return
+		}
+
+		if c.Query("format") != "ics" {
+			c.JSON(http.StatusOK, closures)
+			return
+		}
+
+		c.Header("Content-Type", "text/calendar; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="closures.ics"`)
+		c.Status(http.StatusOK)
+		calendar.Export(c.Writer, closures, time.Now())
+	}
+}
+
+// * every imported event carries a uid, two closures with the same one can't both be kept
+func checkClosureUID(tx *types.Store, closure *types.Closure) error {
+	existing, err := tx.Calendar.GetClosureByUID(closure.UID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if existing.ID != closure.ID {
+		return fail(http.StatusConflict, "there is already a closure with this uid")
+	}
+	return nil
+}
+
+// * must be performed by admin
+func CreateClosure(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var closure types.Closure
+		if err := c.ShouldBindJSON(&closure); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		closure.ID = 0
+
+		if err := closure.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if closure.UID == "" {
+			closure.UID = uuid.NewString()
+		}
+
+		err := s.Transaction(func(tx *types.Store) error {
+			if err := checkClosureUID(tx, &closure); err != nil {
+				return err
+			}
+
+			if err := tx.Calendar.CreateClosure(&closure); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditClosureCreated, "closure", strconv.FormatUint(uint64(closure.ID), 10))
+			entry.Changes = types.AuditDiff(nil, closure)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, closure)
+	}
+}
+
+// * must be performed by admin, loans and holds already running keep the dates they got
+func UpdateClosure(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid closure id"})
+			return
+		}
+
+		var req types.Closure
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := req.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var closure *types.Closure
+		err = s.Transaction(func(tx *types.Store) error {
+			closure, err = tx.Calendar.GetClosureByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "closure not found")
+			}
+
+			before := *closure
+			req.ID = closure.ID
+			req.CreatedAt = closure.CreatedAt
+			if req.UID == "" {
+				req.UID = closure.UID
+			}
+			*closure = req
+
+			if err := checkClosureUID(tx, closure); err != nil {
+				return err
+			}
+
+			if err := tx.Calendar.UpdateClosure(closure); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditClosureUpdated, "closure", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(before, closure)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, closure)
+	}
+}
+
+// * must be performed by admin
+func DeleteClosure(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid closure id"})
+			return
+		}
+
+		err = s.Transaction(func(tx *types.Store) error {
+			closure, err := tx.Calendar.GetClosureByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "closure not found")
+			}
+
+			if err := tx.Calendar.DeleteClosure(closure.ID); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditClosureDeleted, "closure", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(closure, nil)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// * must be performed by admin, the body is an iCalendar file, events already imported before are updated by their uid
+func ImportClosures(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		closures, err := calendar.Import(http.MaxBytesReader(c.Writer, c.Request.Body, maxCalendarImportSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var created, updated int
+		err = s.Transaction(func(tx *types.Store) error {
+			for i := range closures {
+				closure := &closures[i]
+
+				existing, err := tx.Calendar.GetClosureByUID(closure.UID)
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					if err := tx.Calendar.CreateClosure(closure); err != nil {
+						return err
+					}
+					created++
+					continue
+				} else if err != nil {
+					return err
+				}
+
+				closure.ID = existing.ID
+				closure.CreatedAt = existing.CreatedAt
+				if err := tx.Calendar.UpdateClosure(closure); err != nil {
+					return err
+				}
+				updated++
+			}
+
+			entry := auditEntry(c, types.AuditClosuresImport, "closure", "")
+			entry.Detail = fmt.Sprintf("created %d, updated %d", created, updated)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"created": created, "updated": updated, "closures": closures})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/gimtwi/go-library-project/notify"
 	"github.com/gimtwi/go-library-project/types"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -26,7 +27,11 @@ func setupTestDB() *gorm.DB {
 		log.Fatalf("failed to connect to test database: %v", err)
 	}
 
-	db.AutoMigrate(&types.Author{}, &types.Genre{}, &types.Kind{}, &types.User{}, &types.Hold{}, &types.Loan{}, &types.Item{})
+	db.AutoMigrate(&types.Role{})
+	db.FirstOrCreate(&types.Role{ID: types.MemberRoleID, Name: "member", Permissions: types.MemberPermissions, Builtin: true})
+	db.AutoMigrate(&types.Branch{})
+	db.FirstOrCreate(&types.Branch{ID: types.MainBranchID, Name: "main"})
+	db.AutoMigrate(&types.Author{}, &types.Genre{}, &types.Kind{}, &types.User{}, &types.Hold{}, &types.Loan{}, &types.Item{}, &types.AuditEntry{}, &types.CirculationRule{}, &types.OpeningHours{}, &types.Closure{})
 
 	return db
 }
@@ -64,13 +69,14 @@ func TestControllers(t *testing.T) {
 		}
 	}()
 
-	userRepo := types.NewUserRepository(set)
+	store := types.NewStore(set)
+	userRepo := store.Users
 
 	router := gin.Default()
 	router.GET("/user", GetAllUsers(userRepo))
 	router.GET("/user/:id", GetUserByID(userRepo))
-	router.POST("/register", RegisterUser(userRepo))
-	router.DELETE("/user/:id", DeleteUser(userRepo))
+	router.POST("/register", RegisterUser(store, notify.LogNotifier{}))
+	router.DELETE("/user/:id", DeleteUser(store))
 
 	t.Run("UserController", func(t *testing.T) {
 		//* register user
@@ -87,7 +93,7 @@ func TestControllers(t *testing.T) {
 		assert.Equal(t, http.StatusOK, wRegisterUser.Code)
 
 		//* get all user
-		getUsers, err := http.NewRequest("GET", "/user", nil)
+		getUsers, err := http.NewRequest("GET", "/user?filter=test_user", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -95,13 +101,13 @@ func TestControllers(t *testing.T) {
 		router.ServeHTTP(wUsers, getUsers)
 		assert.Equal(t, http.StatusOK, wUsers.Code)
 
-		var response []types.UserResponse
+		var response types.Page[types.UserResponse]
 		err = json.Unmarshal(wUsers.Body.Bytes(), &response)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		userID := response[0].ID
+		userID := response.Data[0].ID
 
 		//* get user by id
 		getUser, _ := http.NewRequest("GET", "/user/"+userID, nil)
@@ -117,3 +123,73 @@ func TestControllers(t *testing.T) {
 	})
 
 }
+
+// * acts as a staff member working at the given branch
+func asStaffOf(branchID uint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user", &types.User{ID: "test_staff", BranchID: &branchID})
+	}
+}
+
+func TestBranchScoping(t *testing.T) {
+	set := setupTestDB()
+	store := types.NewStore(set)
+
+	branch := types.Branch{Name: "test_scoping_branch"}
+	if err := set.Create(&branch).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		set.Where("username = ?", "test_scoped_user").Delete(&types.User{})
+		set.Where("actor_id = ?", "test_staff").Delete(&types.AuditEntry{})
+		set.Delete(&branch)
+	}()
+
+	branchDesk := gin.New()
+	branchDesk.Use(asStaffOf(branch.ID))
+	branchDesk.POST("/register", RegisterUser(store, notify.LogNotifier{}))
+	branchDesk.PUT("/user/:id/category", AssignCategory(store))
+	branchDesk.DELETE("/user/:id", DeleteUser(store))
+
+	mainDesk := gin.New()
+	mainDesk.Use(asStaffOf(types.MainBranchID))
+	mainDesk.PUT("/user/:id/category", AssignCategory(store))
+	mainDesk.DELETE("/user/:id", DeleteUser(store))
+
+	send := func(router *gin.Engine, method, path, body string) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("RegistersOnlyAtTheOwnBranch", func(t *testing.T) {
+		body := fmt.Sprintf(`{"username": "test_scoped_user", "email": "scoped@test.com", "password": "password", "branchID": %d}`, types.MainBranchID)
+		assert.Equal(t, http.StatusForbidden, send(branchDesk, "POST", "/register", body))
+
+		body = `{"username": "test_scoped_user", "email": "scoped@test.com", "password": "password"}`
+		assert.Equal(t, http.StatusOK, send(branchDesk, "POST", "/register", body))
+
+		user, err := store.Users.GetByUniqueField("username", "test_scoped_user")
+		if assert.NoError(t, err) && assert.NotNil(t, user.BranchID) {
+			assert.Equal(t, branch.ID, *user.BranchID)
+		}
+	})
+
+	user, err := store.Users.GetByUniqueField("username", "test_scoped_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("OtherBranchesCantChangeTheUser", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(mainDesk, "PUT", "/user/"+user.ID+"/category", `{"category": "child"}`))
+		assert.Equal(t, http.StatusForbidden, send(mainDesk, "DELETE", "/user/"+user.ID, ""))
+	})
+
+	t.Run("TheOwnBranchChangesTheUser", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(branchDesk, "PUT", "/user/"+user.ID+"/category", `{"category": "child"}`))
+		assert.Equal(t, http.StatusNoContent, send(branchDesk, "DELETE", "/user/"+user.ID, ""))
+	})
+}
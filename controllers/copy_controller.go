@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gimtwi/go-library-project/circulation"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+func GetCopiesByItemID(cr types.CopyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+			return
+		}
+
+		copies, err := cr.GetByItemID(uint(id))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch copies"})
+			return
+		}
+		c.JSON(http.StatusOK, copies)
+	}
+}
+
+func GetCopyByID(cr types.CopyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy id"})
+			return
+		}
+
+		cp, err := cr.GetByID(uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, cp)
+	}
+}
+
+func GetCopyByBarcode(cr types.CopyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cp, err := cr.GetByBarcode(c.Param("barcode"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, cp)
+	}
+}
+
+func CreateCopy(ir types.ItemRepository, cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cp types.Copy
+		if err := c.ShouldBindJSON(&cp); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := ir.GetByID(cp.ItemID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+			return
+		}
+
+		created, err := cs.CreateCopy(cp, actorFromContext(c))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, created)
+	}
+}
+
+func UpdateCopy(cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy id"})
+			return
+		}
+
+		var req types.Copy
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updated, err := cs.UpdateCopy(uint(id), req, actorFromContext(c))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+func DeleteCopy(cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid copy id"})
+			return
+		}
+
+		if err := cs.DeleteCopy(uint(id), actorFromContext(c)); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
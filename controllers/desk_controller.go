@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gimtwi/go-library-project/circulation"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * must be performed by moderator, lends the scanned copy to the owner of the library card
+func CheckoutCopy(cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.CheckoutRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		loan, err := cs.CheckoutCopy(req.Barcode, req.LibraryCard, actorFromContext(c))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, loan)
+	}
+}
+
+// * must be performed by moderator, closes the loan of the scanned copy
+func CheckinCopy(cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.CheckinRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := cs.CheckinCopy(req.Barcode, actorFromContext(c))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
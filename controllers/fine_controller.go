@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+func GetOwnFines(fr types.FineRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := middleware.GetUserIDFromTheToken(c)
+		if userID == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		balance, err := getFineBalance(userID, fr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch fines"})
+			return
+		}
+		c.JSON(http.StatusOK, balance)
+	}
+}
+
+func GetFinesByUserID(fr types.FineRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		balance, err := getFineBalance(id, fr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch fines"})
+			return
+		}
+		c.JSON(http.StatusOK, balance)
+	}
+}
+
+// * must be performed by moderator
+func RecordPayment(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req types.FineRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.Amount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payment amount must be greater than zero"})
+			return
+		}
+
+		payment := types.Fine{
+			UserID:     id,
+			Type:       types.Payment,
+			Amount:     req.Amount,
+			Note:       req.Note,
+			RecordedBy: middleware.GetUserIDFromTheToken(c),
+		}
+
+		err := s.Transaction(func(tx *types.Store) error {
+			if _, err := tx.Users.GetByID(id); err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			balance, err := tx.Fines.GetBalance(id)
+			if err != nil {
+				return err
+			}
+
+			if int64(req.Amount) > balance {
+				return fail(http.StatusBadRequest, "payment exceeds the unpaid balance")
+			}
+
+			if err := tx.Fines.Create(&payment); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditPaymentRecorded, "user", id)
+			entry.Changes = types.AuditDiff(nil, payment)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, payment)
+	}
+}
+
+// * must be performed by moderator, waives the whole balance when no amount is given
+func WaiveFines(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req types.FineRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var waiver types.Fine
+		err := s.Transaction(func(tx *types.Store) error {
+			if _, err := tx.Users.GetByID(id); err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			balance, err := tx.Fines.GetBalance(id)
+			if err != nil {
+				return err
+			}
+
+			if balance <= 0 {
+				return fail(http.StatusBadRequest, "user has no unpaid fines")
+			}
+
+			if req.Amount == 0 {
+				req.Amount = uint(balance)
+			}
+
+			if int64(req.Amount) > balance {
+				return fail(http.StatusBadRequest, "waiver exceeds the unpaid balance")
+			}
+
+			waiver = types.Fine{
+				UserID:     id,
+				Type:       types.Waiver,
+				Amount:     req.Amount,
+				Note:       req.Note,
+				RecordedBy: middleware.GetUserIDFromTheToken(c),
+			}
+
+			if err := tx.Fines.Create(&waiver); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditFinesWaived, "user", id)
+			entry.Changes = types.AuditDiff(nil, waiver)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, waiver)
+	}
+}
+
+func getFineBalance(userID string, fr types.FineRepository) (*types.FineBalance, error) {
+	fines, err := fr.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := fr.GetBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.FineBalance{UserID: userID, Balance: balance, Fines: fines}, nil
+}
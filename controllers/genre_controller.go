@@ -11,15 +11,15 @@ import (
 
 func GetOrderedFilteredGenresByName(gr types.GenreRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var filters types.FilteredRequestBody
-		if err := c.ShouldBindJSON(&filters); err != nil {
+		req, err := bindPageRequest(c)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		genres, err := gr.GetAll(string(filters.Order), filters.Filter, filters.Limit)
+		genres, err := gr.GetAll(*req)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, genres)
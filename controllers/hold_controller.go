@@ -3,25 +3,30 @@ package controllers
 import (
 	"net/http"
 	"strconv"
-	"time"
 
-	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/circulation"
 	"github.com/gimtwi/go-library-project/middleware"
 	"github.com/gimtwi/go-library-project/types"
 	"github.com/gin-gonic/gin"
 )
 
-func GetHoldsByUserID(hr types.HoldRepository, lr types.LoanRepository, ir types.ItemRepository) gin.HandlerFunc {
+func GetHoldsByUserID(hr types.HoldRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id := userIDParam(c)
 
-		holds, err := hr.GetByUserID(id)
+		req, err := bindPageRequest(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch holds"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, holds)
+		page, err := hr.ListByUserID(id, *req)
+		if err != nil {
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -34,94 +39,55 @@ func GetHoldsByItemID(hr types.HoldRepository) gin.HandlerFunc {
 			return
 		}
 
-		holds, err := hr.GetByItemID(uint(id))
+		req, err := bindPageRequest(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		holds, err := hr.ListByItemID(uint(id), *req)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch holds"})
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, holds)
 	}
 }
 
-func PlaceHold(hr types.HoldRepository, lr types.LoanRepository, ir types.ItemRepository) gin.HandlerFunc {
+func PlaceHold(cs *circulation.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var hold types.Hold
-		if err := c.ShouldBindJSON(&hold); err != nil {
+		var req types.Hold
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		userID := middleware.GetUserIDFromTheToken(c)
-		if userID == "" {
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
 			return
 		}
 
-		userHolds, err := hr.GetByUserID(userID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-
-		for _, h := range userHolds {
-			if h.ItemID == hold.ItemID {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "user has already placed a hold on this item"})
-				return
-			}
-		}
-
-		if len(userHolds) >= 10 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "users are not allowed to have more than 10 holds at the time"})
-			return
-		}
-
-		hold.UserID = userID
-
-		item, err := ir.GetByID(hold.ItemID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
-			return
-		}
-
-		holds, err := hr.GetByItemID(item.ID)
+		hold, err := cs.PlaceHold(user, req.ItemID, req.PickupBranchID)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "couldn't fetch the holds for the item"})
-			return
-		}
-
-		hold.PlacedDate = time.Now()
-		hold.DeliveryDate = time.Now()
-
-		if err := help.CheckInitialAvailability(&hold, item, hr, lr); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if hold.IsAvailable {
-			hold.InLinePosition = uint(len(holds) + 1)
-			hold.ExpiryDate = time.Now().Add(3 * 24 * time.Hour) // if item is available for loner than 3 days the hold will expire automatically
-			hold.EstimatedWeeksToWait = 0
-		} else {
-			hold.InLinePosition = uint(len(holds) + 1)
-			if err := help.CalculateDaysToWait(&hold, item); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
-
-		if err := hr.Create(&hold); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, err)
 			return
 		}
 		c.JSON(http.StatusCreated, hold)
 	}
 }
 
-// * by design supposed to be used when the hold is available to the user, but user wants to postpone the delivery
-func ChangeDeliveryDate(hr types.HoldRepository) gin.HandlerFunc {
+func ChangeDeliveryDate(cs *circulation.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var hold types.Hold
-		if err := c.ShouldBindJSON(&hold); err != nil {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hold id"})
+			return
+		}
+
+		var req types.ChangeDeliveryDateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -132,29 +98,16 @@ func ChangeDeliveryDate(hr types.HoldRepository) gin.HandlerFunc {
 			return
 		}
 
-		updatedHold, err := hr.GetByID(hold.ID)
+		hold, err := cs.ChangeDeliveryDate(uint(id), userID, req.DeliveryDate)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "this hold doesn't exist"})
-			return
-		}
-
-		updatedHold.DeliveryDate = hold.DeliveryDate
-
-		if err := hr.Update(updatedHold); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, err)
 			return
 		}
-
-		//TODO update in line position
-		//TODO update is available to false
-		//TODO update other holds
-
-		c.JSON(http.StatusOK, updatedHold)
+		c.JSON(http.StatusOK, hold)
 	}
-
 }
 
-func CancelHold(hr types.HoldRepository, lr types.LoanRepository, ir types.ItemRepository, ur types.UserRepository) gin.HandlerFunc {
+func CancelHold(cs *circulation.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
@@ -163,47 +116,21 @@ func CancelHold(hr types.HoldRepository, lr types.LoanRepository, ir types.ItemR
 			return
 		}
 
-		userID := middleware.GetUserIDFromTheToken(c)
-		if userID == "" {
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
 			return
 		}
 
-		hold, err := hr.GetByID(uint(id))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "hold doesn't exist"})
-			return
-		}
-
-		user, err := ur.GetByID(userID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "couldn't find the user"})
+		if err := cs.CancelHold(uint(id), user); err != nil {
+			respondError(c, err)
 			return
 		}
-
-		requiredRole := types.Moderator
-
-		if hold.UserID == userID || types.CheckPrivilege(user.Role, requiredRole) {
-
-			if err := hr.Delete(uint(id)); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			if err := help.RearrangeHolds(hold.ItemID, hr, lr, ir); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "you can't perform this action"})
-			return
-		}
-
 	}
 }
 
 // * must be performed by moderator
-func ResolveHold(hr types.HoldRepository, lr types.LoanRepository, ir types.ItemRepository) gin.HandlerFunc {
+func ResolveHold(cs *circulation.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
@@ -212,37 +139,8 @@ func ResolveHold(hr types.HoldRepository, lr types.LoanRepository, ir types.Item
 			return
 		}
 
-		hold, err := hr.GetByID(uint(id))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "hold doesn't exist"})
-			return
-		}
-
-		if !hold.IsAvailable {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "hold is not available"})
-			return
-		}
-
-		var loan types.Loan
-
-		loan.ItemID = hold.ItemID
-		loan.UserID = hold.UserID
-		loan.CheckoutDate = time.Now()
-		loan.ExpireDate = time.Now().Add(14 * 24 * time.Hour)  // * expires in 14 days
-		loan.RenewableOn = time.Now().Add(11 * 24 * time.Hour) // * 3 days before loan expires
-
-		if err := lr.Create(&loan); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if err := hr.Delete(uint(id)); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if err := help.RearrangeHolds(hold.ItemID, hr, lr, ir); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if _, err := cs.ResolveHold(uint(id), actorFromContext(c)); err != nil {
+			respondError(c, err)
 			return
 		}
 	}
@@ -1,26 +1,28 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	help "github.com/gimtwi/go-library-project/helpers"
 	"github.com/gimtwi/go-library-project/types"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetOrderedFilteredItemsByTitle(ir types.ItemRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var filters types.FilteredRequestBody
-		if err := c.ShouldBindJSON(&filters); err != nil {
+		req, err := bindPageRequest(c)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		items, err := ir.GetAll(string(filters.Order), filters.Filter, filters.Limit)
-
+		items, err := ir.GetAll(*req)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -28,78 +30,124 @@ func GetOrderedFilteredItemsByTitle(ir types.ItemRepository) gin.HandlerFunc {
 	}
 }
 
-func GetItemByID(ir types.ItemRepository) gin.HandlerFunc {
+func SearchItems(ir types.ItemRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "search query is required"})
 			return
 		}
 
-		item, err := ir.GetByID(uint(id))
+		limit := uint(20)
+		if limitStr := c.Query("limit"); limitStr != "" {
+			value, err := strconv.Atoi(limitStr)
+			if err != nil || value < 1 || value > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+				return
+			}
+			limit = uint(value)
+		}
+
+		results, err := ir.Search(query, limit)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, item)
+
+		c.JSON(http.StatusOK, results)
 	}
 }
 
-func GetItemsByAuthorID(ir types.ItemRepository) gin.HandlerFunc {
+func BrowseCatalog(ir types.ItemRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.Atoi(idStr)
+		var filter types.CatalogFilter
+		if err := c.ShouldBindQuery(&filter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if filter.YearFrom > 0 && filter.YearTo > 0 && filter.YearFrom > filter.YearTo {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "yearFrom can't be after yearTo"})
+			return
+		}
+
+		req, err := bindPageRequest(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author id"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		items, err := ir.GetItemsByAuthor(uint(id))
+		page, err := ir.Browse(filter, *req)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "no items found"})
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, items)
+
+		c.JSON(http.StatusOK, page)
 	}
 }
 
-func GetItemsByGenreID(ir types.ItemRepository) gin.HandlerFunc {
+func GetItemByID(ir types.ItemRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid genre id"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
 			return
 		}
 
-		items, err := ir.GetItemsByGenre(uint(id))
+		item, err := ir.GetByID(uint(id))
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "no items found"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
 			return
 		}
-		c.JSON(http.StatusOK, items)
+		c.JSON(http.StatusOK, item)
 	}
 }
 
-func GetItemsByKindID(ir types.ItemRepository) gin.HandlerFunc {
+// * the items of one author, genre or kind are the catalog narrowed down to it, in the same page and with the same facets
+func browseCatalogBy(ir types.ItemRepository, what string, narrow func(id uint) types.CatalogFilter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kind id"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + what + " id"})
 			return
 		}
 
-		items, err := ir.GetItemsByKind(uint(id))
+		req, err := bindPageRequest(c)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "no items found"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, items)
+
+		page, err := ir.Browse(narrow(uint(id)), *req)
+		if err != nil {
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, page)
 	}
 }
 
+func GetItemsByAuthorID(ir types.ItemRepository) gin.HandlerFunc {
+	return browseCatalogBy(ir, "author", func(id uint) types.CatalogFilter {
+		return types.CatalogFilter{AuthorIDs: []uint{id}}
+	})
+}
+
+func GetItemsByGenreID(ir types.ItemRepository) gin.HandlerFunc {
+	return browseCatalogBy(ir, "genre", func(id uint) types.CatalogFilter {
+		return types.CatalogFilter{GenreIDs: []uint{id}}
+	})
+}
+
+func GetItemsByKindID(ir types.ItemRepository) gin.HandlerFunc {
+	return browseCatalogBy(ir, "kind", func(id uint) types.CatalogFilter {
+		return types.CatalogFilter{KindIDs: []uint{id}}
+	})
+}
+
 func CreateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.GenreRepository, kr types.KindRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var item types.Item
@@ -123,7 +171,7 @@ func CreateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.Gen
 	}
 }
 
-func UpdateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.GenreRepository, hr types.HoldRepository, lr types.LoanRepository, kr types.KindRepository) gin.HandlerFunc {
+func UpdateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.GenreRepository, kr types.KindRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
@@ -139,7 +187,7 @@ func UpdateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.Gen
 		}
 		item.ID = uint(id)
 
-		i, err := ir.GetByID(uint(id))
+		_, err = ir.GetByID(uint(id))
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
 			return
@@ -157,13 +205,6 @@ func UpdateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.Gen
 			return
 		}
 
-		if item.Quantity != i.Quantity {
-			if err := help.RearrangeHolds(item.ID, hr, lr, ir); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-		}
-
 		if err := ir.Update(&item); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -172,7 +213,7 @@ func UpdateItem(ir types.ItemRepository, ar types.AuthorRepository, gr types.Gen
 	}
 }
 
-func DeleteItem(repo types.ItemRepository) gin.HandlerFunc {
+func DeleteItem(s *types.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
@@ -181,8 +222,40 @@ func DeleteItem(repo types.ItemRepository) gin.HandlerFunc {
 			return
 		}
 
-		if err := repo.Delete(uint(id)); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		err = s.Transaction(func(tx *types.Store) error {
+			// * a checkout or hold waits for the lock, so nothing is lent or held while the item goes
+			if err := tx.Items.Lock(uint(id)); errors.Is(err, gorm.ErrRecordNotFound) {
+				return fail(http.StatusNotFound, "item not found")
+			} else if err != nil {
+				return err
+			}
+
+			item, err := tx.Items.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "item not found")
+			}
+
+			inCirculation, err := tx.Items.IsInCirculation(item.ID)
+			if err != nil {
+				return err
+			}
+
+			if inCirculation {
+				return fail(http.StatusConflict, types.ErrItemInCirculation.Error())
+			}
+
+			if err := tx.Items.Delete(item.ID); errors.Is(err, types.ErrItemInCirculation) {
+				return fail(http.StatusConflict, err.Error())
+			} else if err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditItemDeleted, "item", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(item, nil)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
 			return
 		}
 		c.Status(http.StatusNoContent)
@@ -5,18 +5,25 @@ import (
 	"strconv"
 	"time"
 
-	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/circulation"
+	"github.com/gimtwi/go-library-project/middleware"
 	"github.com/gimtwi/go-library-project/types"
 	"github.com/gin-gonic/gin"
 )
 
 func GetLoansByUserID(lr types.LoanRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id := userIDParam(c)
 
-		loans, err := lr.GetByUserID(id)
+		req, err := bindPageRequest(c)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "couldn't fetch loans"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		loans, err := lr.ListByUserID(id, *req)
+		if err != nil {
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(http.StatusOK, loans)
@@ -32,82 +39,74 @@ func GetLoansByItemID(lr types.LoanRepository) gin.HandlerFunc {
 			return
 		}
 
-		loans, err := lr.GetByItemID(uint(id))
+		req, err := bindPageRequest(c)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "couldn't fetch loans"})
-			return
-		}
-		c.JSON(http.StatusOK, loans)
-	}
-}
-
-func CreateLoan(lr types.LoanRepository, ir types.ItemRepository, hr types.HoldRepository) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var loan types.Loan
-		if err := c.ShouldBindJSON(&loan); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		item, err := ir.GetByID(loan.ItemID)
+		loans, err := lr.ListByItemID(uint(id), *req)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "item not found"})
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, loans)
+	}
+}
 
-		userLoans, err := lr.GetByUserID(loan.UserID)
+func GetOverdueLoans(lr types.LoanRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loans, err := lr.GetOverdue(time.Now())
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		if len(userLoans) >= 10 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user can't loan more than 10 items"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch loans"})
 			return
 		}
+		c.JSON(http.StatusOK, loans)
+	}
+}
 
-		loans, err := lr.GetByItemID(loan.ItemID)
-		if err != nil {
+func CreateLoan(cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.Loan
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		if uint(len(loans)) >= item.Quantity {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "item is not available"})
+		loan, err := cs.Checkout(req.UserID, req.ItemID, req.CopyID, actorFromContext(c))
+		if err != nil {
+			respondError(c, err)
 			return
 		}
+		c.JSON(http.StatusCreated, loan)
+	}
+}
 
-		holds, err := hr.GetByItemID(loan.ItemID)
-
+func ProlongLoan(cs *circulation.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid loan id"})
 			return
 		}
 
-		if uint(len(holds)) >= item.Quantity {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "item is not available"})
+		userID := middleware.GetUserIDFromTheToken(c)
+		if userID == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
 			return
 		}
 
-		loan.CheckoutDate = time.Now()
-		loan.ExpireDate = time.Now().Add(14 * 24 * time.Hour)  // * expires in 14 days
-		loan.RenewableOn = time.Now().Add(11 * 24 * time.Hour) // * 3 days before loan expires
-
-		if err := lr.Create(&loan); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		loan, err := cs.Renew(uint(id), userID)
+		if err != nil {
+			respondError(c, err)
 			return
 		}
-		c.JSON(http.StatusCreated, loan)
-	}
-}
-
-func ProlongLoan(lr types.LoanRepository) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		//TODO enable for user to prolong the loan if there are no pending holds
+		c.JSON(http.StatusOK, loan)
 	}
 }
 
-func ReturnTheItem(lr types.LoanRepository, hr types.HoldRepository, ir types.ItemRepository) gin.HandlerFunc {
+func ReturnTheItem(cs *circulation.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
@@ -116,19 +115,8 @@ func ReturnTheItem(lr types.LoanRepository, hr types.HoldRepository, ir types.It
 			return
 		}
 
-		loan, err := lr.GetByID(uint(id))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if err := lr.Delete(uint(id)); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if err := help.RearrangeHolds(loan.ItemID, hr, lr, ir); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if _, err := cs.Return(uint(id), actorFromContext(c)); err != nil {
+			respondError(c, err)
 			return
 		}
 
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * answers with 429 and a Retry-After header while the login is throttled
+func abortThrottled(c *gin.Context, err error) {
+	var throttled *help.ThrottledError
+	if !errors.As(err, &throttled) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": throttled.Error()})
+}
+
+// * a failing store must not turn a wrong password into a different answer
+func registerLoginFailure(c *gin.Context, username string, tr types.LoginThrottleRepository) {
+	if err := help.RegisterLoginFailure(c.ClientIP(), username, time.Now(), tr); err != nil {
+		log.Println(err)
+	}
+}
+
+// * must be performed by admin
+func GetLockedAccounts(tr types.LoginThrottleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		throttles, err := tr.GetLocked(time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, throttles)
+	}
+}
+
+// * must be performed by admin, also clears the failed logins so the user gets the free attempts back
+func UnlockUser(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := s.Transaction(func(tx *types.Store) error {
+			user, err := tx.Users.GetByID(c.Param("id"))
+			if err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			if err := help.ResetLoginThrottle(user.Username, tx.LoginThrottles); err != nil {
+				return err
+			}
+
+			return tx.Audit.Record(auditEntry(c, types.AuditUserUnlocked, "user", user.ID))
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "user was unlocked!"})
+	}
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/oidc"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * sends the browser to the identity provider
+func StartOIDCLogin(p *oidc.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var values [3]string
+		for i := range values {
+			value, err := oidc.RandomString()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start the login"})
+				return
+			}
+			values[i] = value
+		}
+		state, nonce, verifier := values[0], values[1], values[2]
+
+		token, err := middleware.GenerateOIDCStateToken(state, nonce, verifier)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start the login"})
+			return
+		}
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(middleware.OIDCStateCookie, token, int(middleware.OIDCStateTTL.Seconds()), "/", "", false, true)
+		c.Redirect(http.StatusFound, p.AuthCodeURL(state, nonce, verifier))
+	}
+}
+
+// * the identity provider sends the browser back here with the authorization code
+func FinishOIDCLogin(p *oidc.Provider, ur types.UserRepository, sr types.SessionRepository, ir types.IdentityRepository, rr types.RoleRepository, tr types.LoginThrottleRepository, ar types.AuditRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if reason := c.Query("error"); reason != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the identity provider refused the login: " + reason})
+			return
+		}
+
+		stateToken, err := c.Cookie(middleware.OIDCStateCookie)
+		if err != nil || stateToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "login has expired, please sign in again"})
+			return
+		}
+		c.SetCookie(middleware.OIDCStateCookie, "", -1, "/", "", false, true)
+
+		state, nonce, verifier, err := middleware.ValidateOIDCStateToken(stateToken)
+		if err != nil || state != c.Query("state") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "login has expired, please sign in again"})
+			return
+		}
+
+		claims, err := p.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+		if err != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, "", "single sign-on: "+err.Error())
+			log.Printf("oidc login failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the login couldn't be verified"})
+			return
+		}
+
+		user, err := oidc.LinkUser(claims, p.Config(), ur, ir, rr, ar, actorFromContext(c))
+		if errors.Is(err, oidc.ErrNoAccount) || errors.Is(err, oidc.ErrEmailTaken) {
+			auditLogin(c, ar, types.AuditLoginFailed, "", "single sign-on of "+strconv.Quote(claims.Subject)+": "+err.Error())
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// * an account locked after failed logins stays locked for single sign-on too
+		if err := help.CheckLoginThrottle(c.ClientIP(), user.Username, time.Now(), tr); err != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, user.ID, "throttled single sign-on")
+			abortThrottled(c, err)
+			return
+		}
+
+		// * the local second factor still applies to accounts that enabled it
+		if user.TOTPEnabled {
+			token, err := middleware.GenerateTwoFactorToken(user)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create token"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"twoFactorRequired": true, "token": token})
+			return
+		}
+
+		if _, err := startSession(c, user, sr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create token"})
+			return
+		}
+
+		auditLogin(c, ar, types.AuditLogin, user.ID, "single sign-on")
+
+		// * OIDC_POST_LOGIN_URL is where the browser lands once signed in
+		if target := os.Getenv("OIDC_POST_LOGIN_URL"); target != "" {
+			c.Redirect(http.StatusFound, target)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "user is successfully authenticated!"})
+	}
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * query parameters win over the json body older clients still send
+func bindPageRequest(c *gin.Context) (*types.PageRequest, error) {
+	var req types.PageRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.ShouldBindQuery(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func pageErrorStatus(err error) int {
+	if errors.Is(err, types.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
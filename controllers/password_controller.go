@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/notify"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultPasswordResetTTL = time.Hour
+
+// * PASSWORD_RESET_TTL is how long an emailed reset token stays valid
+func passwordResetTTL() time.Duration {
+	value, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL"))
+	if err != nil || value <= 0 {
+		return defaultPasswordResetTTL
+	}
+	return value
+}
+
+// * always answers the same so that nobody can find out which emails have an account
+func ForgotPassword(ur types.UserRepository, pr types.PasswordResetRepository, n notify.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.ForgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := gin.H{"message": "if the account exists, an email with reset instructions was sent"}
+
+		user, err := ur.GetByUniqueField("email", req.Email)
+		if err != nil {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		// * the token is made and sent in the background, answering after the database work or a slow mail server
+		// * would tell that the account exists
+		go func(user *types.User) {
+			token, hash, err := middleware.NewRandomToken()
+			if err != nil {
+				log.Printf("failed to create the password reset token: %v", err)
+				return
+			}
+
+			now := time.Now()
+			if err := pr.InvalidateByUserID(user.ID, now); err != nil {
+				log.Printf("failed to invalidate the old password reset tokens: %v", err)
+				return
+			}
+
+			reset := types.PasswordResetToken{UserID: user.ID, TokenHash: hash, ExpiresAt: now.Add(passwordResetTTL())}
+			if err := pr.Create(&reset); err != nil {
+				log.Printf("failed to save the password reset token: %v", err)
+				return
+			}
+
+			subject, body := notify.NewPasswordReset(token, reset.ExpiresAt)
+			if err := n.Send(user.Email, subject, body); err != nil {
+				log.Printf("failed to send the password reset email: %v", err)
+			}
+		}(user)
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func ResetPassword(ur types.UserRepository, pr types.PasswordResetRepository, sr types.SessionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.ResetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+
+		reset, err := pr.Consume(middleware.HashToken(req.Token), now)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+
+		user, err := ur.GetByID(reset.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+
+		hash, err := user.HashPassword(req.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to hash the password"})
+			return
+		}
+
+		user.Password = hash
+
+		if err := ur.Update(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := pr.InvalidateByUserID(user.ID, now); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := sr.RevokeAllByUserID(user.ID, now); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		clearAuthCookies(c)
+
+		c.JSON(http.StatusOK, gin.H{"message": "password was reset successfully!"})
+	}
+}
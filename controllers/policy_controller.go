@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// * must be performed by admin
+func GetCirculationRules(rr types.CirculationRuleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules, err := rr.GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch circulation rules"})
+			return
+		}
+		c.JSON(http.StatusOK, rules)
+	}
+}
+
+// * the kind must exist and every pair of category and kind has only one rule
+func checkRuleCell(tx *types.Store, rule *types.CirculationRule) error {
+	if err := rule.Validate(); err != nil {
+		return fail(http.StatusBadRequest, err.Error())
+	}
+
+	if rule.KindID != nil {
+		if _, err := tx.Kinds.GetByID(*rule.KindID); err != nil {
+			return fail(http.StatusBadRequest, "kind not found")
+		}
+	}
+
+	existing, err := tx.Rules.GetByCell(rule.PatronCategory, rule.KindID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if existing.ID != rule.ID {
+		return fail(http.StatusConflict, types.ErrRuleCellTaken.Error())
+	}
+	return nil
+}
+
+// * must be performed by admin
+func CreateCirculationRule(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule types.CirculationRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rule.ID = 0
+
+		err := s.Transaction(func(tx *types.Store) error {
+			if err := checkRuleCell(tx, &rule); err != nil {
+				return err
+			}
+
+			if err := tx.Rules.Create(&rule); errors.Is(err, types.ErrRuleCellTaken) {
+				return fail(http.StatusConflict, err.Error())
+			} else if err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRuleCreated, "circulation_rule", strconv.FormatUint(uint64(rule.ID), 10))
+			entry.Changes = types.AuditDiff(nil, rule)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, rule)
+	}
+}
+
+// * must be performed by admin, the request replaces the whole rule so that cleared fields fall through again
+func UpdateCirculationRule(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+			return
+		}
+
+		var req types.CirculationRule
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var rule *types.CirculationRule
+		err = s.Transaction(func(tx *types.Store) error {
+			rule, err = tx.Rules.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "rule not found")
+			}
+
+			before := *rule
+			req.ID = rule.ID
+			req.CreatedAt = rule.CreatedAt
+			*rule = req
+
+			if err := checkRuleCell(tx, rule); err != nil {
+				return err
+			}
+
+			if err := tx.Rules.Update(rule); errors.Is(err, types.ErrRuleCellTaken) {
+				return fail(http.StatusConflict, err.Error())
+			} else if err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRuleUpdated, "circulation_rule", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(before, rule)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// * must be performed by admin, the patrons and items of the rule fall back to the less specific rules
+func DeleteCirculationRule(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+			return
+		}
+
+		err = s.Transaction(func(tx *types.Store) error {
+			rule, err := tx.Rules.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "rule not found")
+			}
+
+			if err := tx.Rules.Delete(rule.ID); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRuleDeleted, "circulation_rule", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(rule, nil)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+func GetPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, types.AllPermissions)
+	}
+}
+
+func GetAllRoles(rr types.RoleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles, err := rr.GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, roles)
+	}
+}
+
+func GetRoleByID(rr types.RoleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role id"})
+			return
+		}
+
+		role, err := rr.GetByID(uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
+			return
+		}
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+func applyRoleRequest(role *types.Role, req *types.RoleRequest) error {
+	role.Name = req.Name
+	role.Description = req.Description
+	role.Permissions = req.Permissions
+	role.RequireTwoFactor = req.RequireTwoFactor
+
+	if role.Permissions == nil {
+		role.Permissions = []types.Permission{}
+	}
+	return role.ValidatePermissions()
+}
+
+func CreateRole(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.RoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var role types.Role
+		if err := applyRoleRequest(&role, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err := s.Transaction(func(tx *types.Store) error {
+			if err := tx.Roles.Create(&role); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRoleCreated, "role", strconv.FormatUint(uint64(role.ID), 10))
+			entry.Changes = types.AuditDiff(nil, role)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, role)
+	}
+}
+
+// * the admin role always holds every permission so that nobody can lock the library out of its own settings
+func UpdateRole(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role id"})
+			return
+		}
+
+		var req types.RoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var role *types.Role
+		err = s.Transaction(func(tx *types.Store) error {
+			role, err = tx.Roles.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "role not found")
+			}
+
+			before := *role
+			if err := applyRoleRequest(role, &req); err != nil {
+				return fail(http.StatusBadRequest, err.Error())
+			}
+
+			if role.ID == types.AdminRoleID {
+				role.Permissions = types.AllPermissions
+			}
+
+			if err := tx.Roles.Update(role); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRoleUpdated, "role", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(before, role)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+func DeleteRole(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role id"})
+			return
+		}
+
+		err = s.Transaction(func(tx *types.Store) error {
+			role, err := tx.Roles.GetByID(uint(id))
+			if err != nil {
+				return fail(http.StatusNotFound, "role not found")
+			}
+
+			if role.Builtin {
+				return fail(http.StatusBadRequest, "built in roles can't be deleted")
+			}
+
+			count, err := tx.Roles.CountUsers(role.ID)
+			if err != nil {
+				return err
+			}
+
+			if count > 0 {
+				return fail(http.StatusBadRequest, "cannot delete role with assigned users")
+			}
+
+			if err := tx.Roles.Delete(role.ID); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRoleDeleted, "role", strconv.Itoa(id))
+			entry.Changes = types.AuditDiff(role, nil)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "role was deleted successfully!"})
+	}
+}
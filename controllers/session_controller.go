@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func setAuthCookies(c *gin.Context, accessToken, refreshToken string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(os.Getenv("COOKIE_NAME"), accessToken, int(middleware.AccessTokenTTL().Seconds()), "/", "", false, true) //secure false only on localhost, change to true in prod
+	c.SetCookie(middleware.RefreshCookieName(), refreshToken, int(middleware.RefreshTokenTTL().Seconds()), "/", "", false, true)
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie(os.Getenv("COOKIE_NAME"), "", -1, "/", "", false, true)
+	c.SetCookie(middleware.RefreshCookieName(), "", -1, "/", "", false, true)
+}
+
+func newTokenResponse(accessToken, refreshToken string) *types.TokenResponse {
+	return &types.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(middleware.AccessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+	}
+}
+
+// * cookie clients only get a message, token clients get the tokens themselves
+func respondAuthenticated(c *gin.Context, tokens *types.TokenResponse, inBody bool, message string) {
+	if inBody {
+		c.JSON(http.StatusOK, tokens)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// * opens a new session for the user and hands out its tokens
+func startSession(c *gin.Context, user *types.User, sr types.SessionRepository) (*types.TokenResponse, error) {
+	refreshToken, hash, err := middleware.NewRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := types.Session{
+		ID:               uuid.NewString(),
+		UserID:           user.ID,
+		RefreshTokenHash: hash,
+		UserAgent:        c.Request.UserAgent(),
+		IP:               c.ClientIP(),
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(middleware.RefreshTokenTTL()),
+	}
+
+	if err := sr.Create(&session); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := middleware.GenerateJWT(user, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	setAuthCookies(c, accessToken, refreshToken)
+	return newTokenResponse(accessToken, refreshToken), nil
+}
+
+// * swaps the refresh token for a new pair, a token that was already swapped revokes the whole session
+func RefreshSession(ur types.UserRepository, sr types.SessionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// * a token from the body is answered in the body
+		refreshToken, inBody := req.RefreshToken, req.RefreshToken != ""
+		if !inBody {
+			refreshToken, _ = c.Cookie(middleware.RefreshCookieName())
+		}
+
+		if refreshToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing refresh token"})
+			return
+		}
+
+		hash := middleware.HashToken(refreshToken)
+		now := time.Now()
+
+		session, err := sr.GetByRefreshTokenHash(hash)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if reused, err := sr.GetByPreviousTokenHash(hash); err == nil {
+				if err := sr.Revoke(reused.ID, now); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !session.IsActive(now) {
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session has expired"})
+			return
+		}
+
+		user, err := ur.GetByID(session.UserID)
+		if err != nil {
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		newRefreshToken, newHash, err := middleware.NewRandomToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+			return
+		}
+
+		session.PreviousTokenHash = session.RefreshTokenHash
+		session.RefreshTokenHash = newHash
+		session.LastUsedAt = now
+		session.UserAgent = c.Request.UserAgent()
+		session.IP = c.ClientIP()
+
+		rotated, err := sr.Rotate(session, hash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// * another request rotated the same token first, one of the two holds a stolen copy
+		if !rotated {
+			if err := sr.Revoke(session.ID, now); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+
+		accessToken, err := middleware.GenerateJWT(user, session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+			return
+		}
+
+		setAuthCookies(c, accessToken, newRefreshToken)
+		respondAuthenticated(c, newTokenResponse(accessToken, newRefreshToken), inBody, "session was refreshed!")
+	}
+}
+
+func GetOwnSessions(sr types.SessionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := middleware.GetUserIDFromTheToken(c)
+		if userID == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		sessions, err := sr.GetActiveByUserID(userID, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldn't fetch sessions"})
+			return
+		}
+
+		var currentID string
+		if current := middleware.GetSessionFromContext(c); current != nil {
+			currentID = current.ID
+		}
+
+		res := make([]types.SessionResponse, len(sessions))
+		for i, session := range sessions {
+			res[i] = types.SessionResponse{Session: session, Current: session.ID == currentID}
+		}
+
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func RevokeSession(sr types.SessionRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		userID := middleware.GetUserIDFromTheToken(c)
+		if userID == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		session, err := sr.GetByID(id)
+		if err != nil || session.UserID != userID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+
+		if err := sr.Revoke(session.ID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if current := middleware.GetSessionFromContext(c); current != nil && current.ID == session.ID {
+			clearAuthCookies(c)
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// * must be performed by admin, signs the user out everywhere
+func RevokeUserSessions(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		err := s.Transaction(func(tx *types.Store) error {
+			if _, err := tx.Users.GetByID(id); err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			if err := tx.Sessions.RevokeAllByUserID(id, time.Now()); err != nil {
+				return err
+			}
+
+			return tx.Audit.Record(auditEntry(c, types.AuditSessionsRevoked, "user", id))
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "all sessions of the user were revoked!"})
+	}
+}
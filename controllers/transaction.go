@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gimtwi/go-library-project/circulation"
+	"github.com/gin-gonic/gin"
+)
+
+// * ends a transaction with a specific answer, the changes made so far are rolled back
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func fail(status int, message string) error {
+	return &statusError{status, message}
+}
+
+var circulationStatus = map[circulation.ErrorKind]int{
+	circulation.NotFound:  http.StatusNotFound,
+	circulation.Forbidden: http.StatusForbidden,
+	circulation.Conflict:  http.StatusConflict,
+	circulation.Invalid:   http.StatusBadRequest,
+}
+
+// * unexpected errors of a transaction are logged and answered with 500, their text stays out of the response
+func respondError(c *gin.Context, err error) {
+	var failed *statusError
+	if errors.As(err, &failed) {
+		c.JSON(failed.status, gin.H{"error": failed.message})
+		return
+	}
+
+	if status, ok := circulationStatus[circulation.KindOf(err)]; ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("%s %s failed: %v", c.Request.Method, c.FullPath(), err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * accepts a TOTP code or one of the recovery codes
+func checkSecondFactor(user *types.User, code string, ur types.UserRepository, rr types.RecoveryCodeRepository) error {
+	now := time.Now()
+
+	step, err := help.ValidateTOTP(user.TOTPSecret, code, now, user.TOTPLastStep)
+	if err == nil {
+		user.TOTPLastStep = step
+		return ur.Update(user)
+	}
+
+	used, recoveryErr := rr.Consume(user.ID, middleware.HashToken(help.NormalizeRecoveryCode(code)), now)
+	if recoveryErr != nil {
+		return recoveryErr
+	}
+
+	if !used {
+		return err
+	}
+	return nil
+}
+
+func issueRecoveryCodes(userID string, rr types.RecoveryCodeRepository) ([]string, error) {
+	codes, err := help.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = middleware.HashToken(help.NormalizeRecoveryCode(code))
+	}
+
+	if err := rr.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func LoginTwoFactor(ur types.UserRepository, sr types.SessionRepository, rr types.RecoveryCodeRepository, tr types.LoginThrottleRepository, ar types.AuditRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.TwoFactorLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id, err := middleware.ValidateTwoFactorToken(req.Token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login has expired, please sign in again"})
+			return
+		}
+
+		user, err := ur.GetByID(id)
+		if err != nil || !user.TOTPEnabled {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login has expired, please sign in again"})
+			return
+		}
+
+		// * codes are guessed far easier than passwords, so they count towards the same lockout
+		if err := help.CheckLoginThrottle(c.ClientIP(), user.Username, time.Now(), tr); err != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, user.ID, "throttled second factor")
+			abortThrottled(c, err)
+			return
+		}
+
+		if err := checkSecondFactor(user, req.Code, ur, rr); err != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, user.ID, "wrong second factor")
+			registerLoginFailure(c, user.Username, tr)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		tokens, err := startSession(c, user, sr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create token"})
+			return
+		}
+
+		if err := help.ResetLoginThrottle(user.Username, tr); err != nil {
+			log.Println(err)
+		}
+
+		auditLogin(c, ar, types.AuditLogin, user.ID, "password and second factor")
+		respondAuthenticated(c, tokens, req.ReturnTokens, "user is successfully authenticated!")
+	}
+}
+
+// * the secret only becomes active once a code from it is confirmed
+func EnrollTwoFactor(ur types.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		if user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := help.GenerateTOTPSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create secret"})
+			return
+		}
+
+		user.TOTPSecret = secret
+		user.TOTPLastStep = 0
+
+		if err := ur.Update(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, types.TwoFactorEnrollment{Secret: secret, URI: help.TOTPProvisioningURI(secret, user.Username)})
+	}
+}
+
+func ConfirmTwoFactor(ur types.UserRepository, rr types.RecoveryCodeRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		if user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		if user.TOTPSecret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start the enrollment first"})
+			return
+		}
+
+		step, err := help.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+
+		if err := ur.Update(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		codes, err := issueRecoveryCodes(user.ID, rr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
+
+func RegenerateRecoveryCodes(ur types.UserRepository, rr types.RecoveryCodeRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		if !user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+
+		step, err := help.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user.TOTPLastStep = step
+		if err := ur.Update(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		codes, err := issueRecoveryCodes(user.ID, rr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
+
+func DisableTwoFactor(ur types.UserRepository, rr types.RecoveryCodeRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		if !user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
+			return
+		}
+
+		if user.Role != nil && user.Role.RequireTwoFactor {
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for your role"})
+			return
+		}
+
+		if err := checkSecondFactor(user, req.Code, ur, rr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+			return
+		}
+
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+
+		if err := ur.Update(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := rr.DeleteByUserID(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication was disabled!"})
+	}
+}
+
+// * must be performed by admin, for staff who lost both their authenticator and their recovery codes
+func ResetUserTwoFactor(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		err := s.Transaction(func(tx *types.Store) error {
+			user, err := tx.Users.GetByID(id)
+			if err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			before := user.ConvertToUserResponse()
+			user.TOTPEnabled = false
+			user.TOTPSecret = ""
+			user.TOTPLastStep = 0
+
+			if err := tx.Users.Update(user); err != nil {
+				return err
+			}
+
+			if err := tx.RecoveryCodes.DeleteByUserID(user.ID); err != nil {
+				return err
+			}
+
+			if err := tx.Sessions.RevokeAllByUserID(user.ID, time.Now()); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditTwoFactorReset, "user", user.ID)
+			entry.Changes = types.AuditDiff(before, user.ConvertToUserResponse())
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication of the user was reset!"})
+	}
+}
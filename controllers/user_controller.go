@@ -1,10 +1,15 @@
 package controllers
 
 import (
+	"log"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	help "github.com/gimtwi/go-library-project/helpers"
 	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/notify"
 	"github.com/gimtwi/go-library-project/types"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -12,24 +17,33 @@ import (
 
 func GetAllUsers(ur types.UserRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		users, err := ur.GetAll()
+		req, err := bindPageRequest(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		converted := make([]*types.UserResponse, len(users))
 
-		for i, user := range users {
-			converted[i] = user.ConvertToUserResponse()
+		users, err := ur.GetAll(*req)
+		if err != nil {
+			c.JSON(pageErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		c.JSON(http.StatusOK, converted)
+		c.JSON(http.StatusOK, types.MapPage(users, (*types.User).ConvertToUserResponse))
 	}
 }
 
+// * the /me routes have no :id, they are about the user of the token
+func userIDParam(c *gin.Context) string {
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	return middleware.GetUserIDFromTheToken(c)
+}
+
 func GetUserByID(ur types.UserRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id := userIDParam(c)
 
 		user, err := ur.GetByID(id)
 		if err != nil {
@@ -41,20 +55,42 @@ func GetUserByID(ur types.UserRepository) gin.HandlerFunc {
 	}
 }
 
-func RegisterUser(ur types.UserRepository) gin.HandlerFunc {
+func RegisterUser(s *types.Store, n notify.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var user types.User
-		if err := c.ShouldBindJSON(&user); err != nil {
+		var req types.RegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		if _, err := ur.GetByUniqueField("username", user.Username); err == nil {
+		user := types.User{
+			LibraryCard: req.LibraryCard,
+			Category:    strings.ToLower(strings.TrimSpace(req.Category)), // * left empty the patron is an adult
+			FirstName:   req.FirstName,
+			LastName:    req.LastName,
+			Username:    req.Username,
+			Email:       req.Email,
+			PhoneNumber: req.PhoneNumber,
+			Address:     req.Address,
+		}
+
+		actor := actorFromContext(c)
+		if req.BranchID == nil {
+			req.BranchID = actor.BranchID
+		}
+
+		if req.BranchID != nil && !actor.WorksAt(*req.BranchID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only register users at your branch"})
+			return
+		}
+		user.BranchID = req.BranchID
+
+		if _, err := s.Users.GetByUniqueField("username", user.Username); err == nil {
 			c.JSON(http.StatusConflict, gin.H{"error": "invalid username"})
 			return
 		}
 
-		if _, err := ur.GetByUniqueField("email", user.Email); err == nil {
+		if _, err := s.Users.GetByUniqueField("email", user.Email); err == nil {
 			c.JSON(http.StatusConflict, gin.H{"error": "invalid email"})
 			return
 		}
@@ -64,7 +100,7 @@ func RegisterUser(ur types.UserRepository) gin.HandlerFunc {
 			return
 		}
 
-		hash, err := user.HashPassword(user.Password)
+		hash, err := user.HashPassword(req.Password)
 
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to hash the password"})
@@ -72,19 +108,38 @@ func RegisterUser(ur types.UserRepository) gin.HandlerFunc {
 		}
 
 		user.ID = uuid.NewString()
-		user.Role = types.Member
+		user.RoleID = types.MemberRoleID
 		user.Password = hash
 
-		if ur.Create(&user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		err = s.Transaction(func(tx *types.Store) error {
+			if user.BranchID != nil {
+				if _, err := tx.Branches.GetByID(*user.BranchID); err != nil {
+					return fail(http.StatusNotFound, "branch not found")
+				}
+			}
+
+			if err := tx.Users.Create(&user); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditUserRegistered, "user", user.ID)
+			entry.Changes = types.AuditDiff(nil, user.ConvertToUserResponse())
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
 			return
 		}
 
+		if err := sendVerificationEmail(&user, n); err != nil {
+			log.Printf("failed to send the verification email: %v", err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{"message": "user is successfully registered!"})
 	}
 }
 
-func Login(ur types.UserRepository) gin.HandlerFunc {
+func Login(ur types.UserRepository, sr types.SessionRepository, tr types.LoginThrottleRepository, ar types.AuditRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req types.LoginRequest
 
@@ -93,8 +148,16 @@ func Login(ur types.UserRepository) gin.HandlerFunc {
 			return
 		}
 
+		if err := help.CheckLoginThrottle(c.ClientIP(), req.Username, time.Now(), tr); err != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, "", "throttled login as "+strconv.Quote(req.Username))
+			abortThrottled(c, err)
+			return
+		}
+
 		user, err := ur.GetByUniqueField("username", req.Username)
 		if err != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, "", "unknown username "+strconv.Quote(req.Username))
+			registerLoginFailure(c, req.Username, tr)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid username or password"})
 			return
 		}
@@ -102,52 +165,216 @@ func Login(ur types.UserRepository) gin.HandlerFunc {
 		errPWD := user.CheckPassword(req.Password)
 
 		if errPWD != nil {
+			auditLogin(c, ar, types.AuditLoginFailed, user.ID, "wrong password")
+			registerLoginFailure(c, req.Username, tr)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid username or password"})
 			return
 		}
 
-		token, err := middleware.GenerateJWT(user)
+		// * the session is only started once the second factor is checked, the failures count until then
+		if user.TOTPEnabled {
+			token, err := middleware.GenerateTwoFactorToken(user)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create token"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"twoFactorRequired": true, "token": token})
+			return
+		}
 
+		tokens, err := startSession(c, user, sr)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to create token"})
 			return
 		}
 
-		c.SetSameSite(http.SameSiteLaxMode)
-		c.SetCookie(os.Getenv("COOKIE_NAME"), token, 3600*24*30, "", "", false, true) //secure false only on localhost, change to true in prod
+		if err := help.ResetLoginThrottle(user.Username, tr); err != nil {
+			log.Println(err)
+		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "user is successfully authenticated!"})
+		auditLogin(c, ar, types.AuditLogin, user.ID, "password")
+		respondAuthenticated(c, tokens, req.ReturnTokens, "user is successfully authenticated!")
 	}
 }
 
-func Logout(ur types.UserRepository) gin.HandlerFunc {
+func Logout(sr types.SessionRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.SetCookie(os.Getenv("COOKIE_NAME"), "", -1, "/", "", false, true)
+		session := middleware.GetSessionFromContext(c)
+
+		// * an expired access token still lets the refresh token end the session
+		if session == nil {
+			if refreshToken, err := c.Cookie(middleware.RefreshCookieName()); err == nil && refreshToken != "" {
+				session, _ = sr.GetByRefreshTokenHash(middleware.HashToken(refreshToken))
+			}
+		}
+
+		if session != nil {
+			if err := sr.Revoke(session.ID, time.Now()); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		clearAuthCookies(c)
 		c.JSON(http.StatusOK, gin.H{"message": "ok bye!"})
 	}
 }
 
-func AssignRole(ur types.UserRepository, role types.UserRole) gin.HandlerFunc {
+// * must be performed by admin
+func AssignRole(s *types.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var req types.AssignRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		id := c.Param("id")
 
-		user, err := ur.GetByID(id)
+		err := s.Transaction(func(tx *types.Store) error {
+			user, err := tx.Users.GetByID(id)
+			if err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			role, err := tx.Roles.GetByID(req.RoleID)
+			if err != nil {
+				return fail(http.StatusBadRequest, "role not found")
+			}
+
+			before := user.ConvertToUserResponse()
+			user.RoleID = role.ID
+			user.Role = role
+
+			if err := tx.Users.Update(user); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditRoleAssigned, "user", user.ID)
+			entry.Changes = types.AuditDiff(before, user.ConvertToUserResponse())
+			return tx.Audit.Record(entry)
+		})
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "user was successfully assigned a new role!"})
+	}
+}
+
+// * must be performed by staff managing users, the category decides which circulation rules apply to the patron
+// * staff of one branch only look after the users of that branch, users without one are left to staff of every branch
+func looksAfter(actor types.Actor, user *types.User) bool {
+	if actor.BranchID == nil {
+		return true
+	}
+	return user.BranchID != nil && actor.WorksAt(*user.BranchID)
+}
+
+func AssignCategory(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.AssignCategoryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		user.Role = role
+		category := strings.ToLower(strings.TrimSpace(req.Category))
+		if category == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category"})
+			return
+		}
 
-		if err := ur.Update(user); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		id := c.Param("id")
+		actor := actorFromContext(c)
+
+		var user *types.User
+		err := s.Transaction(func(tx *types.Store) error {
+			var err error
+			user, err = tx.Users.GetByID(id)
+			if err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			if !looksAfter(actor, user) {
+				return fail(http.StatusForbidden, "user belongs to another branch")
+			}
+
+			before := user.ConvertToUserResponse()
+			user.Category = category
+
+			if err := tx.Users.Update(user); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditCategoryChanged, "user", user.ID)
+			entry.Changes = types.AuditDiff(before, user.ConvertToUserResponse())
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "user was successfully assigned a new role!"})
+		c.JSON(http.StatusOK, user.ConvertToUserResponse())
 	}
 }
 
-func ChangePassword(ur types.UserRepository) gin.HandlerFunc {
+// * the home branch of a patron or the branch a staff member works at, staff of one branch can't let anybody work at every branch
+func AssignBranch(s *types.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req types.AssignBranchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		actor := actorFromContext(c)
+		if actor.BranchID != nil && (req.BranchID == nil || !actor.WorksAt(*req.BranchID)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only assign users to your branch"})
+			return
+		}
+
+		id := c.Param("id")
+
+		var user *types.User
+		err := s.Transaction(func(tx *types.Store) error {
+			if req.BranchID != nil {
+				if _, err := tx.Branches.GetByID(*req.BranchID); err != nil {
+					return fail(http.StatusNotFound, "branch not found")
+				}
+			}
+
+			var err error
+			user, err = tx.Users.GetByID(id)
+			if err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			if !looksAfter(actor, user) {
+				return fail(http.StatusForbidden, "user belongs to another branch")
+			}
+
+			before := user.ConvertToUserResponse()
+			user.BranchID = req.BranchID
+
+			if err := tx.Users.Update(user); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditBranchAssigned, "user", user.ID)
+			entry.Changes = types.AuditDiff(before, user.ConvertToUserResponse())
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, user.ConvertToUserResponse())
+	}
+}
+
+func ChangePassword(ur types.UserRepository, sr types.SessionRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req types.ChangePasswordRequest
 
@@ -184,19 +411,44 @@ func ChangePassword(ur types.UserRepository) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		Logout(ur)
+
+		// * a stolen session must not survive the password change
+		if err := sr.RevokeAllByUserID(user.ID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		clearAuthCookies(c)
 
 		c.JSON(http.StatusOK, gin.H{"message": "password was changed successfully!"})
 
 	}
 }
 
-func DeleteUser(ur types.UserRepository) gin.HandlerFunc {
+func DeleteUser(s *types.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-
-		if err := ur.Delete(id); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		actor := actorFromContext(c)
+
+		err := s.Transaction(func(tx *types.Store) error {
+			user, err := tx.Users.GetByID(id)
+			if err != nil {
+				return fail(http.StatusNotFound, "user not found")
+			}
+
+			if !looksAfter(actor, user) {
+				return fail(http.StatusForbidden, "user belongs to another branch")
+			}
+
+			if err := tx.Users.Delete(user.ID); err != nil {
+				return err
+			}
+
+			entry := auditEntry(c, types.AuditUserDeleted, "user", user.ID)
+			entry.Changes = types.AuditDiff(user.ConvertToUserResponse(), nil)
+			return tx.Audit.Record(entry)
+		})
+		if err != nil {
+			respondError(c, err)
 			return
 		}
 		c.Status(http.StatusNoContent)
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/notify"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+func sendVerificationEmail(user *types.User, n notify.Notifier) error {
+	token, expiresAt, err := middleware.GenerateEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	subject, body := notify.NewEmailVerification(token, expiresAt)
+	return n.Send(user.Email, subject, body)
+}
+
+func VerifyEmail(ur types.UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing verification token"})
+			return
+		}
+
+		id, email, err := middleware.ValidateEmailVerificationToken(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+			return
+		}
+
+		user, err := ur.GetByID(id)
+		if err != nil || user.Email != email {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+			return
+		}
+
+		if user.IsVerified() {
+			c.JSON(http.StatusOK, gin.H{"message": "email is already verified!"})
+			return
+		}
+
+		user.Verified = time.Now().UTC().Format(time.RFC3339)
+
+		if err := ur.Update(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "email was verified successfully!"})
+	}
+}
+
+func ResendVerificationEmail(n notify.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.GetUserFromContext(c)
+		if user == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+
+		if user.IsVerified() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is already verified"})
+			return
+		}
+
+		if err := sendVerificationEmail(user, n); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send the verification email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "verification email was sent!"})
+	}
+}
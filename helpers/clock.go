@@ -0,0 +1,14 @@
+package help
+
+import "time"
+
+// * lets background jobs and tests decide what "now" is
+type Clock interface {
+	Now() time.Time
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
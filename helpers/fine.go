@@ -0,0 +1,83 @@
+package help
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"gorm.io/gorm"
+)
+
+const defaultDailyFine = 25 // * 0.25 per day for kinds without their own fine
+
+// * DEFAULT_DAILY_FINE is used for items whose kinds don't set a daily fine
+func defaultFine() uint {
+	value, err := strconv.ParseUint(os.Getenv("DEFAULT_DAILY_FINE"), 10, 32)
+	if err != nil {
+		return defaultDailyFine
+	}
+	return uint(value)
+}
+
+// * the most expensive kind of the item decides the daily fine
+func DailyFine(item *types.Item) uint {
+	var fine uint
+	for _, kind := range item.Kinds {
+		if kind.DailyFine > fine {
+			fine = kind.DailyFine
+		}
+	}
+
+	if fine == 0 {
+		return defaultFine()
+	}
+	return fine
+}
+
+// * every started day after the expire date counts
+func OverdueDays(loan *types.Loan, now time.Time) uint {
+	if !now.After(loan.ExpireDate) {
+		return 0
+	}
+	return uint(math.Ceil(now.Sub(loan.ExpireDate).Hours() / 24))
+}
+
+// * keeps a single charge per overdue loan up to date with the days it has been overdue
+func AssessFine(loan *types.Loan, now time.Time, fr types.FineRepository, ir types.ItemRepository) error {
+	days := OverdueDays(loan, now)
+	if days == 0 {
+		return nil
+	}
+
+	item, err := ir.GetByID(loan.ItemID)
+	if err != nil {
+		return err
+	}
+
+	amount := days * DailyFine(item)
+
+	charge, err := fr.GetChargeByLoanID(loan.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		loanID := loan.ID
+		return fr.Create(&types.Fine{
+			UserID: loan.UserID,
+			LoanID: &loanID,
+			Type:   types.Charge,
+			Amount: amount,
+			Note:   fmt.Sprintf("%q is overdue", item.Title),
+		})
+	} else if err != nil {
+		return err
+	}
+
+	if charge.Amount == amount {
+		return nil
+	}
+
+	charge.Amount = amount
+	return fr.Update(charge)
+}
@@ -0,0 +1,149 @@
+package help
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"gorm.io/gorm"
+)
+
+const (
+	loginBackoffBase        = time.Second
+	loginBackoffMax         = 15 * time.Minute
+	defaultLockoutThreshold = 10
+	defaultLockoutDuration  = 30 * time.Minute
+	defaultFailureWindow    = time.Hour
+)
+
+// * how many failures are free before the backoff starts, a whole branch can share one address
+type throttlePolicy struct {
+	freeAttempts uint
+	lockout      bool
+}
+
+var (
+	ipThrottlePolicy   = throttlePolicy{freeAttempts: 20}
+	userThrottlePolicy = throttlePolicy{freeAttempts: 3, lockout: true}
+)
+
+// * returned while a login has to wait, RetryAfter tells the client for how long
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return "account is temporarily locked after too many failed logins"
+	}
+	return "too many failed logins, try again later"
+}
+
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func UserThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// * LOGIN_LOCKOUT_THRESHOLD failed logins lock the account for LOGIN_LOCKOUT_DURATION
+func LockoutThreshold() uint {
+	value, err := strconv.ParseUint(os.Getenv("LOGIN_LOCKOUT_THRESHOLD"), 10, 32)
+	if err != nil || value == 0 {
+		return defaultLockoutThreshold
+	}
+	return uint(value)
+}
+
+func LockoutDuration() time.Duration {
+	return durationFromEnv("LOGIN_LOCKOUT_DURATION", defaultLockoutDuration)
+}
+
+// * LOGIN_FAILURE_WINDOW is how long a failed login is remembered
+func LoginFailureWindow() time.Duration {
+	return durationFromEnv("LOGIN_FAILURE_WINDOW", defaultFailureWindow)
+}
+
+// * doubles with every failure past the free ones
+func LoginBackoff(failures, freeAttempts uint) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	backoff := loginBackoffBase
+	for i := freeAttempts; i < failures; i++ {
+		backoff *= 2
+		if backoff >= loginBackoffMax {
+			return loginBackoffMax
+		}
+	}
+	return backoff
+}
+
+func checkThrottle(key string, policy throttlePolicy, now time.Time, tr types.LoginThrottleRepository) error {
+	throttle, err := tr.Get(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+		return &ThrottledError{RetryAfter: throttle.LockedUntil.Sub(now), Locked: true}
+	}
+
+	if now.Sub(throttle.LastFailureAt) > LoginFailureWindow() {
+		return nil
+	}
+
+	retryAt := throttle.LastFailureAt.Add(LoginBackoff(throttle.Failures, policy.freeAttempts))
+	if now.Before(retryAt) {
+		return &ThrottledError{RetryAfter: retryAt.Sub(now)}
+	}
+	return nil
+}
+
+// * must be called before the password is checked, returns a *ThrottledError while the client or the account has to wait
+func CheckLoginThrottle(ip, username string, now time.Time, tr types.LoginThrottleRepository) error {
+	if err := checkThrottle(IPThrottleKey(ip), ipThrottlePolicy, now, tr); err != nil {
+		return err
+	}
+	return checkThrottle(UserThrottleKey(username), userThrottlePolicy, now, tr)
+}
+
+// * counts the failure against both the client address and the username, unknown usernames included
+func RegisterLoginFailure(ip, username string, now time.Time, tr types.LoginThrottleRepository) error {
+	window := LoginFailureWindow()
+
+	if _, err := tr.RecordFailure(IPThrottleKey(ip), now, window); err != nil {
+		return err
+	}
+
+	throttle, err := tr.RecordFailure(UserThrottleKey(username), now, window)
+	if err != nil {
+		return err
+	}
+
+	if userThrottlePolicy.lockout && throttle.Failures >= LockoutThreshold() {
+		return tr.Lock(throttle.Key, now.Add(LockoutDuration()))
+	}
+	return nil
+}
+
+// * a successful login clears the account, the client address keeps its failures so other accounts stay protected
+func ResetLoginThrottle(username string, tr types.LoginThrottleRepository) error {
+	return tr.Delete(UserThrottleKey(username))
+}
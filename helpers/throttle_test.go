@@ -0,0 +1,118 @@
+package help
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type fakeLoginThrottleRepository struct {
+	types.LoginThrottleRepository
+	throttles map[string]*types.LoginThrottle
+}
+
+func (f *fakeLoginThrottleRepository) Get(key string) (*types.LoginThrottle, error) {
+	throttle, ok := f.throttles[key]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *throttle
+	return &copied, nil
+}
+
+func (f *fakeLoginThrottleRepository) RecordFailure(key string, now time.Time, window time.Duration) (*types.LoginThrottle, error) {
+	throttle, ok := f.throttles[key]
+	if !ok {
+		throttle = &types.LoginThrottle{Key: key}
+		f.throttles[key] = throttle
+	}
+	if throttle.LastFailureAt.Before(now.Add(-window)) {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	copied := *throttle
+	return &copied, nil
+}
+
+func (f *fakeLoginThrottleRepository) Lock(key string, until time.Time) error {
+	f.throttles[key].LockedUntil = &until
+	return nil
+}
+
+func (f *fakeLoginThrottleRepository) Delete(key string) error {
+	delete(f.throttles, key)
+	return nil
+}
+
+func TestLoginBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), LoginBackoff(2, 3))
+	assert.Equal(t, time.Second, LoginBackoff(3, 3))
+	assert.Equal(t, 4*time.Second, LoginBackoff(5, 3))
+	assert.Equal(t, loginBackoffMax, LoginBackoff(40, 3))
+}
+
+func TestLoginThrottle(t *testing.T) {
+	t.Setenv("LOGIN_LOCKOUT_THRESHOLD", "5")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "30m")
+
+	repo := &fakeLoginThrottleRepository{throttles: make(map[string]*types.LoginThrottle)}
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	t.Run("FirstFailuresAreFree", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, RegisterLoginFailure("10.0.0.1", "Victim", now, repo))
+		}
+		assert.NoError(t, CheckLoginThrottle("10.0.0.1", "victim", now, repo))
+	})
+
+	t.Run("BacksOffAfterTheFreeFailures", func(t *testing.T) {
+		assert.NoError(t, RegisterLoginFailure("10.0.0.2", "victim", now, repo))
+
+		var throttled *ThrottledError
+		err := CheckLoginThrottle("10.0.0.3", "victim", now, repo)
+		if assert.True(t, errors.As(err, &throttled)) {
+			assert.False(t, throttled.Locked)
+			assert.Equal(t, time.Second, throttled.RetryAfter)
+		}
+
+		assert.NoError(t, CheckLoginThrottle("10.0.0.3", "victim", now.Add(time.Second), repo))
+	})
+
+	t.Run("OtherAccountsAreNotAffected", func(t *testing.T) {
+		assert.NoError(t, CheckLoginThrottle("10.0.0.1", "someone-else", now, repo))
+	})
+
+	t.Run("LocksTheAccountAtTheThreshold", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		assert.NoError(t, RegisterLoginFailure("10.0.0.4", "victim", now, repo))
+		assert.NoError(t, RegisterLoginFailure("10.0.0.5", "victim", now, repo))
+
+		var throttled *ThrottledError
+		err := CheckLoginThrottle("10.0.0.6", "victim", now.Add(20*time.Minute), repo)
+		if assert.True(t, errors.As(err, &throttled)) {
+			assert.True(t, throttled.Locked)
+			assert.Equal(t, 10*time.Minute, throttled.RetryAfter)
+		}
+	})
+
+	t.Run("UnlockClearsTheAccount", func(t *testing.T) {
+		assert.NoError(t, ResetLoginThrottle("VICTIM", repo))
+		assert.NoError(t, CheckLoginThrottle("10.0.0.6", "victim", now, repo))
+	})
+
+	t.Run("ThrottlesASingleAddressAcrossAccounts", func(t *testing.T) {
+		for i := uint(0); i < ipThrottlePolicy.freeAttempts; i++ {
+			assert.NoError(t, RegisterLoginFailure("10.0.0.9", "guess", now, repo))
+			assert.NoError(t, ResetLoginThrottle("guess", repo))
+		}
+
+		var throttled *ThrottledError
+		err := CheckLoginThrottle("10.0.0.9", "another-guess", now, repo)
+		assert.True(t, errors.As(err, &throttled))
+	})
+}
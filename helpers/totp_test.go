@@ -0,0 +1,64 @@
+package help
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// * the SHA1 test vectors of RFC 6238, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, "time %d", unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+
+	t.Run("AcceptsClockSkew", func(t *testing.T) {
+		step, err := ValidateTOTP(secret, previous, now, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TOTPStep(now)-1, step)
+	})
+
+	t.Run("RejectsReplays", func(t *testing.T) {
+		_, err := ValidateTOTP(secret, previous, now, TOTPStep(now)-1)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsOldCodes", func(t *testing.T) {
+		old, _ := TOTPCode(secret, TOTPStep(now)-5)
+		_, err := ValidateTOTP(secret, old, now, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	t.Setenv("TOTP_ISSUER", "City Library")
+
+	uri := TOTPProvisioningURI("ABCDEF", "admin")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/City%20Library:admin?"))
+	assert.Contains(t, uri, "secret=ABCDEF")
+	assert.Contains(t, uri, "issuer=City+Library")
+}
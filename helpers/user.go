@@ -0,0 +1,22 @@
+package help
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gimtwi/go-library-project/types"
+)
+
+// * REQUIRE_VERIFIED_EMAIL stops members from placing holds until they confirm their address
+func RequireVerifiedEmail() bool {
+	value, err := strconv.ParseBool(os.Getenv("REQUIRE_VERIFIED_EMAIL"))
+	return err == nil && value
+}
+
+func CheckVerifiedEmail(user *types.User) error {
+	if RequireVerifiedEmail() && !user.IsVerified() {
+		return fmt.Errorf("please confirm your email address first")
+	}
+	return nil
+}
@@ -1,8 +1,22 @@
 package main
 
 import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gimtwi/go-library-project/calendar"
+	"github.com/gimtwi/go-library-project/circulation"
 	"github.com/gimtwi/go-library-project/controllers"
+	help "github.com/gimtwi/go-library-project/helpers"
 	"github.com/gimtwi/go-library-project/middleware"
+	"github.com/gimtwi/go-library-project/notify"
+	"github.com/gimtwi/go-library-project/oidc"
+	"github.com/gimtwi/go-library-project/scheduler"
 	"github.com/gimtwi/go-library-project/types"
 	"github.com/gimtwi/go-library-project/utils"
 	"github.com/gin-gonic/gin"
@@ -28,63 +42,215 @@ func main() {
 	kindRepo := types.NewKindRepository(utils.DB)
 	holdRepo := types.NewHoldRepository(utils.DB)
 	loanRepo := types.NewLoanRepository(utils.DB)
+	fineRepo := types.NewFineRepository(utils.DB)
+	notificationRepo := types.NewNotificationRepository(utils.DB)
+	copyRepo := types.NewCopyRepository(utils.DB)
+	sessionRepo := types.NewSessionRepository(utils.DB)
+	passwordResetRepo := types.NewPasswordResetRepository(utils.DB)
+	roleRepo := types.NewRoleRepository(utils.DB)
+	apiKeyRepo := types.NewAPIKeyRepository(utils.DB)
+	identityRepo := types.NewIdentityRepository(utils.DB)
+	recoveryCodeRepo := types.NewRecoveryCodeRepository(utils.DB)
+	loginThrottleRepo := types.NewLoginThrottleRepository(utils.DB)
+	auditRepo := types.NewAuditRepository(utils.DB)
+	circulationRuleRepo := types.NewCirculationRuleRepository(utils.DB)
+	store := types.NewStore(utils.DB)
+	circulationService := circulation.NewService(store, help.RealClock{}, circulation.PolicyFromEnv(), calendar.LocationFromEnv())
+	notifier := notify.FromEnv()
+
+	r.Use(middleware.Authenticate(userRepo, sessionRepo, apiKeyRepo))
+
+	// single sign-on controller, only when an identity provider is configured
+	if config, err := oidc.ConfigFromEnv(); err != nil {
+		log.Fatalf("invalid single sign-on configuration: %v", err)
+	} else if config != nil {
+		provider, err := oidc.NewProvider(context.Background(), *config)
+		if err != nil {
+			log.Printf("single sign-on is disabled: %v", err)
+		} else {
+			r.GET("/oidc/login", controllers.StartOIDCLogin(provider))
+			r.GET("/oidc/callback", controllers.FinishOIDCLogin(provider, userRepo, sessionRepo, identityRepo, roleRepo, loginThrottleRepo, auditRepo))
+		}
+	}
+
+	// own records, resolved from the token
+	r.GET("/me", middleware.RequireUser(), controllers.GetUserByID(userRepo))
+	r.GET("/me/holds", middleware.RequireUser(), controllers.GetHoldsByUserID(holdRepo))
+	r.GET("/me/loans", middleware.RequireUser(), controllers.GetLoansByUserID(loanRepo))
 
 	// user CRUD controller
-	r.GET("/user", middleware.CheckPrivilege(userRepo, types.Member), controllers.GetAllUsers(userRepo))
-	r.GET("/user/:id", middleware.CheckPrivilege(userRepo, types.Member), controllers.GetUserByID(userRepo))
-	r.PUT("/user/new-moderator/:id", middleware.CheckPrivilege(userRepo, types.Admin), controllers.AssignRole(userRepo, types.Moderator))
-	r.PUT("/user/new-admin/:id", middleware.CheckPrivilege(userRepo, types.Admin), controllers.AssignRole(userRepo, types.Admin))
-	r.PUT("/user/:id/change-password", middleware.CompareCookiesAndParameter(userRepo), controllers.ChangePassword(userRepo))
-	r.DELETE("/user/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.DeleteUser(userRepo))
+	r.GET("/user", middleware.CheckPrivilege(types.UsersRead), controllers.GetAllUsers(userRepo))
+	r.GET("/user/:id", middleware.CheckOwnerOrPrivilege(types.UsersRead), controllers.GetUserByID(userRepo))
+	r.PUT("/user/:id/role", middleware.CheckPrivilege(types.RolesManage), controllers.AssignRole(store))
+	r.PUT("/user/:id/category", middleware.CheckPrivilege(types.UsersManage), controllers.AssignCategory(store))
+	r.PUT("/user/:id/branch", middleware.CheckPrivilege(types.UsersManage), controllers.AssignBranch(store))
+	r.PUT("/user/:id/change-password", middleware.CompareCookiesAndParameter(), controllers.ChangePassword(userRepo, sessionRepo))
+	r.DELETE("/user/:id", middleware.CheckPrivilege(types.UsersManage), controllers.DeleteUser(store))
+
+	r.POST("/register", middleware.CheckPrivilege(types.UsersManage), controllers.RegisterUser(store, notifier))
+	r.POST("/login", middleware.RateLimitMiddleware(), controllers.Login(userRepo, sessionRepo, loginThrottleRepo, auditRepo))
+	r.POST("/login/2fa", middleware.RateLimitMiddleware(), controllers.LoginTwoFactor(userRepo, sessionRepo, recoveryCodeRepo, loginThrottleRepo, auditRepo))
+	r.GET("/logout", controllers.Logout(sessionRepo))
+	r.GET("/verify-email", controllers.VerifyEmail(userRepo))
+	r.POST("/verify-email/resend", middleware.RateLimitMiddleware(), middleware.RequireUser(), controllers.ResendVerificationEmail(notifier))
+	r.POST("/password/forgot", middleware.RateLimitMiddleware(), controllers.ForgotPassword(userRepo, passwordResetRepo, notifier))
+	r.POST("/password/reset", middleware.RateLimitMiddleware(), controllers.ResetPassword(userRepo, passwordResetRepo, sessionRepo))
+	r.POST("/token/refresh", middleware.RateLimitMiddleware(), controllers.RefreshSession(userRepo, sessionRepo))
+
+	// role controller
+	r.GET("/permission", middleware.CheckPrivilege(types.RolesManage), controllers.GetPermissions())
+	r.GET("/role", middleware.CheckPrivilege(types.RolesManage), controllers.GetAllRoles(roleRepo))
+	r.GET("/role/:id", middleware.CheckPrivilege(types.RolesManage), controllers.GetRoleByID(roleRepo))
+	r.POST("/role", middleware.CheckPrivilege(types.RolesManage), controllers.CreateRole(store))
+	r.PUT("/role/:id", middleware.CheckPrivilege(types.RolesManage), controllers.UpdateRole(store))
+	r.DELETE("/role/:id", middleware.CheckPrivilege(types.RolesManage), controllers.DeleteRole(store))
 
-	r.POST("/register", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.RegisterUser(userRepo))
-	r.POST("/login", middleware.RateLimitMiddleware(), controllers.Login(userRepo))
-	r.GET("/logout", controllers.Logout(userRepo))
+	// api key controller
+	r.GET("/api-key", middleware.CheckPrivilege(types.APIKeysManage), controllers.GetAllAPIKeys(apiKeyRepo))
+	r.POST("/api-key", middleware.CheckPrivilege(types.APIKeysManage), controllers.CreateAPIKey(store))
+	r.DELETE("/api-key/:id", middleware.CheckPrivilege(types.APIKeysManage), controllers.RevokeAPIKey(store))
+
+	// lockout controller
+	r.GET("/lockouts", middleware.CheckPrivilege(types.SecurityManage), controllers.GetLockedAccounts(loginThrottleRepo))
+	r.DELETE("/user/:id/lockout", middleware.CheckPrivilege(types.SecurityManage), controllers.UnlockUser(store))
+
+	// two-factor authentication controller
+	r.POST("/2fa/enroll", middleware.RequireUser(), controllers.EnrollTwoFactor(userRepo))
+	r.POST("/2fa/confirm", middleware.RequireUser(), controllers.ConfirmTwoFactor(userRepo, recoveryCodeRepo))
+	r.POST("/2fa/recovery-codes", middleware.RequireUser(), controllers.RegenerateRecoveryCodes(userRepo, recoveryCodeRepo))
+	r.DELETE("/2fa", middleware.RequireUser(), controllers.DisableTwoFactor(userRepo, recoveryCodeRepo))
+	r.DELETE("/user/:id/2fa", middleware.CheckPrivilege(types.SecurityManage), controllers.ResetUserTwoFactor(store))
+
+	// session controller
+	r.GET("/session", middleware.RequireUser(), controllers.GetOwnSessions(sessionRepo))
+	r.DELETE("/session/:id", middleware.RequireUser(), controllers.RevokeSession(sessionRepo))
+	r.DELETE("/user/:id/sessions", middleware.CheckPrivilege(types.SecurityManage), controllers.RevokeUserSessions(store))
 
 	// item CRUD controller
 	r.GET("/item", controllers.GetOrderedFilteredItemsByTitle(itemRepo))
 	r.GET("/item/:id", controllers.GetItemByID(itemRepo))
+	r.GET("/search", controllers.SearchItems(itemRepo))
+	r.GET("/catalog", controllers.BrowseCatalog(itemRepo))
 	r.GET("/item/author/:id", controllers.GetItemsByAuthorID(itemRepo))
 	r.GET("/item/genre/:id", controllers.GetItemsByGenreID(itemRepo))
 	r.GET("/item/kind/:id", controllers.GetItemsByKindID(itemRepo))
-	r.POST("/item", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.CreateItem(itemRepo, authorRepo, genreRepo, kindRepo))
-	r.PUT("/item/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.UpdateItem(itemRepo, authorRepo, genreRepo, holdRepo, loanRepo, kindRepo))
-	r.DELETE("/item/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.DeleteItem(itemRepo))
+	r.POST("/item", middleware.CheckPrivilege(types.CatalogWrite), controllers.CreateItem(itemRepo, authorRepo, genreRepo, kindRepo))
+	r.PUT("/item/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.UpdateItem(itemRepo, authorRepo, genreRepo, kindRepo))
+	r.DELETE("/item/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.DeleteItem(store))
+
+	// branch CRUD controller
+	r.GET("/branch", controllers.GetBranches(store.Branches))
+	r.GET("/branch/:id", controllers.GetBranchByID(store.Branches))
+	r.POST("/branch", middleware.CheckPrivilege(types.BranchesManage), controllers.CreateBranch(store))
+	r.PUT("/branch/:id", middleware.CheckPrivilege(types.BranchesManage), controllers.UpdateBranch(store))
+	r.DELETE("/branch/:id", middleware.CheckPrivilege(types.BranchesManage), controllers.DeleteBranch(store))
+
+	// copy CRUD controller
+	r.GET("/item/:id/copies", controllers.GetCopiesByItemID(copyRepo))
+	r.GET("/copy/:id", controllers.GetCopyByID(copyRepo))
+	r.GET("/copy/barcode/:barcode", middleware.CheckPrivilege(types.CatalogWrite), controllers.GetCopyByBarcode(copyRepo))
+	r.POST("/copy", middleware.CheckPrivilege(types.CatalogWrite), controllers.CreateCopy(itemRepo, circulationService))
+	r.PUT("/copy/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.UpdateCopy(circulationService))
+	r.DELETE("/copy/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.DeleteCopy(circulationService))
 
 	// author CRUD controller
 	r.GET("/author", controllers.GetOrderedFilteredAuthorsByName(authorRepo))
 	r.GET("/author/:id", controllers.GetAuthorByID(authorRepo))
-	r.POST("/author", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.CreateAuthor(authorRepo))
-	r.PUT("/author/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.UpdateAuthor(authorRepo, itemRepo))
-	r.DELETE("/author/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.DeleteAuthor(authorRepo))
+	r.POST("/author", middleware.CheckPrivilege(types.CatalogWrite), controllers.CreateAuthor(authorRepo))
+	r.PUT("/author/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.UpdateAuthor(authorRepo, itemRepo))
+	r.DELETE("/author/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.DeleteAuthor(authorRepo))
 
 	// genre CRUD controller
 	r.GET("/genre", controllers.GetOrderedFilteredGenresByName(genreRepo))
 	r.GET("/genre/:id", controllers.GetGenreByID(genreRepo))
-	r.POST("/genre", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.CreateGenre(genreRepo))
-	r.PUT("/genre/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.UpdateGenre(genreRepo, itemRepo))
-	r.DELETE("/genre/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.DeleteGenre(genreRepo))
+	r.POST("/genre", middleware.CheckPrivilege(types.CatalogWrite), controllers.CreateGenre(genreRepo))
+	r.PUT("/genre/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.UpdateGenre(genreRepo, itemRepo))
+	r.DELETE("/genre/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.DeleteGenre(genreRepo))
 
 	// kind CRUD controller
 	r.GET("/kind", controllers.GetOrderedFilteredKindsByName(kindRepo))
 	r.GET("/kind/:id", controllers.GetKindByID(kindRepo))
-	r.POST("/kind", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.CreateKind(kindRepo))
-	r.PUT("/kind/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.UpdateKind(kindRepo, itemRepo))
-	r.DELETE("/kind/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.DeleteKind(kindRepo))
+	r.POST("/kind", middleware.CheckPrivilege(types.CatalogWrite), controllers.CreateKind(kindRepo))
+	r.PUT("/kind/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.UpdateKind(kindRepo, itemRepo))
+	r.DELETE("/kind/:id", middleware.CheckPrivilege(types.CatalogWrite), controllers.DeleteKind(kindRepo))
 
 	// hold CRUD controller
-	r.GET("/hold/user/:id", middleware.CheckPrivilege(userRepo, types.Member), controllers.GetHoldsByUserID(holdRepo, loanRepo, itemRepo))
-	r.GET("/hold/item/:id", middleware.CheckPrivilege(userRepo, types.Member), controllers.GetHoldsByItemID(holdRepo))
-	r.POST("/hold", middleware.CheckPrivilege(userRepo, types.Member), controllers.PlaceHold(holdRepo, loanRepo, itemRepo))
-	r.DELETE("/cancel-hold/:id", middleware.CheckPrivilege(userRepo, types.Member), controllers.CancelHold(holdRepo, loanRepo, itemRepo, userRepo))
-	r.DELETE("/resolve-hold/:id", middleware.CheckPrivilege(userRepo, types.Moderator), middleware.CheckPrivilege(userRepo, types.Moderator), controllers.ResolveHold(holdRepo, loanRepo, itemRepo))
+	r.GET("/hold/user/:id", middleware.CheckOwnerOrPrivilege(types.HoldsRead), controllers.GetHoldsByUserID(holdRepo))
+	r.GET("/hold/item/:id", middleware.CheckPrivilege(types.HoldsRead), controllers.GetHoldsByItemID(holdRepo))
+	r.POST("/hold", middleware.CheckPrivilege(types.HoldsPlace), controllers.PlaceHold(circulationService))
+	r.PUT("/hold/:id/delivery-date", middleware.CheckPrivilege(types.HoldsPlace), controllers.ChangeDeliveryDate(circulationService))
+	r.DELETE("/cancel-hold/:id", middleware.CheckPrivilege(types.HoldsPlace), controllers.CancelHold(circulationService))
+	r.DELETE("/resolve-hold/:id", middleware.CheckPrivilege(types.CirculationCheckout), controllers.ResolveHold(circulationService))
 
 	// loan CRUD controller
-	r.GET("/loan/item/:id", middleware.CheckPrivilege(userRepo, types.Member), controllers.GetLoansByItemID(loanRepo))
-	r.GET("/loan/user/:id", middleware.CheckPrivilege(userRepo, types.Member), controllers.GetLoansByUserID(loanRepo))
-	r.POST("/loan", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.CreateLoan(loanRepo, itemRepo, holdRepo))
-	r.DELETE("/loan/:id", middleware.CheckPrivilege(userRepo, types.Moderator), controllers.ReturnTheItem(loanRepo, holdRepo, itemRepo))
+	r.GET("/loan/item/:id", middleware.CheckPrivilege(types.LoansRead), controllers.GetLoansByItemID(loanRepo))
+	r.GET("/loan/user/:id", middleware.CheckOwnerOrPrivilege(types.LoansRead), controllers.GetLoansByUserID(loanRepo))
+	r.GET("/loan/overdue", middleware.CheckPrivilege(types.CirculationCheckout), controllers.GetOverdueLoans(loanRepo))
+	r.POST("/loan", middleware.CheckPrivilege(types.CirculationCheckout), controllers.CreateLoan(circulationService))
+	r.POST("/loan/:id/renew", middleware.CheckPrivilege(types.LoansRenew), controllers.ProlongLoan(circulationService))
+	r.DELETE("/loan/:id", middleware.CheckPrivilege(types.CirculationCheckout), controllers.ReturnTheItem(circulationService))
+
+	// circulation desk controller
+	r.POST("/desk/checkout", middleware.CheckPrivilege(types.CirculationCheckout), controllers.CheckoutCopy(circulationService))
+	r.POST("/desk/checkin", middleware.CheckPrivilege(types.CirculationCheckout), controllers.CheckinCopy(circulationService))
+
+	// circulation policy controller
+	r.GET("/circulation-rule", middleware.CheckPrivilege(types.PolicyManage), controllers.GetCirculationRules(circulationRuleRepo))
+	r.POST("/circulation-rule", middleware.CheckPrivilege(types.PolicyManage), controllers.CreateCirculationRule(store))
+	r.PUT("/circulation-rule/:id", middleware.CheckPrivilege(types.PolicyManage), controllers.UpdateCirculationRule(store))
+	r.DELETE("/circulation-rule/:id", middleware.CheckPrivilege(types.PolicyManage), controllers.DeleteCirculationRule(store))
+
+	// opening calendar controller
+	r.GET("/calendar/hours", controllers.GetOpeningHours(store.Calendar))
+	r.PUT("/calendar/hours", middleware.CheckPrivilege(types.CalendarManage), controllers.SetOpeningHours(store))
+	r.GET("/calendar/closure", controllers.GetClosures(store.Calendar))
+	r.POST("/calendar/closure", middleware.CheckPrivilege(types.CalendarManage), controllers.CreateClosure(store))
+	r.PUT("/calendar/closure/:id", middleware.CheckPrivilege(types.CalendarManage), controllers.UpdateClosure(store))
+	r.DELETE("/calendar/closure/:id", middleware.CheckPrivilege(types.CalendarManage), controllers.DeleteClosure(store))
+	r.POST("/calendar/import", middleware.CheckPrivilege(types.CalendarManage), controllers.ImportClosures(store))
+
+	// audit controller
+	r.GET("/audit", middleware.CheckPrivilege(types.AuditRead), controllers.GetAuditLog(auditRepo))
+
+	// fine controller
+	r.GET("/fine", middleware.RequireUser(), controllers.GetOwnFines(fineRepo))
+	r.GET("/fine/user/:id", middleware.CheckOwnerOrPrivilege(types.FinesRead), controllers.GetFinesByUserID(fineRepo))
+	r.POST("/fine/user/:id/payment", middleware.CheckPrivilege(types.FinesCollect), controllers.RecordPayment(store))
+	r.POST("/fine/user/:id/waive", middleware.CheckPrivilege(types.FinesWaive), controllers.WaiveFines(store))
+
+	jobs := scheduler.New(help.RealClock{}, scheduler.IntervalFromEnv())
+	jobs.Register("expire holds", scheduler.ExpireHolds(circulationService))
+	jobs.Register("release postponed holds", scheduler.ReleasePostponedHolds(circulationService))
+	jobs.Register("accrue fines", scheduler.AccrueFines(loanRepo, fineRepo, itemRepo))
+	jobs.Register("remind loans", scheduler.RemindLoans(loanRepo, itemRepo, notificationRepo))
+	jobs.Register("dispatch notifications", scheduler.DispatchNotifications(notificationRepo, userRepo, notifier))
+	jobs.Register("prune sessions", scheduler.PruneSessions(sessionRepo))
+	jobs.Register("prune password reset tokens", scheduler.PrunePasswordResets(passwordResetRepo))
+	jobs.Register("prune login throttles", scheduler.PruneLoginThrottles(loginThrottleRepo))
+	jobs.Start()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to start the server: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
 
-	r.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down the server: %v", err)
+	}
+	jobs.Stop()
 }
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+// * keys carry a fixed prefix so that a bearer token can be told apart from an access token at a glance
+const (
+	APIKeyPrefix      = "lib_"
+	apiKeyTouchPeriod = time.Minute
+)
+
+func NewAPIKey() (string, string, error) {
+	token, _, err := NewRandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := APIKeyPrefix + token
+	return key, HashToken(key), nil
+}
+
+// * the token of the Authorization header, scripts and mobile clients use it instead of the cookie
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func authenticateAPIKey(rawKey string, ur types.UserRepository, kr types.APIKeyRepository) (*types.User, *types.APIKey, error) {
+	key, err := kr.GetByHash(HashToken(rawKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if !key.IsActive(now) {
+		return nil, nil, fmt.Errorf("api key %d is no longer active", key.ID)
+	}
+
+	user, err := ur.GetByID(key.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.Role == nil {
+		return nil, nil, fmt.Errorf("user of api key %d has no role", key.ID)
+	}
+	user.Role = key.EffectiveRole(user.Role)
+
+	// * written at most once a minute so that busy integrations don't write on every request
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchPeriod {
+		if err := kr.Touch(key.ID, now); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return user, key, nil
+}
+
+func GetAPIKeyFromContext(c *gin.Context) *types.APIKey {
+	value, ok := c.Get("apiKey")
+	if !ok {
+		return nil
+	}
+
+	key, _ := value.(*types.APIKey)
+	return key
+}
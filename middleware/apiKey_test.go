@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeUserRepository struct {
+	types.UserRepository
+	users map[string]*types.User
+}
+
+func (f *fakeUserRepository) GetByID(id string) (*types.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+type fakeSessionRepository struct {
+	types.SessionRepository
+	sessions map[string]*types.Session
+}
+
+func (f *fakeSessionRepository) GetByID(id string) (*types.Session, error) {
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	return session, nil
+}
+
+type fakeAPIKeyRepository struct {
+	types.APIKeyRepository
+	keys map[string]*types.APIKey
+}
+
+func (f *fakeAPIKeyRepository) GetByHash(hash string) (*types.APIKey, error) {
+	key, ok := f.keys[hash]
+	if !ok {
+		return nil, errors.New("api key not found")
+	}
+	return key, nil
+}
+
+func (f *fakeAPIKeyRepository) Touch(id uint, now time.Time) error {
+	for _, key := range f.keys {
+		if key.ID == id {
+			key.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test_secret")
+	t.Setenv("COOKIE_NAME", "lib-auth")
+
+	librarian := &types.User{ID: "librarian", Role: &types.Role{Name: "moderator", Permissions: types.ModeratorPermissions}}
+	session := &types.Session{ID: "session", UserID: librarian.ID, ExpiresAt: time.Now().Add(time.Hour)}
+
+	kioskKey, kioskHash, err := NewAPIKey()
+	assert.NoError(t, err)
+	revokedKey, revokedHash, err := NewAPIKey()
+	assert.NoError(t, err)
+
+	revokedAt := time.Now().Add(-time.Minute)
+	keyRepo := &fakeAPIKeyRepository{keys: map[string]*types.APIKey{
+		kioskHash:   {ID: 1, UserID: librarian.ID, Permissions: []types.Permission{types.CirculationCheckout, types.RolesManage}},
+		revokedHash: {ID: 2, UserID: librarian.ID, Permissions: []types.Permission{types.CirculationCheckout}, RevokedAt: &revokedAt},
+	}}
+
+	r := gin.New()
+	r.Use(Authenticate(
+		&fakeUserRepository{users: map[string]*types.User{librarian.ID: librarian}},
+		&fakeSessionRepository{sessions: map[string]*types.Session{session.ID: session}},
+		keyRepo,
+	))
+	r.POST("/desk/checkout", CheckPrivilege(types.CirculationCheckout), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.POST("/item", CheckPrivilege(types.CatalogWrite), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.PUT("/role/1", CheckPrivilege(types.RolesManage), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/session", RequireUser(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(method, path string, header string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	accessToken, err := GenerateJWT(librarian, session)
+	assert.NoError(t, err)
+
+	t.Run("AcceptsBearerAccessTokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(http.MethodPost, "/item", "Bearer "+accessToken))
+		assert.Equal(t, http.StatusOK, request(http.MethodGet, "/session", "bearer "+accessToken))
+	})
+
+	t.Run("RejectsInvalidBearerTokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/item", "Bearer nonsense"))
+	})
+
+	t.Run("ApiKeysActWithinTheirScopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(http.MethodPost, "/desk/checkout", "Bearer "+kioskKey))
+		assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/item", "Bearer "+kioskKey))
+		assert.NotNil(t, keyRepo.keys[kioskHash].LastUsedAt)
+	})
+
+	t.Run("ApiKeyScopesAreLimitedByTheUser", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(http.MethodPut, "/role/1", "Bearer "+kioskKey))
+	})
+
+	t.Run("ApiKeysCantUseAccountRoutes", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/session", "Bearer "+kioskKey))
+	})
+
+	t.Run("RevokedApiKeysAreRejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/desk/checkout", "Bearer "+revokedKey))
+	})
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	verifyEmailPurpose          = "verify-email"
+	defaultEmailVerificationTTL = 48 * time.Hour
+)
+
+// * EMAIL_VERIFICATION_TTL is how long the link in the verification email works
+func EmailVerificationTTL() time.Duration {
+	return durationFromEnv("EMAIL_VERIFICATION_TTL", defaultEmailVerificationTTL)
+}
+
+// * tokens signed for one purpose are never accepted for another one or as access tokens
+func signPurposeToken(purpose, subject string, ttl time.Duration, extra jwt.MapClaims) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := jwt.MapClaims{
+		"sub":     subject,
+		"purpose": purpose,
+		"exp":     expiresAt.Unix(),
+	}
+	for key, value := range extra {
+		claims[key] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return signed, expiresAt, err
+}
+
+func parsePurposeToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	token, err := ValidateJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid %s token", purpose)
+	}
+
+	if value, _ := claims["purpose"].(string); value != purpose {
+		return nil, fmt.Errorf("invalid %s token", purpose)
+	}
+
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, fmt.Errorf("invalid %s token", purpose)
+	}
+
+	return claims, nil
+}
+
+// * the token is bound to the address, changing the email makes older links useless
+func GenerateEmailVerificationToken(user *types.User) (string, time.Time, error) {
+	return signPurposeToken(verifyEmailPurpose, user.ID, EmailVerificationTTL(), jwt.MapClaims{"email": user.Email})
+}
+
+// * returns the user id and the address the token was issued for
+func ValidateEmailVerificationToken(tokenString string) (string, string, error) {
+	claims, err := parsePurposeToken(tokenString, verifyEmailPurpose)
+	if err != nil {
+		return "", "", err
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", "", fmt.Errorf("invalid verification token")
+	}
+
+	return claims["sub"].(string), email, nil
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test_secret")
+
+	user := &types.User{ID: "test_user", Email: "member@example.com"}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		token, _, err := GenerateEmailVerificationToken(user)
+		assert.NoError(t, err)
+
+		id, email, err := ValidateEmailVerificationToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, id)
+		assert.Equal(t, user.Email, email)
+	})
+
+	t.Run("RejectsAccessTokens", func(t *testing.T) {
+		token, err := GenerateJWT(user, &types.Session{ID: "test_session"})
+		assert.NoError(t, err)
+
+		_, _, err = ValidateEmailVerificationToken(token)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsTamperedTokens", func(t *testing.T) {
+		token, _, err := GenerateEmailVerificationToken(user)
+		assert.NoError(t, err)
+
+		_, _, err = ValidateEmailVerificationToken(token + "x")
+		assert.Error(t, err)
+	})
+}
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gimtwi/go-library-project/types"
@@ -13,10 +19,42 @@ import (
 	"github.com/juju/ratelimit"
 )
 
-func GenerateJWT(user *types.User) (string, error) {
+const (
+	defaultAccessTokenTTL    = 15 * time.Minute
+	defaultRefreshTokenTTL   = 30 * 24 * time.Hour
+	defaultRefreshCookieName = "lib-refresh"
+)
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// * ACCESS_TOKEN_TTL keeps access tokens short lived, they are renewed with the refresh token
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// * REFRESH_TOKEN_TTL is how long a session lasts
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+func RefreshCookieName() string {
+	if name := os.Getenv("REFRESH_COOKIE_NAME"); name != "" {
+		return name
+	}
+	return defaultRefreshCookieName
+}
+
+func GenerateJWT(user *types.User, session *types.Session) (string, error) {
 	claims := &jwt.MapClaims{
 		"id":  user.ID,
-		"exp": time.Now().Add(time.Hour * 24 * 30).Unix(), // token expires in 30 days
+		"sid": session.ID,
+		"exp": time.Now().Add(AccessTokenTTL()).Unix(),
 	}
 
 	secret := os.Getenv("JWT_SECRET")
@@ -37,160 +75,227 @@ func ValidateJWT(tokenString string) (*jwt.Token, error) {
 
 }
 
-func CheckPrivilege(ur types.UserRepository, role types.UserRole) gin.HandlerFunc {
+// * refresh and reset tokens are random, only their sha256 is stored
+func NewRandomToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// * runs on every request, routes that need a user are guarded by CheckPrivilege,
+// * a bearer token wins over the cookie and may also be an api key
+func Authenticate(ur types.UserRepository, sr types.SessionRepository, kr types.APIKeyRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenStr, err := c.Cookie(os.Getenv("COOKIE_NAME"))
+		tokenStr := bearerToken(c)
 
-		if err != nil {
-			c.AbortWithStatus(http.StatusUnauthorized)
+		if strings.HasPrefix(tokenStr, APIKeyPrefix) {
+			user, key, err := authenticateAPIKey(tokenStr, ur, kr)
+			if err != nil {
+				log.Println(err)
+				c.Next()
+				return
+			}
+
+			c.Set("user", user)
+			c.Set("apiKey", key)
+			c.Next()
+			return
 		}
 
-		token, err := ValidateJWT(tokenStr)
+		if tokenStr == "" {
+			tokenStr, _ = c.Cookie(os.Getenv("COOKIE_NAME"))
+		}
 
+		if tokenStr == "" {
+			c.Next()
+			return
+		}
+
+		user, session, err := authenticateToken(tokenStr, ur, sr)
 		if err != nil {
-			// Handle the JWT parsing error gracefully
-			if err == jwt.ErrSignatureInvalid {
-				// The token signature is invalid
-				c.AbortWithStatus(http.StatusUnauthorized)
-			} else if err == jwt.ErrTokenMalformed {
-				// The token is malformed
-				c.AbortWithStatus(http.StatusUnauthorized)
-			} else {
-				// Other JWT parsing errors
-				c.AbortWithStatus(http.StatusInternalServerError)
-			}
 			log.Println(err)
+			c.Next()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if float64(time.Now().Unix()) > claims["exp"].(float64) {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+		c.Set("user", user)
+		c.Set("session", session)
 
-			id, ok := claims["id"].(string)
-			if !ok || id == "" {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+		if user.Role != nil && user.Role.RequireTwoFactor && !user.TOTPEnabled {
+			c.Set("twoFactorPending", true)
+		}
 
-			user, errUser := ur.GetByID(id)
-			if errUser != nil {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+		c.Next()
+	}
+}
 
-			if user == nil {
-				c.AbortWithStatus(http.StatusUnauthorized)
-				return
-			}
+func authenticateToken(tokenStr string, ur types.UserRepository, sr types.SessionRepository) (*types.User, *types.Session, error) {
+	token, err := ValidateJWT(tokenStr)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			requiredRole := role
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, nil, fmt.Errorf("invalid access token")
+	}
 
-			if !types.CheckPrivilege(user.Role, requiredRole) {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+	id, _ := claims["id"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if id == "" || sessionID == "" {
+		return nil, nil, fmt.Errorf("access token is missing its claims")
+	}
 
-			c.Set("user", user)
+	// * checked on every request so that revoking a session takes effect right away
+	session, err := sr.GetByID(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
 
-			c.Next()
-		} else {
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}
+	if session.UserID != id || !session.IsActive(time.Now()) {
+		return nil, nil, fmt.Errorf("session %s is no longer active", session.ID)
+	}
 
-		c.Next()
+	user, err := ur.GetByID(id)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	return user, session, nil
 }
 
-func CompareCookiesAndParameter(ur types.UserRepository) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID := c.Param("id")
+// * aborts the request unless the role of the user grants the permission
+func authorize(c *gin.Context, user *types.User, permission types.Permission) bool {
+	if !user.Can(permission) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing permission " + string(permission)})
+		return false
+	}
 
-		tokenStr, err := c.Cookie(os.Getenv("COOKIE_NAME"))
+	if twoFactorPending(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two-factor authentication must be enabled for this account"})
+		return false
+	}
 
-		if err != nil {
+	return true
+}
+
+// * lets the request through when the role of the user grants the permission
+func CheckPrivilege(permission types.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if user == nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 
-		token, err := ValidateJWT(tokenStr)
-
-		if err != nil {
-			// Handle the JWT parsing error gracefully
-			if err == jwt.ErrSignatureInvalid {
-				// The token signature is invalid
-				c.AbortWithStatus(http.StatusUnauthorized)
-			} else if err == jwt.ErrTokenMalformed {
-				// The token is malformed
-				c.AbortWithStatus(http.StatusUnauthorized)
-			} else {
-				// Other JWT parsing errors
-				c.AbortWithStatus(http.StatusInternalServerError)
-			}
-			log.Println(err)
+		if !authorize(c, user, permission) {
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if float64(time.Now().Unix()) > claims["exp"].(float64) {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+		c.Next()
+	}
+}
 
-			id, ok := claims["id"].(string)
-			if !ok || id == "" {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+// * for routes scoped to the user in the :id parameter, users always reach their own records,
+// * the records of others need the permission
+func CheckOwnerOrPrivilege(permission types.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if user == nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
-			user, errUser := ur.GetByID(id)
-			if errUser != nil {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+		// * api keys are limited to their scopes even for the records of their own user
+		isOwner := user.ID == c.Param("id") && GetAPIKeyFromContext(c) == nil
 
-			if user.ID != userID {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+		if !isOwner && !authorize(c, user, permission) {
+			return
+		}
 
-			c.Set("user", user)
+		c.Next()
+	}
+}
 
-			c.Next()
-		} else {
+func CompareCookiesAndParameter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if user == nil || user.ID != c.Param("id") || GetAPIKeyFromContext(c) != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 
 		c.Next()
 	}
 }
 
-func GetUserIDFromTheToken(c *gin.Context) string {
-	tokenStr, err := c.Cookie(os.Getenv("COOKIE_NAME"))
-	if err != nil {
-		return ""
+func GetUserFromContext(c *gin.Context) *types.User {
+	value, ok := c.Get("user")
+	if !ok {
+		return nil
 	}
 
-	token, err := ValidateJWT(tokenStr)
-	if err != nil {
-		return ""
-	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			return ""
-		}
+	user, _ := value.(*types.User)
+	return user
+}
 
-		id, ok := claims["id"].(string)
-		if !ok || id == "" {
-			return ""
-		}
+func GetSessionFromContext(c *gin.Context) *types.Session {
+	value, ok := c.Get("session")
+	if !ok {
+		return nil
+	}
 
-		return id
+	session, _ := value.(*types.Session)
+	return session
+}
 
-	} else {
+func GetUserIDFromTheToken(c *gin.Context) string {
+	user := GetUserFromContext(c)
+	if user == nil {
 		return ""
 	}
+	return user.ID
 }
 
+// * every client address gets its own bucket, so one client can't use up the requests of everybody else
 func RateLimitMiddleware() gin.HandlerFunc {
-	bucket := ratelimit.NewBucketWithRate(1, 5)
+	var mu sync.Mutex
+	buckets := make(map[string]*ratelimit.Bucket)
+	lastSweep := time.Now()
+
+	bucketFor := func(ip string) *ratelimit.Bucket {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// * full buckets belong to clients that went quiet, they would be created the same again
+		if time.Since(lastSweep) > time.Minute {
+			for key, bucket := range buckets {
+				if bucket.Available() >= bucket.Capacity() {
+					delete(buckets, key)
+				}
+			}
+			lastSweep = time.Now()
+		}
+
+		bucket, ok := buckets[ip]
+		if !ok {
+			bucket = ratelimit.NewBucketWithRate(1, 5)
+			buckets[ip] = bucket
+		}
+		return bucket
+	}
 
 	return func(c *gin.Context) {
-		if bucket.TakeAvailable(1) < 1 {
+		if bucketFor(c.ClientIP()).TakeAvailable(1) < 1 {
 			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
 			c.Abort()
 			return
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.POST("/login", RateLimitMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(ip string) int {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("LimitsASingleClient", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, request("10.0.0.1"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, request("10.0.0.1"))
+	})
+
+	t.Run("OtherClientsKeepTheirRequests", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("10.0.0.2"))
+	})
+}
+
+func TestCheckPrivilege(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cataloguer := &types.User{ID: "cataloguer", Role: &types.Role{Name: "cataloguer", Permissions: []types.Permission{types.CatalogWrite}}}
+	enforced := &types.User{ID: "enforced", Role: &types.Role{Name: "enforced", Permissions: []types.Permission{types.CatalogWrite}, RequireTwoFactor: true}}
+
+	request := func(user *types.User, permission types.Permission) int {
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			if user != nil {
+				c.Set("user", user)
+				if user.Role.RequireTwoFactor && !user.TOTPEnabled {
+					c.Set("twoFactorPending", true)
+				}
+			}
+		})
+		r.GET("/", CheckPrivilege(permission), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	t.Run("AllowsGrantedPermission", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(cataloguer, types.CatalogWrite))
+	})
+
+	t.Run("RejectsMissingPermission", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(cataloguer, types.UsersRead))
+	})
+
+	t.Run("RejectsAnonymousUsers", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(nil, types.CatalogWrite))
+	})
+
+	t.Run("RejectsRolesWaitingForTwoFactor", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(enforced, types.CatalogWrite))
+
+		enforced.TOTPEnabled = true
+		assert.Equal(t, http.StatusOK, request(enforced, types.CatalogWrite))
+	})
+}
+
+func TestCheckOwnerOrPrivilege(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	member := &types.User{ID: "member", Role: &types.Role{Name: "member", Permissions: types.MemberPermissions}}
+	librarian := &types.User{ID: "librarian", Role: &types.Role{Name: "moderator", Permissions: types.ModeratorPermissions}}
+
+	request := func(user *types.User, id string) int {
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			c.Set("user", user)
+		})
+		r.GET("/hold/user/:id", CheckOwnerOrPrivilege(types.HoldsRead), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hold/user/"+id, nil))
+		return w.Code
+	}
+
+	t.Run("MembersSeeTheirOwnRecords", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(member, "member"))
+	})
+
+	t.Run("MembersCantSeeOtherRecords", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request(member, "librarian"))
+	})
+
+	t.Run("StaffSeeEverybody", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(librarian, "member"))
+	})
+}
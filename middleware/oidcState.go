@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcStatePurpose = "oidc-state"
+	OIDCStateTTL     = 10 * time.Minute
+	OIDCStateCookie  = "lib-oidc"
+)
+
+// * keeps state, nonce and PKCE verifier in a signed cookie while the browser is away at the identity provider
+func GenerateOIDCStateToken(state, nonce, verifier string) (string, error) {
+	token, _, err := signPurposeToken(oidcStatePurpose, state, OIDCStateTTL, jwt.MapClaims{"nonce": nonce, "verifier": verifier})
+	return token, err
+}
+
+// * returns the state, the nonce and the verifier
+func ValidateOIDCStateToken(tokenString string) (string, string, string, error) {
+	claims, err := parsePurposeToken(tokenString, oidcStatePurpose)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	return claims["sub"].(string), nonce, verifier, nil
+}
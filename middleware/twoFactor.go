@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	twoFactorPurpose = "two-factor"
+	twoFactorTTL     = 5 * time.Minute
+)
+
+// * proves that the password was already checked, the second login step exchanges it for a session
+func GenerateTwoFactorToken(user *types.User) (string, error) {
+	token, _, err := signPurposeToken(twoFactorPurpose, user.ID, twoFactorTTL, nil)
+	return token, err
+}
+
+func ValidateTwoFactorToken(tokenString string) (string, error) {
+	claims, err := parsePurposeToken(tokenString, twoFactorPurpose)
+	if err != nil {
+		return "", err
+	}
+	return claims["sub"].(string), nil
+}
+
+// * users whose role requires 2FA can still sign in to enroll, but none of their permissions work until they do
+func twoFactorPending(c *gin.Context) bool {
+	return c.GetBool("twoFactorPending")
+}
+
+// * for routes every signed in user needs, like the 2FA enrollment itself,
+// * they act on the account so api keys can't use them
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetUserFromContext(c) == nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if GetAPIKeyFromContext(c) != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this route can't be used with an api key"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,25 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/gimtwi/go-library-project/types"
+)
+
+const dispatchBatchSize = 50
+
+// * sends queued notifications to the current email address of the member
+func Dispatch(nr types.NotificationRepository, ur types.UserRepository, n Notifier) (int, error) {
+	return nr.SendPending(dispatchBatchSize, func(notification *types.Notification) error {
+		user, err := ur.GetByID(notification.UserID)
+		if err != nil {
+			return err
+		}
+
+		if user.Email == "" {
+			return fmt.Errorf("user %s has no email address", user.ID)
+		}
+
+		return n.Send(user.Email, notification.Subject, notification.Body)
+	})
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+)
+
+func NewHoldAvailable(hold *types.Hold, item *types.Item) *types.Notification {
+	return &types.Notification{
+		UserID:   hold.UserID,
+		Kind:     types.HoldAvailable,
+		DedupKey: fmt.Sprintf("%s:%d:%d", types.HoldAvailable, hold.ID, hold.ExpiryDate.Unix()),
+		Subject:  fmt.Sprintf("%q is ready for pickup", item.Title),
+		Body: fmt.Sprintf("Your hold on %q is available.\nPlease pick it up before %s, after that the hold expires.",
+			item.Title, hold.ExpiryDate.Format(time.DateOnly)),
+	}
+}
+
+func NewLoanDueSoon(loan *types.Loan, item *types.Item) *types.Notification {
+	return &types.Notification{
+		UserID:   loan.UserID,
+		Kind:     types.LoanDueSoon,
+		DedupKey: fmt.Sprintf("%s:%d:%d", types.LoanDueSoon, loan.ID, loan.ExpireDate.Unix()),
+		Subject:  fmt.Sprintf("%q is due on %s", item.Title, loan.ExpireDate.Format(time.DateOnly)),
+		Body: fmt.Sprintf("Your loan of %q is due on %s.\nYou can renew it now if nobody is waiting for it.",
+			item.Title, loan.ExpireDate.Format(time.DateOnly)),
+	}
+}
+
+func NewLoanOverdue(loan *types.Loan, item *types.Item) *types.Notification {
+	return &types.Notification{
+		UserID:   loan.UserID,
+		Kind:     types.LoanOverdue,
+		DedupKey: fmt.Sprintf("%s:%d:%d", types.LoanOverdue, loan.ID, loan.ExpireDate.Unix()),
+		Subject:  fmt.Sprintf("%q is overdue", item.Title),
+		Body: fmt.Sprintf("Your loan of %q was due on %s.\nPlease return it as soon as possible, fines are charged for every overdue day.",
+			item.Title, loan.ExpireDate.Format(time.DateOnly)),
+	}
+}
+
+// * PASSWORD_RESET_URL points at the page of the front end that asks for the new password
+func NewPasswordReset(token string, expiresAt time.Time) (string, string) {
+	instructions := fmt.Sprintf("Use this token to choose a new password: %s", token)
+	if link := os.Getenv("PASSWORD_RESET_URL"); link != "" {
+		instructions = fmt.Sprintf("Follow this link to choose a new password: %s?token=%s", link, url.QueryEscape(token))
+	}
+
+	return "Reset your library password",
+		fmt.Sprintf("Somebody asked to reset the password of your library account.\n%s\nThe link works once and expires at %s. If it wasn't you, you can ignore this email.",
+			instructions, expiresAt.Format(time.RFC1123))
+}
+
+// * EMAIL_VERIFICATION_URL defaults to the verify-email endpoint of a local server
+func NewEmailVerification(token string, expiresAt time.Time) (string, string) {
+	link := os.Getenv("EMAIL_VERIFICATION_URL")
+	if link == "" {
+		link = "http://localhost:8080/verify-email"
+	}
+
+	return "Confirm your email address",
+		fmt.Sprintf("Welcome to the library!\nPlease confirm your email address by following this link: %s?token=%s\nThe link expires at %s.",
+			link, url.QueryEscape(token), expiresAt.Format(time.RFC1123))
+}
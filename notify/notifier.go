@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"log"
+	"os"
+)
+
+type Notifier interface {
+	Send(to, subject, body string) error
+}
+
+// * used when no SMTP server is configured, e.g. during development
+type LogNotifier struct{}
+
+func (LogNotifier) Send(to, subject, body string) error {
+	log.Printf("notification to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// * SMTP_HOST enables email delivery, otherwise notifications are only logged
+func FromEnv() Notifier {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogNotifier{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+
+	return NewSMTPNotifier(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+}
@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// * speaks just enough SMTP for net/smtp.SendMail
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	received []receivedMail
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSMTPServer{listener: listener}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return server
+}
+
+func (s *fakeSMTPServer) addr() (string, string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host, port
+}
+
+func (s *fakeSMTPServer) mails() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.received...)
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var mail receivedMail
+	reply("220 localhost fake smtp")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			mail = receivedMail{from: strings.Trim(line[len("MAIL FROM:"):], "<>")}
+			reply("250 ok")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 ok")
+		case command == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			mail.data = data.String()
+			s.mu.Lock()
+			s.received = append(s.received, mail)
+			s.mu.Unlock()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	host, port := server.addr()
+
+	notifier := NewSMTPNotifier(host, port, "", "", "library@example.com")
+
+	err := notifier.Send("member@example.com", "\"Dune\" is ready for pickup", "Your hold on \"Dune\" is available.\nSee you soon.")
+	assert.NoError(t, err)
+
+	mails := server.mails()
+	if assert.Len(t, mails, 1) {
+		assert.Equal(t, "library@example.com", mails[0].from)
+		assert.Equal(t, []string{"member@example.com"}, mails[0].to)
+		assert.Contains(t, mails[0].data, "Subject: \"Dune\" is ready for pickup\r\n")
+		assert.Contains(t, mails[0].data, "To: member@example.com\r\n")
+		assert.Contains(t, mails[0].data, "See you soon.")
+	}
+}
+
+type fakeNotificationRepository struct {
+	types.NotificationRepository
+	pending []types.Notification
+	sent    map[uint]bool
+}
+
+func (f *fakeNotificationRepository) SendPending(limit int, send func(notification *types.Notification) error) (int, error) {
+	sent := 0
+	for i := range f.pending {
+		if sent == limit {
+			break
+		}
+		if f.sent[f.pending[i].ID] {
+			continue
+		}
+		if err := send(&f.pending[i]); err != nil {
+			continue
+		}
+		f.sent[f.pending[i].ID] = true
+		sent++
+	}
+	return sent, nil
+}
+
+type fakeUserRepository struct {
+	types.UserRepository
+	users map[string]*types.User
+}
+
+func (f *fakeUserRepository) GetByID(id string) (*types.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+func TestDispatch(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	host, port := server.addr()
+
+	notificationRepo := &fakeNotificationRepository{
+		pending: []types.Notification{
+			{ID: 1, UserID: "with-email", Subject: "first", Body: "first body"},
+			{ID: 2, UserID: "without-email", Subject: "second", Body: "second body"},
+		},
+		sent: make(map[uint]bool),
+	}
+	userRepo := &fakeUserRepository{users: map[string]*types.User{
+		"with-email":    {ID: "with-email", Email: "member@example.com"},
+		"without-email": {ID: "without-email"},
+	}}
+	notifier := NewSMTPNotifier(host, port, "", "", "library@example.com")
+
+	t.Run("SendsToTheMembersEmail", func(t *testing.T) {
+		sent, err := Dispatch(notificationRepo, userRepo, notifier)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, sent)
+		assert.True(t, notificationRepo.sent[1])
+		assert.False(t, notificationRepo.sent[2])
+		assert.Len(t, server.mails(), 1)
+	})
+
+	t.Run("DoesNotSendTwice", func(t *testing.T) {
+		sent, err := Dispatch(notificationRepo, userRepo, notifier)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, sent)
+		assert.Len(t, server.mails(), 1)
+	})
+}
+
+func TestNewPasswordReset(t *testing.T) {
+	t.Setenv("PASSWORD_RESET_URL", "https://library.example.com/reset")
+
+	_, body := NewPasswordReset("a+b/c", time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC))
+	assert.Contains(t, body, "https://library.example.com/reset?token=a%2Bb%2Fc")
+}
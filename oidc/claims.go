@@ -0,0 +1,60 @@
+package oidc
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// * what the id token tells about the user
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	GivenName         string
+	FamilyName        string
+	Groups            []string
+}
+
+func claimsFromMap(m jwt.MapClaims, groupsClaim string) (*Claims, error) {
+	claims := Claims{}
+	claims.Issuer, _ = m["iss"].(string)
+	claims.Subject, _ = m["sub"].(string)
+	claims.Email, _ = m["email"].(string)
+	claims.EmailVerified, _ = m["email_verified"].(bool)
+	claims.PreferredUsername, _ = m["preferred_username"].(string)
+	claims.GivenName, _ = m["given_name"].(string)
+	claims.FamilyName, _ = m["family_name"].(string)
+
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	// * some providers send a single group as a plain string
+	switch groups := m[groupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				claims.Groups = append(claims.Groups, name)
+			}
+		}
+	case string:
+		claims.Groups = []string{groups}
+	}
+
+	return &claims, nil
+}
+
+// * the role of the first mapped group the user is in, ok is false when none matches
+func (c *Claims) MappedRole(mapping []GroupRole) (string, bool) {
+	for _, entry := range mapping {
+		for _, group := range c.Groups {
+			if group == entry.Group {
+				return entry.Role, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,92 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// * maps a group of the identity provider to the name of a role
+type GroupRole struct {
+	Group string
+	Role  string
+}
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	RoleMapping  []GroupRole // * earlier entries win when a user is in several mapped groups
+	// * users in none of the mapped groups are turned into members, otherwise their role is left alone
+	DemoteUnmapped bool
+	// * unknown users get an account on their first login, otherwise they need one with a matching email
+	AutoProvision bool
+}
+
+// * "library-admins=admin,library-staff=moderator"
+func ParseRoleMapping(value string) ([]GroupRole, error) {
+	var mapping []GroupRole
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(group) == "" || strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("invalid group mapping %q", pair)
+		}
+		mapping = append(mapping, GroupRole{Group: strings.TrimSpace(group), Role: strings.TrimSpace(role)})
+	}
+	return mapping, nil
+}
+
+// * OIDC_ISSUER enables single sign-on, nil means it is not configured
+func ConfigFromEnv() (*Config, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	config := Config{
+		Issuer:        issuer,
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(os.Getenv("OIDC_SCOPES")),
+		GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		AutoProvision: true,
+	}
+
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	if value, err := strconv.ParseBool(os.Getenv("OIDC_AUTO_PROVISION")); err == nil {
+		config.AutoProvision = value
+	}
+
+	if value, err := strconv.ParseBool(os.Getenv("OIDC_DEMOTE_UNMAPPED")); err == nil {
+		config.DemoteUnmapped = value
+	}
+
+	mapping, err := ParseRoleMapping(os.Getenv("OIDC_ROLE_MAPPING"))
+	if err != nil {
+		return nil, err
+	}
+	config.RoleMapping = mapping
+
+	return &config, nil
+}
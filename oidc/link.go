@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoAccount  = errors.New("there is no account for this identity")
+	ErrEmailTaken = errors.New("the email of this identity is used by another account")
+)
+
+// * finds the user behind the claims, an account with the same verified email gets linked,
+// * anyone else gets a new member account when provisioning is on
+func LinkUser(claims *Claims, config Config, ur types.UserRepository, ir types.IdentityRepository, rr types.RoleRepository, ar types.AuditRepository, actor types.Actor) (*types.User, error) {
+	user, err := findUser(claims, config, ur, ir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncRole(user, claims, config, ur, rr, ar, actor); err != nil {
+		return nil, err
+	}
+
+	// * loads the role that was just assigned
+	return ur.GetByID(user.ID)
+}
+
+func findUser(claims *Claims, config Config, ur types.UserRepository, ir types.IdentityRepository) (*types.User, error) {
+	identity, err := ir.GetBySubject(claims.Issuer, claims.Subject)
+	if err == nil {
+		return ur.GetByID(identity.UserID)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var user *types.User
+	if claims.Email != "" {
+		existing, err := ur.GetByUniqueField("email", claims.Email)
+		if err == nil {
+			// * an unverified email could be anybody's, linking it would hand over the account
+			if !claims.EmailVerified {
+				return nil, ErrEmailTaken
+			}
+			user = existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		if !config.AutoProvision {
+			return nil, ErrNoAccount
+		}
+
+		if user, err = provisionUser(claims, ur); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ir.Create(&types.Identity{UserID: user.ID, Issuer: claims.Issuer, Subject: claims.Subject}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func provisionUser(claims *Claims, ur types.UserRepository) (*types.User, error) {
+	if claims.Email == "" {
+		return nil, errors.New("the identity provider didn't share an email address")
+	}
+
+	username, err := availableUsername(claims, ur)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewString()
+	user := types.User{
+		ID:          id,
+		RoleID:      types.MemberRoleID,
+		Username:    username,
+		Email:       claims.Email,
+		FirstName:   claims.GivenName,
+		LastName:    claims.FamilyName,
+		LibraryCard: "SSO-" + strings.ToUpper(strings.ReplaceAll(id, "-", "")[:12]), // * placeholder until the desk issues a card
+	}
+
+	if claims.EmailVerified {
+		user.Verified = time.Now().Format(time.RFC3339)
+	}
+
+	if err := ur.Create(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func availableUsername(claims *Claims, ur types.UserRepository) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		_, err := ur.GetByUniqueField("username", candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s-%s", base, uuid.NewString()[:6])
+	}
+	return "", fmt.Errorf("couldn't find a free username for %q", base)
+}
+
+// * with a group mapping the identity provider decides the role on every login, users outside the mapped groups
+// * only become members with DemoteUnmapped, so a local admin isn't demoted for missing a group
+func syncRole(user *types.User, claims *Claims, config Config, ur types.UserRepository, rr types.RoleRepository, ar types.AuditRepository, actor types.Actor) error {
+	if len(config.RoleMapping) == 0 {
+		return nil
+	}
+
+	roleID := types.MemberRoleID
+	var role *types.Role
+	if name, ok := claims.MappedRole(config.RoleMapping); ok {
+		var err error
+		role, err = rr.GetByName(name)
+		if err != nil {
+			return fmt.Errorf("mapped role %q doesn't exist: %w", name, err)
+		}
+		roleID = role.ID
+	} else if !config.DemoteUnmapped {
+		return nil
+	}
+
+	if user.RoleID == roleID {
+		return nil
+	}
+
+	before := user.ConvertToUserResponse()
+	user.RoleID = roleID
+	user.Role = role
+	if err := ur.Update(user); err != nil {
+		return err
+	}
+
+	entry := actor.Entry(types.AuditRoleAssigned, "user", user.ID)
+	entry.Changes = types.AuditDiff(before, user.ConvertToUserResponse())
+	entry.Detail = "single sign-on group mapping"
+	return ar.Record(entry)
+}
@@ -0,0 +1,345 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// * serves discovery, keys and a token endpoint that hands out whatever claims the test sets
+type fakeIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(metadata{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/keys",
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kid: "test",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(tokenResponse{IDToken: signed})
+	})
+
+	return issuer
+}
+
+func (f *fakeIssuer) validClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.server.URL,
+		"sub":            "subject-1",
+		"aud":            "library",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "reader@example.com",
+		"email_verified": true,
+		"groups":         []string{"library-staff"},
+	}
+}
+
+func TestProvider(t *testing.T) {
+	issuer := newFakeIssuer(t)
+
+	provider, err := NewProvider(context.Background(), Config{
+		Issuer:      issuer.server.URL,
+		ClientID:    "library",
+		RedirectURL: "http://localhost:3000/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		GroupsClaim: "groups",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("AuthCodeURLCarriesStateNonceAndChallenge", func(t *testing.T) {
+		target, err := url.Parse(provider.AuthCodeURL("the-state", "the-nonce", "the-verifier"))
+		if assert.NoError(t, err) {
+			query := target.Query()
+			assert.Equal(t, "the-state", query.Get("state"))
+			assert.Equal(t, "the-nonce", query.Get("nonce"))
+			assert.Equal(t, codeChallenge("the-verifier"), query.Get("code_challenge"))
+			assert.Equal(t, "S256", query.Get("code_challenge_method"))
+		}
+	})
+
+	t.Run("ExchangesCodeForVerifiedClaims", func(t *testing.T) {
+		issuer.claims = issuer.validClaims("the-nonce")
+
+		claims, err := provider.Exchange(context.Background(), "good-code", "the-verifier", "the-nonce")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "subject-1", claims.Subject)
+			assert.Equal(t, "reader@example.com", claims.Email)
+			assert.True(t, claims.EmailVerified)
+			assert.Equal(t, []string{"library-staff"}, claims.Groups)
+		}
+	})
+
+	t.Run("RejectsWrongNonce", func(t *testing.T) {
+		issuer.claims = issuer.validClaims("someone-elses-nonce")
+
+		_, err := provider.Exchange(context.Background(), "good-code", "the-verifier", "the-nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsTokenForAnotherClient", func(t *testing.T) {
+		issuer.claims = issuer.validClaims("the-nonce")
+		issuer.claims["aud"] = "another-app"
+
+		_, err := provider.Exchange(context.Background(), "good-code", "the-verifier", "the-nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsExpiredToken", func(t *testing.T) {
+		issuer.claims = issuer.validClaims("the-nonce")
+		issuer.claims["exp"] = time.Now().Add(-time.Hour).Unix()
+
+		_, err := provider.Exchange(context.Background(), "good-code", "the-verifier", "the-nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsBadCode", func(t *testing.T) {
+		_, err := provider.Exchange(context.Background(), "bad-code", "the-verifier", "the-nonce")
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsWrongIssuer", func(t *testing.T) {
+		_, err := NewProvider(context.Background(), Config{Issuer: issuer.server.URL + "/other", ClientID: "library"})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := ParseRoleMapping("library-admins=admin, library-staff=moderator")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []GroupRole{{Group: "library-admins", Role: "admin"}, {Group: "library-staff", Role: "moderator"}}, mapping)
+	}
+
+	_, err = ParseRoleMapping("library-admins")
+	assert.Error(t, err)
+}
+
+type fakeUserRepository struct {
+	types.UserRepository
+	users map[string]*types.User
+}
+
+func (f *fakeUserRepository) Create(user *types.User) error {
+	created := *user
+	f.users[user.ID] = &created
+	return nil
+}
+
+func (f *fakeUserRepository) GetByID(id string) (*types.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (f *fakeUserRepository) GetByUniqueField(field, value string) (*types.User, error) {
+	for _, user := range f.users {
+		if (field == "email" && user.Email == value) || (field == "username" && user.Username == value) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeUserRepository) Update(user *types.User) error {
+	updated := *user
+	f.users[user.ID] = &updated
+	return nil
+}
+
+type fakeIdentityRepository struct {
+	types.IdentityRepository
+	identities []types.Identity
+}
+
+func (f *fakeIdentityRepository) Create(identity *types.Identity) error {
+	f.identities = append(f.identities, *identity)
+	return nil
+}
+
+func (f *fakeIdentityRepository) GetBySubject(issuer, subject string) (*types.Identity, error) {
+	for _, identity := range f.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			found := identity
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeRoleRepository struct {
+	types.RoleRepository
+	roles []types.Role
+}
+
+func (f *fakeRoleRepository) GetByName(name string) (*types.Role, error) {
+	for _, role := range f.roles {
+		if role.Name == name {
+			found := role
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeAuditRepository struct {
+	types.AuditRepository
+	entries []*types.AuditEntry
+}
+
+func (f *fakeAuditRepository) Record(entry *types.AuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func TestLinkUser(t *testing.T) {
+	userRepo := &fakeUserRepository{users: map[string]*types.User{
+		"existing": {ID: "existing", Username: "reader", Email: "reader@example.com", RoleID: types.MemberRoleID},
+	}}
+	identityRepo := &fakeIdentityRepository{}
+	roleRepo := &fakeRoleRepository{roles: []types.Role{
+		{ID: types.MemberRoleID, Name: "member"},
+		{ID: types.ModeratorRoleID, Name: "moderator"},
+	}}
+	auditRepo := &fakeAuditRepository{}
+
+	config := Config{
+		AutoProvision: true,
+		RoleMapping:   []GroupRole{{Group: "library-staff", Role: "moderator"}},
+	}
+
+	t.Run("RefusesToLinkAnUnverifiedEmail", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "attacker", Email: "reader@example.com"}
+
+		_, err := LinkUser(claims, config, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		assert.ErrorIs(t, err, ErrEmailTaken)
+		assert.Empty(t, identityRepo.identities)
+	})
+
+	t.Run("LinksAccountWithTheSameVerifiedEmail", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "subject-1", Email: "reader@example.com", EmailVerified: true}
+
+		user, err := LinkUser(claims, config, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "existing", user.ID)
+			assert.Len(t, identityRepo.identities, 1)
+		}
+	})
+
+	t.Run("MapsGroupsToRoles", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "subject-1", Groups: []string{"library-staff"}}
+
+		user, err := LinkUser(claims, config, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "existing", user.ID)
+			assert.Equal(t, types.ModeratorRoleID, user.RoleID)
+			if assert.Len(t, auditRepo.entries, 1) {
+				assert.Equal(t, types.AuditRoleAssigned, auditRepo.entries[0].Action)
+				assert.Equal(t, "existing", auditRepo.entries[0].TargetID)
+			}
+		}
+	})
+
+	t.Run("KeepsTheRoleOfUsersOutsideTheMappedGroups", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "subject-1"}
+
+		user, err := LinkUser(claims, config, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, types.ModeratorRoleID, user.RoleID)
+			assert.Len(t, auditRepo.entries, 1)
+		}
+	})
+
+	t.Run("DemotesUsersThatLeftTheMappedGroupsWhenAllowed", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "subject-1"}
+		demoting := config
+		demoting.DemoteUnmapped = true
+
+		user, err := LinkUser(claims, demoting, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, types.MemberRoleID, user.RoleID)
+			assert.Len(t, auditRepo.entries, 2)
+		}
+	})
+
+	t.Run("ProvisionsNewMembers", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "subject-2", Email: "newcomer@example.com", EmailVerified: true, PreferredUsername: "reader"}
+
+		user, err := LinkUser(claims, config, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		if assert.NoError(t, err) {
+			assert.NotEqual(t, "existing", user.ID)
+			assert.NotEqual(t, "reader", user.Username)
+			assert.Contains(t, user.Username, "reader-")
+			assert.Equal(t, types.MemberRoleID, user.RoleID)
+			assert.NotEmpty(t, user.Verified)
+			assert.Len(t, identityRepo.identities, 2)
+		}
+	})
+
+	t.Run("RefusesUnknownIdentitiesWithoutProvisioning", func(t *testing.T) {
+		claims := &Claims{Issuer: "https://idp", Subject: "subject-3", Email: "stranger@example.com", EmailVerified: true}
+
+		_, err := LinkUser(claims, Config{}, userRepo, identityRepo, roleRepo, auditRepo, types.Actor{})
+		assert.ErrorIs(t, err, ErrNoAccount)
+	})
+}
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gimtwi/go-library-project/circulation"
+	help "github.com/gimtwi/go-library-project/helpers"
+	"github.com/gimtwi/go-library-project/notify"
+	"github.com/gimtwi/go-library-project/types"
+)
+
+// * available holds that were not picked up in time give their copies to the next in line
+func ExpireHolds(cs *circulation.Service) Job {
+	return func(now time.Time) error {
+		return cs.ExpireHolds(now)
+	}
+}
+
+// * postponed holds get back in line once their delivery date has passed
+func ReleasePostponedHolds(cs *circulation.Service) Job {
+	return func(now time.Time) error {
+		return cs.ReleasePostponedHolds(now)
+	}
+}
+
+// * keeps the fines of loans that are still out up to date, returned loans are assessed on return,
+// * a loan that fails is retried on the next run and doesn't keep the others unfined
+func AccrueFines(lr types.LoanRepository, fr types.FineRepository, ir types.ItemRepository) Job {
+	return func(now time.Time) error {
+		loans, err := lr.GetOverdue(now)
+		if err != nil {
+			return err
+		}
+
+		var failed []error
+		for _, loan := range loans {
+			if err := help.AssessFine(&loan, now, fr, ir); err != nil {
+				log.Printf("scheduler: couldn't fine loan %d: %v", loan.ID, err)
+				failed = append(failed, err)
+			}
+		}
+
+		return errors.Join(failed...)
+	}
+}
+
+// * queues a reminder for loans that are about to expire and a warning for loans that already did,
+// * a loan that fails is retried on the next run and doesn't keep the others from being reminded
+func RemindLoans(lr types.LoanRepository, ir types.ItemRepository, nr types.NotificationRepository) Job {
+	return func(now time.Time) error {
+		var failed []error
+		remind := func(loans []types.Loan, notice func(loan *types.Loan, item *types.Item) *types.Notification) {
+			for _, loan := range loans {
+				item, err := ir.GetByID(loan.ItemID)
+				if err == nil {
+					err = nr.Enqueue(notice(&loan, item))
+				}
+
+				if err != nil {
+					log.Printf("scheduler: couldn't remind loan %d: %v", loan.ID, err)
+					failed = append(failed, err)
+				}
+			}
+		}
+
+		dueSoon, err := lr.GetDueSoon(now)
+		if err != nil {
+			failed = append(failed, err)
+		} else {
+			remind(dueSoon, notify.NewLoanDueSoon)
+		}
+
+		overdue, err := lr.GetOverdue(now)
+		if err != nil {
+			failed = append(failed, err)
+		} else {
+			remind(overdue, notify.NewLoanOverdue)
+		}
+
+		return errors.Join(failed...)
+	}
+}
+
+// * revoked sessions are kept until they expire, then they can go
+func PruneSessions(sr types.SessionRepository) Job {
+	return func(now time.Time) error {
+		return sr.DeleteExpired(now)
+	}
+}
+
+func PrunePasswordResets(pr types.PasswordResetRepository) Job {
+	return func(now time.Time) error {
+		return pr.DeleteExpired(now)
+	}
+}
+
+// * failed logins are only remembered for LOGIN_FAILURE_WINDOW
+func PruneLoginThrottles(tr types.LoginThrottleRepository) Job {
+	return func(now time.Time) error {
+		return tr.DeleteStale(now, help.LoginFailureWindow())
+	}
+}
+
+func DispatchNotifications(nr types.NotificationRepository, ur types.UserRepository, n notify.Notifier) Job {
+	return func(now time.Time) error {
+		_, err := notify.Dispatch(nr, ur, n)
+		return err
+	}
+}
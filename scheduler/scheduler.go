@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	help "github.com/gimtwi/go-library-project/helpers"
+)
+
+const defaultInterval = 5 * time.Minute
+
+type Job func(now time.Time) error
+
+type namedJob struct {
+	name string
+	run  Job
+}
+
+// * runs the registered jobs one after another every interval until stopped
+type Scheduler struct {
+	clock    help.Clock
+	interval time.Duration
+	jobs     []namedJob
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func New(clock help.Clock, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		clock:    clock,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// * SCHEDULER_INTERVAL accepts anything time.ParseDuration does, e.g. "30s" or "5m"
+func IntervalFromEnv() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("SCHEDULER_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return defaultInterval
+	}
+	return interval
+}
+
+func (s *Scheduler) Register(name string, job Job) {
+	s.jobs = append(s.jobs, namedJob{name: name, run: job})
+}
+
+func (s *Scheduler) RunOnce() {
+	now := s.clock.Now()
+	for _, job := range s.jobs {
+		if err := job.run(now); err != nil {
+			log.Printf("scheduler: %s failed: %v", job.name, err)
+		}
+	}
+}
+
+func (s *Scheduler) Start() {
+	s.started = true
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RunOnce()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// * waits for the job that is currently running to finish
+func (s *Scheduler) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+		if s.started {
+			<-s.done
+		}
+	})
+}
@@ -0,0 +1,391 @@
+package scheduler
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gimtwi/go-library-project/circulation"
+	"github.com/gimtwi/go-library-project/types"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+type fakeHoldRepository struct {
+	types.HoldRepository
+	holds map[uint]*types.Hold
+}
+
+func (f *fakeHoldRepository) GetByItemID(itemID uint) ([]types.Hold, error) {
+	var holds []types.Hold
+	for _, hold := range f.holds {
+		if hold.ItemID == itemID {
+			holds = append(holds, *hold)
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].PlacedDate.Before(holds[j].PlacedDate)
+	})
+	return holds, nil
+}
+
+func (f *fakeHoldRepository) GetExpired(now time.Time) ([]types.Hold, error) {
+	var holds []types.Hold
+	for _, hold := range f.holds {
+		if hold.IsAvailable && hold.ExpiryDate.Before(now) {
+			holds = append(holds, *hold)
+		}
+	}
+	return holds, nil
+}
+
+func (f *fakeHoldRepository) GetPostponedDue(now time.Time) ([]types.Hold, error) {
+	var holds []types.Hold
+	for _, hold := range f.holds {
+		if hold.IsPostponed && !hold.DeliveryDate.After(now) {
+			holds = append(holds, *hold)
+		}
+	}
+	return holds, nil
+}
+
+func (f *fakeHoldRepository) Update(hold *types.Hold) error {
+	updated := *hold
+	f.holds[hold.ID] = &updated
+	return nil
+}
+
+func (f *fakeHoldRepository) Delete(id uint) error {
+	delete(f.holds, id)
+	return nil
+}
+
+type fakeCopyRepository struct {
+	types.CopyRepository
+	copies map[uint]*types.Copy
+}
+
+func (f *fakeCopyRepository) GetByItemID(itemID uint) ([]types.Copy, error) {
+	var copies []types.Copy
+	for _, cp := range f.copies {
+		if cp.ItemID == itemID {
+			copies = append(copies, *cp)
+		}
+	}
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].ID < copies[j].ID
+	})
+	return copies, nil
+}
+
+func (f *fakeCopyRepository) Update(cp *types.Copy) error {
+	updated := *cp
+	f.copies[cp.ID] = &updated
+	return nil
+}
+
+type fakeItemRepository struct {
+	types.ItemRepository
+	items map[uint]*types.Item
+}
+
+func (f *fakeItemRepository) GetByID(id uint) (*types.Item, error) {
+	item, ok := f.items[id]
+	if !ok {
+		return nil, errors.New("item not found")
+	}
+	return item, nil
+}
+
+func (f *fakeItemRepository) Lock(id uint) error {
+	if _, ok := f.items[id]; !ok {
+		return errors.New("item not found")
+	}
+	return nil
+}
+
+type fakeUserRepository struct {
+	types.UserRepository
+}
+
+func (f *fakeUserRepository) GetByID(id string) (*types.User, error) {
+	return &types.User{ID: id, Category: types.DefaultPatronCategory}, nil
+}
+
+type fakeRuleRepository struct {
+	types.CirculationRuleRepository
+	rules []types.CirculationRule
+}
+
+func (f *fakeRuleRepository) GetAll() ([]types.CirculationRule, error) {
+	return f.rules, nil
+}
+
+type fakeCalendarRepository struct {
+	types.CalendarRepository
+	closures []types.Closure
+}
+
+func (f *fakeCalendarRepository) GetHours() ([]types.OpeningHours, error) {
+	return nil, nil
+}
+
+func (f *fakeCalendarRepository) GetClosures(filter types.ClosureFilter) ([]types.Closure, error) {
+	return f.closures, nil
+}
+
+type fakeNotificationRepository struct {
+	types.NotificationRepository
+	queued map[string]types.Notification
+}
+
+func (f *fakeNotificationRepository) Enqueue(notification *types.Notification) error {
+	if _, ok := f.queued[notification.DedupKey]; !ok {
+		f.queued[notification.DedupKey] = *notification
+	}
+	return nil
+}
+
+type fakeLoanRepository struct {
+	types.LoanRepository
+	dueSoon []types.Loan
+	overdue []types.Loan
+}
+
+func (f *fakeLoanRepository) GetDueSoon(now time.Time) ([]types.Loan, error) {
+	return f.dueSoon, nil
+}
+
+func (f *fakeLoanRepository) GetOverdue(now time.Time) ([]types.Loan, error) {
+	return f.overdue, nil
+}
+
+type fakeFineRepository struct {
+	types.FineRepository
+	charges map[uint]types.Fine
+}
+
+func (f *fakeFineRepository) GetChargeByLoanID(loanID uint) (*types.Fine, error) {
+	charge, ok := f.charges[loanID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &charge, nil
+}
+
+func (f *fakeFineRepository) Create(fine *types.Fine) error {
+	f.charges[*fine.LoanID] = *fine
+	return nil
+}
+
+func TestAccrueFines(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	// * the item of the first loan is gone, the second one still has to be fined
+	loanRepo := &fakeLoanRepository{overdue: []types.Loan{
+		{ID: 1, ItemID: 404, UserID: "first", ExpireDate: now.Add(-72 * time.Hour)},
+		{ID: 2, ItemID: 1, UserID: "second", ExpireDate: now.Add(-72 * time.Hour)},
+	}}
+	fineRepo := &fakeFineRepository{charges: make(map[uint]types.Fine)}
+	itemRepo := &fakeItemRepository{items: map[uint]*types.Item{1: {ID: 1}}}
+
+	err := AccrueFines(loanRepo, fineRepo, itemRepo)(now)
+
+	t.Run("ReportsTheLoanThatFailed", func(t *testing.T) {
+		assert.Error(t, err)
+		assert.NotContains(t, fineRepo.charges, uint(1))
+	})
+
+	t.Run("FinesTheLoansAfterIt", func(t *testing.T) {
+		assert.Contains(t, fineRepo.charges, uint(2))
+	})
+}
+
+func TestRemindLoans(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	// * the item of the first loan is gone, the others still have to be reminded
+	loanRepo := &fakeLoanRepository{
+		dueSoon: []types.Loan{
+			{ID: 1, ItemID: 404, UserID: "first", ExpireDate: now.Add(24 * time.Hour)},
+			{ID: 2, ItemID: 1, UserID: "second", ExpireDate: now.Add(24 * time.Hour)},
+		},
+		overdue: []types.Loan{
+			{ID: 3, ItemID: 1, UserID: "third", ExpireDate: now.Add(-24 * time.Hour)},
+		},
+	}
+	itemRepo := &fakeItemRepository{items: map[uint]*types.Item{1: {ID: 1}}}
+	notificationRepo := &fakeNotificationRepository{queued: make(map[string]types.Notification)}
+
+	err := RemindLoans(loanRepo, itemRepo, notificationRepo)(now)
+
+	t.Run("ReportsTheLoanThatFailed", func(t *testing.T) {
+		assert.Error(t, err)
+	})
+
+	t.Run("RemindsTheLoansAfterIt", func(t *testing.T) {
+		kinds := make(map[string]types.NotificationKind)
+		for _, notification := range notificationRepo.queued {
+			kinds[notification.UserID] = notification.Kind
+		}
+		assert.Equal(t, map[string]types.NotificationKind{"second": types.LoanDueSoon, "third": types.LoanOverdue}, kinds)
+	})
+}
+
+func TestExpireHolds(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)}
+
+	firstCopy, thirdCopy := uint(10), uint(20)
+
+	holdRepo := &fakeHoldRepository{holds: map[uint]*types.Hold{
+		1: {ID: 1, ItemID: 1, UserID: "first", PlacedDate: clock.now.Add(-96 * time.Hour), IsAvailable: true, CopyID: &firstCopy, InLinePosition: 1, ExpiryDate: clock.now.Add(-time.Hour)},
+		2: {ID: 2, ItemID: 1, UserID: "second", PlacedDate: clock.now.Add(-72 * time.Hour), InLinePosition: 2},
+		3: {ID: 3, ItemID: 2, UserID: "third", PlacedDate: clock.now.Add(-72 * time.Hour), IsAvailable: true, CopyID: &thirdCopy, InLinePosition: 1, ExpiryDate: clock.now.Add(time.Hour)},
+	}}
+	copyRepo := &fakeCopyRepository{copies: map[uint]*types.Copy{
+		firstCopy: {ID: firstCopy, ItemID: 1, Status: types.CopyOnHoldShelf},
+		thirdCopy: {ID: thirdCopy, ItemID: 2, Status: types.CopyOnHoldShelf},
+	}}
+	itemRepo := &fakeItemRepository{items: map[uint]*types.Item{
+		1: {ID: 1},
+		2: {ID: 2},
+	}}
+	notificationRepo := &fakeNotificationRepository{queued: make(map[string]types.Notification)}
+
+	pickupDays := uint(1)
+	ruleRepo := &fakeRuleRepository{rules: []types.CirculationRule{{PatronCategory: types.DefaultPatronCategory, PickupDays: &pickupDays}}}
+
+	// * the day after is closed, so the pickup deadline moves on to the day after that
+	closed := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	calendarRepo := &fakeCalendarRepository{closures: []types.Closure{{Name: "closed", StartDate: closed, EndDate: closed}}}
+
+	store := &types.Store{Users: &fakeUserRepository{}, Rules: ruleRepo, Calendar: calendarRepo, Holds: holdRepo, Copies: copyRepo, Items: itemRepo, Notifications: notificationRepo}
+	service := circulation.NewService(store, clock, circulation.DefaultPolicy(), time.UTC)
+
+	s := New(clock, time.Hour)
+	s.Register("expire holds", ExpireHolds(service))
+
+	t.Run("DeletesExpiredHoldAndPromotesNextInLine", func(t *testing.T) {
+		s.RunOnce()
+
+		assert.NotContains(t, holdRepo.holds, uint(1))
+		assert.True(t, holdRepo.holds[2].IsAvailable)
+		assert.Equal(t, uint(1), holdRepo.holds[2].InLinePosition)
+	})
+
+	t.Run("WaitsThePickupPeriodOfThePatronUntilAnOpenDay", func(t *testing.T) {
+		assert.Equal(t, clock.now.Add(48*time.Hour), holdRepo.holds[2].ExpiryDate)
+	})
+
+	t.Run("HandsTheShelvedCopyToNextInLine", func(t *testing.T) {
+		if assert.NotNil(t, holdRepo.holds[2].CopyID) {
+			assert.Equal(t, firstCopy, *holdRepo.holds[2].CopyID)
+		}
+		assert.Equal(t, types.CopyOnHoldShelf, copyRepo.copies[firstCopy].Status)
+	})
+
+	t.Run("NotifiesPromotedHold", func(t *testing.T) {
+		assert.Len(t, notificationRepo.queued, 1)
+		for _, notification := range notificationRepo.queued {
+			assert.Equal(t, "second", notification.UserID)
+			assert.Equal(t, types.HoldAvailable, notification.Kind)
+		}
+	})
+
+	t.Run("KeepsHoldsThatHaveNotExpired", func(t *testing.T) {
+		assert.Contains(t, holdRepo.holds, uint(3))
+		assert.True(t, holdRepo.holds[3].IsAvailable)
+	})
+
+	t.Run("ExpiresOnceTheClockPassesExpiryDate", func(t *testing.T) {
+		clock.now = clock.now.Add(2 * time.Hour)
+		s.RunOnce()
+
+		assert.NotContains(t, holdRepo.holds, uint(3))
+		assert.Contains(t, holdRepo.holds, uint(2))
+		assert.Equal(t, types.CopyAvailable, copyRepo.copies[thirdCopy].Status)
+	})
+}
+
+func TestReleasePostponedHolds(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, time.March, 12, 12, 0, 0, 0, time.UTC)}
+
+	shelved := uint(10)
+
+	holdRepo := &fakeHoldRepository{holds: map[uint]*types.Hold{
+		1: {ID: 1, ItemID: 1, UserID: "first", PickupBranchID: types.MainBranchID, PlacedDate: clock.now.Add(-48 * time.Hour), DeliveryDate: clock.now.Add(time.Hour), IsPostponed: true, InLinePosition: 2},
+		2: {ID: 2, ItemID: 1, UserID: "second", PickupBranchID: types.MainBranchID, PlacedDate: clock.now.Add(-72 * time.Hour), DeliveryDate: clock.now.Add(-72 * time.Hour), IsAvailable: true, CopyID: &shelved, InLinePosition: 1, ExpiryDate: clock.now.Add(24 * time.Hour)},
+	}}
+	copyRepo := &fakeCopyRepository{copies: map[uint]*types.Copy{
+		shelved: {ID: shelved, ItemID: 1, BranchID: types.MainBranchID, Status: types.CopyOnHoldShelf},
+		20:      {ID: 20, ItemID: 1, BranchID: types.MainBranchID, Status: types.CopyAvailable},
+	}}
+	itemRepo := &fakeItemRepository{items: map[uint]*types.Item{1: {ID: 1}}}
+	notificationRepo := &fakeNotificationRepository{queued: make(map[string]types.Notification)}
+
+	store := &types.Store{Users: &fakeUserRepository{}, Rules: &fakeRuleRepository{}, Calendar: &fakeCalendarRepository{}, Holds: holdRepo, Copies: copyRepo, Items: itemRepo, Notifications: notificationRepo}
+	service := circulation.NewService(store, clock, circulation.DefaultPolicy(), time.UTC)
+
+	s := New(clock, time.Hour)
+	s.Register("release postponed holds", ReleasePostponedHolds(service))
+
+	t.Run("KeepsHoldsBeforeTheirDeliveryDate", func(t *testing.T) {
+		s.RunOnce()
+
+		assert.True(t, holdRepo.holds[1].IsPostponed)
+		assert.False(t, holdRepo.holds[1].IsAvailable)
+		assert.Equal(t, types.CopyAvailable, copyRepo.copies[20].Status)
+	})
+
+	t.Run("ReturnsToTheLineAfterTheDate", func(t *testing.T) {
+		clock.now = clock.now.Add(2 * time.Hour)
+		s.RunOnce()
+
+		assert.False(t, holdRepo.holds[1].IsPostponed)
+		assert.True(t, holdRepo.holds[1].IsAvailable)
+		if assert.NotNil(t, holdRepo.holds[1].CopyID) {
+			assert.Equal(t, uint(20), *holdRepo.holds[1].CopyID)
+		}
+		assert.Equal(t, types.CopyOnHoldShelf, copyRepo.copies[20].Status)
+	})
+
+	t.Run("LeavesTheHoldAheadAlone", func(t *testing.T) {
+		assert.True(t, holdRepo.holds[2].IsAvailable)
+		if assert.NotNil(t, holdRepo.holds[2].CopyID) {
+			assert.Equal(t, shelved, *holdRepo.holds[2].CopyID)
+		}
+	})
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	var runs int32
+
+	s := New(&fakeClock{now: time.Now()}, 10*time.Millisecond)
+	s.Register("count", func(now time.Time) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	s.Start()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	s.Stop()
+	stoppedAt := atomic.LoadInt32(&runs)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stoppedAt, atomic.LoadInt32(&runs))
+
+	// * stopping twice must not block or panic
+	s.Stop()
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * a long lived credential for integrations, it acts as its user but only with the permissions it was scoped to
+type APIKey struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Name        string       `json:"name"`
+	UserID      string       `gorm:"index" json:"userID"`
+	CreatedByID string       `json:"createdByID"`
+	Prefix      string       `json:"prefix"` // * the start of the key, enough to recognize it in a list
+	KeyHash     string       `gorm:"uniqueIndex" json:"-"`
+	Permissions []Permission `gorm:"serializer:json" json:"permissions"`
+	LastUsedAt  *time.Time   `json:"lastUsedAt"`
+	ExpiresAt   *time.Time   `json:"expiresAt"`
+	RevokedAt   *time.Time   `json:"revokedAt"`
+}
+
+type APIKeyRequest struct {
+	Name        string       `json:"name" binding:"required"`
+	UserID      string       `json:"userID" binding:"required"`
+	Permissions []Permission `json:"permissions" binding:"required"`
+	ExpiresAt   *time.Time   `json:"expiresAt"`
+}
+
+// * the only time the key itself is shown
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyRepository interface {
+	Create(key *APIKey) error
+	GetAll(req PageRequest) (*Page[APIKey], error)
+	GetByID(id uint) (*APIKey, error)
+	GetByHash(hash string) (*APIKey, error)
+	Touch(id uint, now time.Time) error
+	Revoke(id uint, now time.Time) error
+}
+
+type APIKeyRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &APIKeyRepositoryImpl{db}
+}
+
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// * what the key can do right now, its scopes are cut down to what its user is still allowed
+func (k *APIKey) EffectiveRole(role *Role) *Role {
+	scoped := Role{ID: role.ID, Name: role.Name, Permissions: []Permission{}}
+	for _, permission := range k.Permissions {
+		if role.HasPermission(permission) {
+			scoped.Permissions = append(scoped.Permissions, permission)
+		}
+	}
+	return &scoped
+}
+
+func (r *APIKeyRepositoryImpl) Create(key *APIKey) error {
+	return r.db.Create(key).Error
+}
+
+var apiKeysByCreatedAt = keyset[APIKey, time.Time, uint]{
+	keyColumn: "created_at",
+	idColumn:  "id",
+	values:    func(key *APIKey) (time.Time, uint) { return key.CreatedAt, key.ID },
+}
+
+func (r *APIKeyRepositoryImpl) GetAll(req PageRequest) (*Page[APIKey], error) {
+	return paginate(r.db.Model(&APIKey{}).Where("name LIKE ?", req.Filter+"%"), req, apiKeysByCreatedAt)
+}
+
+func (r *APIKeyRepositoryImpl) GetByID(id uint) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.Where("id = ?", id).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepositoryImpl) GetByHash(hash string) (*APIKey, error) {
+	var key APIKey
+	if err := r.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepositoryImpl) Touch(id uint, now time.Time) error {
+	return r.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", now).Error
+}
+
+func (r *APIKeyRepositoryImpl) Revoke(id uint, now time.Time) error {
+	return r.db.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", now).Error
+}
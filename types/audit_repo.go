@@ -0,0 +1,199 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditLogin           AuditAction = "auth.login"
+	AuditLoginFailed     AuditAction = "auth.login_failed"
+	AuditUserRegistered  AuditAction = "user.register"
+	AuditUserDeleted     AuditAction = "user.delete"
+	AuditRoleAssigned    AuditAction = "user.assign_role"
+	AuditCategoryChanged AuditAction = "user.assign_category"
+	AuditBranchAssigned  AuditAction = "user.assign_branch"
+	AuditRoleCreated     AuditAction = "role.create"
+	AuditRoleUpdated     AuditAction = "role.update"
+	AuditRoleDeleted     AuditAction = "role.delete"
+	AuditAPIKeyCreated   AuditAction = "api_key.create"
+	AuditAPIKeyRevoked   AuditAction = "api_key.revoke"
+	AuditUserUnlocked    AuditAction = "security.unlock"
+	AuditTwoFactorReset  AuditAction = "security.reset_2fa"
+	AuditSessionsRevoked AuditAction = "security.revoke_sessions"
+	AuditItemDeleted     AuditAction = "item.delete"
+	AuditHoldResolved    AuditAction = "hold.resolve"
+	AuditLoanReturned    AuditAction = "loan.return"
+	AuditCopyCheckedOut  AuditAction = "desk.checkout"
+	AuditCopyCheckedIn   AuditAction = "desk.checkin"
+	AuditPaymentRecorded AuditAction = "fine.payment"
+	AuditFinesWaived     AuditAction = "fine.waive"
+	AuditRuleCreated     AuditAction = "policy.create"
+	AuditRuleUpdated     AuditAction = "policy.update"
+	AuditRuleDeleted     AuditAction = "policy.delete"
+	AuditHoursChanged    AuditAction = "calendar.hours"
+	AuditClosureCreated  AuditAction = "calendar.create"
+	AuditClosureUpdated  AuditAction = "calendar.update"
+	AuditClosureDeleted  AuditAction = "calendar.delete"
+	AuditClosuresImport  AuditAction = "calendar.import"
+	AuditBranchCreated   AuditAction = "branch.create"
+	AuditBranchUpdated   AuditAction = "branch.update"
+	AuditBranchDeleted   AuditAction = "branch.delete"
+)
+
+// * one value of a field before and after the action, a created record has no before and a deleted one no after
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+type AuditChanges map[string]AuditChange
+
+// * an entry is never updated or deleted, the table refuses it on its own
+type AuditEntry struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+
+	ActorID    string       `gorm:"index" json:"actorID"` // * empty when nobody is signed in, like for failed logins
+	APIKeyID   *uint        `json:"apiKeyID,omitempty"`
+	Action     AuditAction  `gorm:"index" json:"action"`
+	TargetType string       `gorm:"index:idx_audit_target" json:"targetType"`
+	TargetID   string       `gorm:"index:idx_audit_target" json:"targetID"`
+	Changes    AuditChanges `gorm:"serializer:json" json:"changes,omitempty"`
+	Detail     string       `json:"detail,omitempty"`
+	IP         string       `json:"ip"`
+}
+
+// * who asked for an action
+type Actor struct {
+	UserID   string
+	APIKeyID *uint
+	IP       string
+	BranchID *uint // * the branch the staff member works at, nil for staff of every branch
+}
+
+func (a Actor) WorksAt(branchID uint) bool {
+	return a.BranchID == nil || *a.BranchID == branchID
+}
+
+func (a Actor) Entry(action AuditAction, targetType, targetID string) *AuditEntry {
+	return &AuditEntry{
+		ActorID:    a.UserID,
+		APIKeyID:   a.APIKeyID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         a.IP,
+	}
+}
+
+// * the query string of the audit endpoint, times are RFC3339
+type AuditFilter struct {
+	ActorID    string     `form:"actor"`
+	Action     string     `form:"action"`
+	TargetType string     `form:"targetType"`
+	TargetID   string     `form:"targetID"`
+	From       *time.Time `form:"from"`
+	To         *time.Time `form:"to"`
+}
+
+type AuditRepository interface {
+	Record(entry *AuditEntry) error
+	List(filter AuditFilter, req PageRequest) (*Page[AuditEntry], error)
+	Export(filter AuditFilter, batch func(entries []AuditEntry) error) error
+}
+
+type AuditRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &AuditRepositoryImpl{db}
+}
+
+// * the fields that differ between two versions of a record, either of them may be nil
+func AuditDiff(before, after interface{}) AuditChanges {
+	was, now := auditFields(before), auditFields(after)
+
+	changes := AuditChanges{}
+	for field, value := range was {
+		if !reflect.DeepEqual(value, now[field]) {
+			changes[field] = AuditChange{Before: value, After: now[field]}
+		}
+	}
+	for field, value := range now {
+		if _, ok := was[field]; !ok && value != nil {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+
+	// * the timestamp of the change is the entry itself
+	delete(changes, "updatedAt")
+	return changes
+}
+
+// * the json form is what the api shows, so hidden fields stay out of the log as well
+func auditFields(record interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if record == nil || reflect.ValueOf(record).IsZero() {
+		return fields
+	}
+
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(raw, &fields)
+	return fields
+}
+
+func (a *AuditRepositoryImpl) Record(entry *AuditEntry) error {
+	return a.db.Create(entry).Error
+}
+
+var auditEntriesByCreatedAt = keyset[AuditEntry, time.Time, uint]{
+	keyColumn: "created_at",
+	idColumn:  "id",
+	values:    func(entry *AuditEntry) (time.Time, uint) { return entry.CreatedAt, entry.ID },
+}
+
+func (a *AuditRepositoryImpl) filtered(filter AuditFilter) *gorm.DB {
+	query := a.db.Model(&AuditEntry{})
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		// * "security" matches every security action, "auth.login" only itself
+		query = query.Where("action = ? OR action LIKE ?", filter.Action, filter.Action+".%")
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	return query
+}
+
+func (a *AuditRepositoryImpl) List(filter AuditFilter, req PageRequest) (*Page[AuditEntry], error) {
+	return paginate(a.filtered(filter), req, auditEntriesByCreatedAt)
+}
+
+// * hands over every matching entry oldest first, a few hundred at a time so large exports don't sit in memory
+func (a *AuditRepositoryImpl) Export(filter AuditFilter, batch func(entries []AuditEntry) error) error {
+	var entries []AuditEntry
+	return a.filtered(filter).FindInBatches(&entries, 500, func(tx *gorm.DB, _ int) error {
+		return batch(entries)
+	}).Error
+}
@@ -18,7 +18,7 @@ type Author struct {
 
 type AuthorRepository interface {
 	Create(author *Author) error
-	GetAll(order, filter string, limit uint) ([]Author, error)
+	GetAll(req PageRequest) (*Page[Author], error)
 	GetByID(id uint) (*Author, error)
 	Update(author *Author) error
 	Delete(id uint) error
@@ -36,12 +36,15 @@ func (a *AuthorRepositoryImpl) Create(author *Author) error {
 	return a.db.Create(author).Error
 }
 
-func (a *AuthorRepositoryImpl) GetAll(order, filter string, limit uint) ([]Author, error) {
-	var authors []Author
-	if err := a.db.Preload("Items").Order("name "+order).Where("name LIKE ?", filter+"%").Limit(int(limit)).Find(&authors).Error; err != nil {
-		return nil, err
-	}
-	return authors, nil
+var authorsByName = keyset[Author, string, uint]{
+	keyColumn: "name",
+	idColumn:  "id",
+	preloads:  []string{"Items"},
+	values:    func(author *Author) (string, uint) { return author.Name, author.ID },
+}
+
+func (a *AuthorRepositoryImpl) GetAll(req PageRequest) (*Page[Author], error) {
+	return paginate(a.db.Model(&Author{}).Where("name LIKE ?", req.Filter+"%"), req, authorsByName)
 }
 
 func (a *AuthorRepositoryImpl) GetByID(id uint) (*Author, error) {
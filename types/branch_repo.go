@@ -0,0 +1,94 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * every copy and hold from before there were several branches belongs to this one
+const MainBranchID uint = 1
+
+// * one building of the library, copies are owned by and shelved at a branch and holds are picked up at one
+type Branch struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Name    string `gorm:"unique" json:"name" binding:"required"`
+	Address string `json:"address"`
+}
+
+type AssignBranchRequest struct {
+	BranchID *uint `json:"branchID"` // * empty lets staff work at every branch
+}
+
+type BranchRepository interface {
+	Create(branch *Branch) error
+	GetAll() ([]Branch, error)
+	GetByID(id uint) (*Branch, error)
+	GetByName(name string) (*Branch, error)
+	IsInUse(id uint) (bool, error)
+	Update(branch *Branch) error
+	Delete(id uint) error
+}
+
+type BranchRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewBranchRepository(db *gorm.DB) BranchRepository {
+	return &BranchRepositoryImpl{db}
+}
+
+func (b *BranchRepositoryImpl) Create(branch *Branch) error {
+	return b.db.Create(branch).Error
+}
+
+func (b *BranchRepositoryImpl) GetAll() ([]Branch, error) {
+	var branches []Branch
+	if err := b.db.Order("name").Find(&branches).Error; err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+func (b *BranchRepositoryImpl) GetByID(id uint) (*Branch, error) {
+	var branch Branch
+	if err := b.db.First(&branch, id).Error; err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+func (b *BranchRepositoryImpl) GetByName(name string) (*Branch, error) {
+	var branch Branch
+	if err := b.db.Where("name = ?", name).First(&branch).Error; err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+// * a branch with copies, holds to pick up or staff can't be removed
+func (b *BranchRepositoryImpl) IsInUse(id uint) (bool, error) {
+	var count int64
+
+	if err := b.db.Model(&Copy{}).Where("home_branch_id = ? OR branch_id = ?", id, id).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	if err := b.db.Model(&Hold{}).Where("pickup_branch_id = ?", id).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err := b.db.Model(&User{}).Where("branch_id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+func (b *BranchRepositoryImpl) Update(branch *Branch) error {
+	return b.db.Save(branch).Error
+}
+
+func (b *BranchRepositoryImpl) Delete(id uint) error {
+	return b.db.Delete(&Branch{}, id).Error
+}
@@ -0,0 +1,176 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * the regular hours of one day of the week, a day without them is closed
+type OpeningHours struct {
+	Weekday time.Weekday `gorm:"primaryKey;autoIncrement:false" json:"weekday"` // * 0 is Sunday
+	Opens   string       `json:"opens" binding:"required"`                      // * "09:00" in the time zone of the library
+	Closes  string       `json:"closes" binding:"required"`
+}
+
+func (h *OpeningHours) Validate() error {
+	if h.Weekday < time.Sunday || h.Weekday > time.Saturday {
+		return fmt.Errorf("invalid weekday %d", h.Weekday)
+	}
+
+	opens, err := time.Parse("15:04", h.Opens)
+	if err != nil {
+		return fmt.Errorf("invalid opening time %q", h.Opens)
+	}
+
+	closes, err := time.Parse("15:04", h.Closes)
+	if err != nil {
+		return fmt.Errorf("invalid closing time %q", h.Closes)
+	}
+
+	if !closes.After(opens) {
+		return fmt.Errorf("the library must open before it closes on %s", h.Weekday)
+	}
+	return nil
+}
+
+type ClosureKind string
+
+const (
+	HolidayClosure   ClosureKind = "holiday"
+	TemporaryClosure ClosureKind = "closure" // * renovations, staff training and the like
+)
+
+// * days the library is closed although the regular hours say otherwise, dates are kept at midnight UTC
+type Closure struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	UID       string      `gorm:"uniqueIndex" json:"uid"` // * the same event imported again updates the closure instead of adding one
+	Kind      ClosureKind `json:"kind"`
+	Name      string      `json:"name" binding:"required"`
+	StartDate time.Time   `gorm:"type:date;index" json:"startDate" binding:"required"`
+	EndDate   time.Time   `gorm:"type:date;index" json:"endDate"` // * the last closed day
+	Yearly    bool        `json:"yearly"`                         // * repeats on the same dates every year, like most public holidays
+}
+
+func (c *Closure) Validate() error {
+	if c.Kind == "" {
+		c.Kind = HolidayClosure
+	}
+
+	if c.Kind != HolidayClosure && c.Kind != TemporaryClosure {
+		return fmt.Errorf("invalid closure kind %q", c.Kind)
+	}
+
+	c.StartDate = dateOnly(c.StartDate)
+	if c.EndDate.IsZero() {
+		c.EndDate = c.StartDate
+	}
+	c.EndDate = dateOnly(c.EndDate)
+
+	if c.EndDate.Before(c.StartDate) {
+		return fmt.Errorf("closure can't end before it starts")
+	}
+
+	if c.Yearly && c.EndDate.Sub(c.StartDate) >= 365*24*time.Hour {
+		return fmt.Errorf("yearly closures must be shorter than a year")
+	}
+	return nil
+}
+
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// * the query string of the closure list, dates are RFC3339
+type ClosureFilter struct {
+	From *time.Time `form:"from"`
+	To   *time.Time `form:"to"`
+}
+
+type CalendarRepository interface {
+	GetHours() ([]OpeningHours, error)
+	ReplaceHours(hours []OpeningHours) error
+	CreateClosure(closure *Closure) error
+	GetClosures(filter ClosureFilter) ([]Closure, error)
+	GetClosureByID(id uint) (*Closure, error)
+	GetClosureByUID(uid string) (*Closure, error)
+	UpdateClosure(closure *Closure) error
+	DeleteClosure(id uint) error
+}
+
+type CalendarRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCalendarRepository(db *gorm.DB) CalendarRepository {
+	return &CalendarRepositoryImpl{db}
+}
+
+func (c *CalendarRepositoryImpl) GetHours() ([]OpeningHours, error) {
+	var hours []OpeningHours
+	if err := c.db.Order("weekday").Find(&hours).Error; err != nil {
+		return nil, err
+	}
+	return hours, nil
+}
+
+// * the week is always set as a whole, run it in a transaction so nobody sees it half way
+func (c *CalendarRepositoryImpl) ReplaceHours(hours []OpeningHours) error {
+	if err := c.db.Where("1 = 1").Delete(&OpeningHours{}).Error; err != nil {
+		return err
+	}
+
+	if len(hours) == 0 {
+		return nil
+	}
+	return c.db.Create(&hours).Error
+}
+
+func (c *CalendarRepositoryImpl) CreateClosure(closure *Closure) error {
+	return c.db.Create(closure).Error
+}
+
+// * closures overlapping the dates of the filter, yearly ones always overlap
+func (c *CalendarRepositoryImpl) GetClosures(filter ClosureFilter) ([]Closure, error) {
+	query := c.db.Model(&Closure{})
+	if filter.From != nil {
+		query = query.Where("yearly OR end_date >= ?", dateOnly(*filter.From))
+	}
+	if filter.To != nil {
+		query = query.Where("yearly OR start_date <= ?", dateOnly(*filter.To))
+	}
+
+	var closures []Closure
+	if err := query.Order("start_date").Order("id").Find(&closures).Error; err != nil {
+		return nil, err
+	}
+	return closures, nil
+}
+
+func (c *CalendarRepositoryImpl) GetClosureByID(id uint) (*Closure, error) {
+	var closure Closure
+	if err := c.db.Where("id = ?", id).First(&closure).Error; err != nil {
+		return nil, err
+	}
+	return &closure, nil
+}
+
+func (c *CalendarRepositoryImpl) GetClosureByUID(uid string) (*Closure, error) {
+	var closure Closure
+	if err := c.db.Where("uid = ?", uid).First(&closure).Error; err != nil {
+		return nil, err
+	}
+	return &closure, nil
+}
+
+func (c *CalendarRepositoryImpl) UpdateClosure(closure *Closure) error {
+	return c.db.Save(closure).Error
+}
+
+func (c *CalendarRepositoryImpl) DeleteClosure(id uint) error {
+	return c.db.Where("id = ?", id).Delete(&Closure{}).Error
+}
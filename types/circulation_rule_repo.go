@@ -0,0 +1,138 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const DefaultPatronCategory = "adult"
+
+var ErrRuleCellTaken = errors.New("there is already a rule for this category and kind")
+
+// * one cell of the circulation policy matrix, an empty category matches every patron and a missing kind every item,
+// * fields left empty fall through to the less specific rules and finally to the defaults of the library
+type CirculationRule struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	PatronCategory string `gorm:"index" json:"patronCategory"`
+	KindID         *uint  `gorm:"index" json:"kindID"`
+	Kind           *Kind  `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	LoanDays          *uint `json:"loanDays,omitempty"`          // * a new loan and every renewal run this long
+	RenewalWindowDays *uint `json:"renewalWindowDays,omitempty"` // * how many days before the expire date a loan can be renewed
+	MaxRenewals       *uint `json:"maxRenewals,omitempty"`
+	PickupDays        *uint `json:"pickupDays,omitempty"` // * how long an available hold waits on the hold shelf
+
+	// * the limits of a patron can't depend on the kind of a single item, rules with a kind leave them empty
+	MaxHolds       *uint  `json:"maxHolds,omitempty"`
+	MaxLoans       *uint  `json:"maxLoans,omitempty"`
+	MaxFineBalance *int64 `json:"maxFineBalance,omitempty"` // * in cents
+}
+
+// * every cell holds one rule, a missing kind is a cell of its own as NULLs never collide in a unique index
+func MigrateRuleCells(db *gorm.DB) error {
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_circulation_rule_cell ON circulation_rules (patron_category, COALESCE(kind_id, 0))").Error
+}
+
+func (r *CirculationRule) Validate() error {
+	r.PatronCategory = strings.ToLower(strings.TrimSpace(r.PatronCategory))
+
+	if r.KindID != nil && (r.MaxHolds != nil || r.MaxLoans != nil || r.MaxFineBalance != nil) {
+		return fmt.Errorf("rules for a kind can't set the limits of a patron")
+	}
+
+	if r.LoanDays != nil && *r.LoanDays == 0 {
+		return fmt.Errorf("loans must run for at least one day")
+	}
+
+	if r.LoanDays != nil && r.RenewalWindowDays != nil && *r.RenewalWindowDays > *r.LoanDays {
+		return fmt.Errorf("renewal window can't be longer than the loan")
+	}
+
+	if r.PickupDays != nil && *r.PickupDays == 0 {
+		return fmt.Errorf("holds must wait on the hold shelf for at least one day")
+	}
+
+	if r.MaxFineBalance != nil && *r.MaxFineBalance < 0 {
+		return fmt.Errorf("fine balance can't be negative")
+	}
+	return nil
+}
+
+type CirculationRuleRepository interface {
+	Create(rule *CirculationRule) error
+	GetAll() ([]CirculationRule, error)
+	GetByID(id uint) (*CirculationRule, error)
+	GetByCell(category string, kindID *uint) (*CirculationRule, error)
+	Update(rule *CirculationRule) error
+	Delete(id uint) error
+}
+
+type CirculationRuleRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCirculationRuleRepository(db *gorm.DB) CirculationRuleRepository {
+	return &CirculationRuleRepositoryImpl{db}
+}
+
+func (r *CirculationRuleRepositoryImpl) Create(rule *CirculationRule) error {
+	return r.cellTaken(r.db.Create(rule).Error)
+}
+
+// * the matrix stays small, it's always read as a whole
+func (r *CirculationRuleRepositoryImpl) GetAll() ([]CirculationRule, error) {
+	var rules []CirculationRule
+	if err := r.db.Order("patron_category").Order("kind_id NULLS FIRST").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (r *CirculationRuleRepositoryImpl) GetByID(id uint) (*CirculationRule, error) {
+	var rule CirculationRule
+	if err := r.db.Where("id = ?", id).First(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// * there is at most one rule for every pair of category and kind
+func (r *CirculationRuleRepositoryImpl) GetByCell(category string, kindID *uint) (*CirculationRule, error) {
+	query := r.db.Where("patron_category = ?", category)
+	if kindID == nil {
+		query = query.Where("kind_id IS NULL")
+	} else {
+		query = query.Where("kind_id = ?", *kindID)
+	}
+
+	var rule CirculationRule
+	if err := query.First(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *CirculationRuleRepositoryImpl) Update(rule *CirculationRule) error {
+	return r.cellTaken(r.db.Save(rule).Error)
+}
+
+// * the unique index on the cell catches a rule created in parallel after the check of the handler
+func (r *CirculationRuleRepositoryImpl) cellTaken(err error) error {
+	if translator, ok := r.db.Dialector.(gorm.ErrorTranslator); ok && err != nil {
+		if errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey) {
+			return ErrRuleCellTaken
+		}
+	}
+	return err
+}
+
+func (r *CirculationRuleRepositoryImpl) Delete(id uint) error {
+	return r.db.Where("id = ?", id).Delete(&CirculationRule{}).Error
+}
@@ -0,0 +1,109 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CopyStatus string
+
+const (
+	CopyAvailable   CopyStatus = "available"
+	CopyOnLoan      CopyStatus = "on_loan"
+	CopyOnHoldShelf CopyStatus = "on_hold_shelf"
+	CopyInTransit   CopyStatus = "in_transit" // * on its way to another branch, to a hold or back to the branch owning it
+	CopyLost        CopyStatus = "lost"
+	CopyWithdrawn   CopyStatus = "withdrawn"
+)
+
+// * a physical copy of an item
+type Copy struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	ItemID        uint       `gorm:"index" json:"itemID" binding:"required"`
+	Barcode       string     `gorm:"unique" json:"barcode" binding:"required"`
+	Condition     string     `json:"condition"` // * e.g. new, good, worn, damaged
+	ShelfLocation string     `json:"shelfLocation"`
+	Status        CopyStatus `gorm:"index" json:"status"`
+
+	HomeBranchID uint    `gorm:"index;default:1" json:"homeBranchID"` // * the branch owning the copy
+	HomeBranch   *Branch `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+	BranchID     uint    `gorm:"index;default:1" json:"branchID"` // * where the copy is shelved, or where it's headed while in transit
+	Branch       *Branch `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+}
+
+type CopyRepository interface {
+	Create(cp *Copy) error
+	GetByID(id uint) (*Copy, error)
+	GetByBarcode(barcode string) (*Copy, error)
+	GetByItemID(itemID uint) ([]Copy, error)
+	GetFirstAvailable(itemID uint, branchID *uint) (*Copy, error)
+	Update(cp *Copy) error
+	Delete(id uint) error
+}
+
+type CopyRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCopyRepository(db *gorm.DB) CopyRepository {
+	return &CopyRepositoryImpl{db}
+}
+
+// * lost and withdrawn copies don't count towards the copies patrons can get
+func (cp *Copy) IsCirculating() bool {
+	return cp.Status != CopyLost && cp.Status != CopyWithdrawn
+}
+
+func (c *CopyRepositoryImpl) Create(cp *Copy) error {
+	return c.db.Create(cp).Error
+}
+
+func (c *CopyRepositoryImpl) GetByID(id uint) (*Copy, error) {
+	var cp Copy
+	if err := c.db.First(&cp, id).Error; err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (c *CopyRepositoryImpl) GetByBarcode(barcode string) (*Copy, error) {
+	var cp Copy
+	if err := c.db.Where("barcode = ?", barcode).First(&cp).Error; err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (c *CopyRepositoryImpl) GetByItemID(itemID uint) ([]Copy, error) {
+	var copies []Copy
+	if err := c.db.Where("item_id = ?", itemID).Order("id").Find(&copies).Error; err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+// * any branch when none is given
+func (c *CopyRepositoryImpl) GetFirstAvailable(itemID uint, branchID *uint) (*Copy, error) {
+	query := c.db.Where("item_id = ? AND status = ?", itemID, CopyAvailable)
+	if branchID != nil {
+		query = query.Where("branch_id = ?", *branchID)
+	}
+
+	var cp Copy
+	if err := query.Order("id").First(&cp).Error; err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (c *CopyRepositoryImpl) Update(cp *Copy) error {
+	return c.db.Save(cp).Error
+}
+
+func (c *CopyRepositoryImpl) Delete(id uint) error {
+	return c.db.Delete(&Copy{}, id).Error
+}
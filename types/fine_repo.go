@@ -0,0 +1,90 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type FineType string
+
+const (
+	Charge  FineType = "charge"
+	Payment FineType = "payment"
+	Waiver  FineType = "waiver"
+)
+
+type Fine struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	UserID     string   `gorm:"index" json:"userID"`
+	LoanID     *uint    `gorm:"index" json:"loanID"` // * set for charges of an overdue loan
+	Type       FineType `json:"type"`
+	Amount     uint     `json:"amount"` // * in cents
+	Note       string   `json:"note"`
+	RecordedBy string   `json:"recordedBy"` // * moderator who recorded the payment or waiver
+}
+
+type FineRequest struct {
+	Amount uint   `json:"amount"`
+	Note   string `json:"note"`
+}
+
+type FineBalance struct {
+	UserID  string `json:"userID"`
+	Balance int64  `json:"balance"` // * unpaid amount in cents
+	Fines   []Fine `json:"fines"`
+}
+
+type FineRepository interface {
+	Create(fine *Fine) error
+	GetByUserID(userID string) ([]Fine, error)
+	GetChargeByLoanID(loanID uint) (*Fine, error)
+	GetBalance(userID string) (int64, error)
+	Update(fine *Fine) error
+}
+
+type FineRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewFineRepository(db *gorm.DB) FineRepository {
+	return &FineRepositoryImpl{db}
+}
+
+func (f *FineRepositoryImpl) Create(fine *Fine) error {
+	return f.db.Create(fine).Error
+}
+
+func (f *FineRepositoryImpl) GetByUserID(userID string) ([]Fine, error) {
+	var fines []Fine
+	if err := f.db.Where("user_id = ?", userID).Order("created_at").Find(&fines).Error; err != nil {
+		return nil, err
+	}
+	return fines, nil
+}
+
+func (f *FineRepositoryImpl) GetChargeByLoanID(loanID uint) (*Fine, error) {
+	var fine Fine
+	if err := f.db.Where("loan_id = ? AND type = ?", loanID, Charge).First(&fine).Error; err != nil {
+		return nil, err
+	}
+	return &fine, nil
+}
+
+// * charges minus payments and waivers
+func (f *FineRepositoryImpl) GetBalance(userID string) (int64, error) {
+	var balance int64
+	if err := f.db.Model(&Fine{}).
+		Select("COALESCE(SUM(CASE WHEN type = ? THEN amount ELSE -amount END), 0)", Charge).
+		Where("user_id = ?", userID).Scan(&balance).Error; err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+func (f *FineRepositoryImpl) Update(fine *Fine) error {
+	return f.db.Save(fine).Error
+}
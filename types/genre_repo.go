@@ -19,7 +19,7 @@ type Genre struct {
 
 type GenreRepository interface {
 	Create(genre *Genre) error
-	GetAll(order, filter string, limit uint) ([]Genre, error)
+	GetAll(req PageRequest) (*Page[Genre], error)
 	GetByID(id uint) (*Genre, error)
 	Update(genre *Genre) error
 	Delete(id uint) error
@@ -37,12 +37,15 @@ func (g *GenreRepositoryImpl) Create(genre *Genre) error {
 	return g.db.Create(genre).Error
 }
 
-func (g *GenreRepositoryImpl) GetAll(order, filter string, limit uint) ([]Genre, error) {
-	var genres []Genre
-	if err := g.db.Preload("Items").Order("name "+order).Where("name LIKE ?", filter+"%").Limit(int(limit)).Find(&genres).Error; err != nil {
-		return nil, err
-	}
-	return genres, nil
+var genresByName = keyset[Genre, string, uint]{
+	keyColumn: "name",
+	idColumn:  "id",
+	preloads:  []string{"Items"},
+	values:    func(genre *Genre) (string, uint) { return genre.Name, genre.ID },
+}
+
+func (g *GenreRepositoryImpl) GetAll(req PageRequest) (*Page[Genre], error) {
+	return paginate(g.db.Model(&Genre{}).Where("name LIKE ?", req.Filter+"%"), req, genresByName)
 }
 
 func (g *GenreRepositoryImpl) GetByID(id uint) (*Genre, error) {
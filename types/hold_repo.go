@@ -14,18 +14,31 @@ type Hold struct {
 
 	PlacedDate time.Time `json:"placedDate"`
 
+	PickupBranchID uint    `gorm:"index;default:1" json:"pickupBranchID"` // * the home branch of the patron when not chosen
+	PickupBranch   *Branch `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+
 	IsAvailable          bool      `json:"isAvailable"`
+	CopyID               *uint     `json:"copyID"` // * copy waiting on the hold shelf, or on its way to the pickup branch
 	ExpiryDate           time.Time `json:"expiryDate"`
 	InLinePosition       uint      `json:"inLinePosition"`       // * place in line
 	EstimatedWeeksToWait uint      `json:"estimatedWeeksToWait"` // * approximate waiting days
 	DeliveryDate         time.Time `json:"deliveryDate"`         // * deliver the hold after the date
+	IsPostponed          bool      `json:"isPostponed"`          // * waits at the end of the line until the delivery date
+}
+
+type ChangeDeliveryDateRequest struct {
+	DeliveryDate time.Time `json:"deliveryDate" binding:"required"`
 }
 
 type HoldRepository interface {
 	Create(hold *Hold) error
 	GetByUserID(userID string) ([]Hold, error)
 	GetByItemID(itemID uint) ([]Hold, error)
+	ListByUserID(userID string, req PageRequest) (*Page[Hold], error)
+	ListByItemID(itemID uint, req PageRequest) (*Page[Hold], error)
 	GetByID(id uint) (*Hold, error)
+	GetExpired(now time.Time) ([]Hold, error)
+	GetPostponedDue(now time.Time) ([]Hold, error)
 	Update(hold *Hold) error
 	Delete(id uint) error
 }
@@ -44,7 +57,7 @@ func (h *HoldRepositoryImpl) Create(hold *Hold) error {
 
 func (h *HoldRepositoryImpl) GetByUserID(userID string) ([]Hold, error) {
 	var holds []Hold
-	if err := h.db.Find(&holds).Where("userID = ?", userID).Error; err != nil {
+	if err := h.db.Where("user_id = ?", userID).Find(&holds).Error; err != nil {
 		return nil, err
 	}
 
@@ -58,7 +71,7 @@ func (h *HoldRepositoryImpl) GetByUserID(userID string) ([]Hold, error) {
 
 func (h *HoldRepositoryImpl) GetByItemID(itemID uint) ([]Hold, error) {
 	var holds []Hold
-	if err := h.db.Find(&holds).Where("itemID = ?", itemID).Error; err != nil {
+	if err := h.db.Where("item_id = ?", itemID).Find(&holds).Error; err != nil {
 		return nil, err
 	}
 
@@ -70,6 +83,20 @@ func (h *HoldRepositoryImpl) GetByItemID(itemID uint) ([]Hold, error) {
 	return holds, nil
 }
 
+var holdsByPlacedDate = keyset[Hold, time.Time, uint]{
+	keyColumn: "placed_date",
+	idColumn:  "id",
+	values:    func(hold *Hold) (time.Time, uint) { return hold.PlacedDate, hold.ID },
+}
+
+func (h *HoldRepositoryImpl) ListByUserID(userID string, req PageRequest) (*Page[Hold], error) {
+	return paginate(h.db.Model(&Hold{}).Where("user_id = ?", userID), req, holdsByPlacedDate)
+}
+
+func (h *HoldRepositoryImpl) ListByItemID(itemID uint, req PageRequest) (*Page[Hold], error) {
+	return paginate(h.db.Model(&Hold{}).Where("item_id = ?", itemID), req, holdsByPlacedDate)
+}
+
 func (h *HoldRepositoryImpl) GetByID(id uint) (*Hold, error) {
 	var hold Hold
 	if err := h.db.First(&hold, id).Error; err != nil {
@@ -78,6 +105,22 @@ func (h *HoldRepositoryImpl) GetByID(id uint) (*Hold, error) {
 	return &hold, nil
 }
 
+func (h *HoldRepositoryImpl) GetExpired(now time.Time) ([]Hold, error) {
+	var holds []Hold
+	if err := h.db.Where("is_available = ? AND expiry_date < ?", true, now).Find(&holds).Error; err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (h *HoldRepositoryImpl) GetPostponedDue(now time.Time) ([]Hold, error) {
+	var holds []Hold
+	if err := h.db.Where("is_postponed = ? AND delivery_date <= ?", true, now).Find(&holds).Error; err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
 func (h *HoldRepositoryImpl) Update(hold *Hold) error {
 	return h.db.Save(hold).Error
 }
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * links a user to an account at an external identity provider
+type Identity struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	UserID  string `gorm:"index" json:"userID"`
+	Issuer  string `gorm:"uniqueIndex:idx_identity_subject" json:"issuer"`
+	Subject string `gorm:"uniqueIndex:idx_identity_subject" json:"subject"`
+}
+
+type IdentityRepository interface {
+	Create(identity *Identity) error
+	GetBySubject(issuer, subject string) (*Identity, error)
+}
+
+type IdentityRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &IdentityRepositoryImpl{db}
+}
+
+func (i *IdentityRepositoryImpl) Create(identity *Identity) error {
+	return i.db.Create(identity).Error
+}
+
+func (i *IdentityRepositoryImpl) GetBySubject(issuer, subject string) (*Identity, error) {
+	var identity Identity
+	if err := i.db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
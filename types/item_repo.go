@@ -3,13 +3,18 @@ package types
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Order string
 
+// * an item with loans, copies out or holds can't be removed, patrons would be left with nothing to return or pick up
+var ErrItemInCirculation = errors.New("item still has loans, copies on loan or holds")
+
 const (
 	ASC  Order = "ASC"
 	DESC Order = "DESC"
@@ -20,18 +25,72 @@ type Item struct {
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 
-	Title       string   `json:"title" binding:"required"`
-	Description string   `json:"description"`
-	Authors     []Author `gorm:"many2many:item_authors" json:"authors"`
-	Genres      []Genre  `gorm:"many2many:item_genres" json:"genres"`
-	Kinds       []Kind   `gorm:"many2many:item_kinds" json:"kinds"`
-	Quantity    uint     `json:"quantity"`
+	Title           string   `json:"title" binding:"required"`
+	Description     string   `json:"description"`
+	PublicationYear uint     `gorm:"index" json:"publicationYear"` // * 0 when unknown
+	Authors         []Author `gorm:"many2many:item_authors" json:"authors"`
+	Genres          []Genre  `gorm:"many2many:item_genres" json:"genres"`
+	Kinds           []Kind   `gorm:"many2many:item_kinds" json:"kinds"`
+	Copies          []Copy   `json:"copies"`
+}
+
+// * values of the same dimension are combined with OR, dimensions with AND
+type CatalogFilter struct {
+	GenreIDs  []uint `form:"genre"`
+	KindIDs   []uint `form:"kind"`
+	AuthorIDs []uint `form:"author"`
+	Available *bool  `form:"available"` // * whether any copy is on the shelf
+	YearFrom  uint   `form:"yearFrom"`
+	YearTo    uint   `form:"yearTo"`
+}
+
+type Facet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+type YearFacet struct {
+	Year  uint  `json:"year"`
+	Count int64 `json:"count"`
+}
+
+type AvailabilityFacet struct {
+	Available   int64 `json:"available"`
+	Unavailable int64 `json:"unavailable"`
+}
+
+// * each dimension is counted with every filter applied except its own
+type CatalogFacets struct {
+	Genres       []Facet           `json:"genres"`
+	Kinds        []Facet           `json:"kinds"`
+	Authors      []Facet           `json:"authors"`
+	Years        []YearFacet       `json:"years"`
+	Availability AvailabilityFacet `json:"availability"`
+}
+
+// * the standard page of items with the facets of the whole result next to it
+type CatalogPage struct {
+	Page[Item]
+	Facets CatalogFacets `json:"facets"`
+}
+
+// * Title and Snippet mark the matched words with <mark></mark>
+type SearchResult struct {
+	Item    Item    `json:"item"`
+	Rank    float64 `json:"rank"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
 }
 
 type ItemRepository interface {
 	Create(i *Item) error
-	GetAll(order, filter string, limit uint) ([]Item, error)
+	GetAll(req PageRequest) (*Page[Item], error)
+	Search(query string, limit uint) ([]SearchResult, error)
+	Browse(filter CatalogFilter, req PageRequest) (*CatalogPage, error)
 	GetByID(id uint) (*Item, error)
+	Lock(id uint) error
+	IsInCirculation(id uint) (bool, error)
 	GetItemsByAuthor(authorID uint) ([]Item, error)
 	GetItemsByGenre(genreID uint) ([]Item, error)
 	GetItemsByKind(kindID uint) ([]Item, error)
@@ -42,12 +101,6 @@ type ItemRepository interface {
 	DisassociateAuthor(i *Item, author *Author) error
 }
 
-type FilteredRequestBody struct {
-	Order  Order  `json:"order"`
-	Filter string `json:"filter"`
-	Limit  uint   `json:"limit"`
-}
-
 type ItemRepositoryImpl struct {
 	db *gorm.DB
 }
@@ -60,8 +113,8 @@ func (i *ItemRepositoryImpl) Create(item *Item) error {
 	// Begin a transaction
 	tx := i.db.Begin()
 
-	// Create the item
-	if err := tx.Create(item).Error; err != nil {
+	// Create the item, copies are added through the copy repository
+	if err := tx.Omit("Copies").Create(item).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -92,17 +145,279 @@ func (i *ItemRepositoryImpl) Create(item *Item) error {
 	return tx.Commit().Error
 }
 
-func (i *ItemRepositoryImpl) GetAll(order, filter string, limit uint) ([]Item, error) {
+var itemsByTitle = keyset[Item, string, uint]{
+	keyColumn: "title",
+	idColumn:  "id",
+	preloads:  []string{"Authors", "Genres", "Kinds"},
+	values:    func(item *Item) (string, uint) { return item.Title, item.ID },
+}
+
+func (i *ItemRepositoryImpl) GetAll(req PageRequest) (*Page[Item], error) {
+	return paginate(i.db.Model(&Item{}).Where("title LIKE ?", req.Filter+"%"), req, itemsByTitle)
+}
+
+// * title weighs the most, then authors, genres and finally the description
+const searchDocumentSQL = `
+setweight(to_tsvector('english', coalesce(items.title, '')), 'A') ||
+setweight(to_tsvector('english', coalesce((SELECT string_agg(authors.name, ' ') FROM item_authors JOIN authors ON authors.id = item_authors.author_id WHERE item_authors.item_id = items.id), '')), 'B') ||
+setweight(to_tsvector('english', coalesce((SELECT string_agg(genres.name, ' ') FROM item_genres JOIN genres ON genres.id = item_genres.genre_id WHERE item_genres.item_id = items.id), '')), 'C') ||
+setweight(to_tsvector('english', coalesce(items.description, '')), 'D')`
+
+// * the document of an item is rebuilt by the database whenever its title, description, authors or genres change
+var searchDocumentMigrations = []string{
+	"ALTER TABLE items ADD COLUMN IF NOT EXISTS search_document tsvector",
+	"CREATE INDEX IF NOT EXISTS idx_items_search_document ON items USING gin (search_document)",
+	`CREATE OR REPLACE FUNCTION refresh_search_document(item bigint) RETURNS void AS $$
+		UPDATE items SET search_document = ` + searchDocumentSQL + ` WHERE items.id = item
+	$$ LANGUAGE sql`,
+	`CREATE OR REPLACE FUNCTION items_search_document() RETURNS trigger AS $$
+		BEGIN
+			PERFORM refresh_search_document(NEW.id);
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION item_links_search_document() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				PERFORM refresh_search_document(OLD.item_id);
+			ELSE
+				PERFORM refresh_search_document(NEW.item_id);
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION names_search_document() RETURNS trigger AS $$
+		BEGIN
+			IF TG_TABLE_NAME = 'authors' THEN
+				PERFORM refresh_search_document(item_id) FROM item_authors WHERE author_id = NEW.id;
+			ELSE
+				PERFORM refresh_search_document(item_id) FROM item_genres WHERE genre_id = NEW.id;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+	"DROP TRIGGER IF EXISTS items_search_document ON items",
+	`CREATE TRIGGER items_search_document AFTER INSERT OR UPDATE OF title, description ON items
+		FOR EACH ROW EXECUTE FUNCTION items_search_document()`,
+	"DROP TRIGGER IF EXISTS item_authors_search_document ON item_authors",
+	`CREATE TRIGGER item_authors_search_document AFTER INSERT OR DELETE ON item_authors
+		FOR EACH ROW EXECUTE FUNCTION item_links_search_document()`,
+	"DROP TRIGGER IF EXISTS item_genres_search_document ON item_genres",
+	`CREATE TRIGGER item_genres_search_document AFTER INSERT OR DELETE ON item_genres
+		FOR EACH ROW EXECUTE FUNCTION item_links_search_document()`,
+	"DROP TRIGGER IF EXISTS authors_search_document ON authors",
+	`CREATE TRIGGER authors_search_document AFTER UPDATE OF name ON authors
+		FOR EACH ROW EXECUTE FUNCTION names_search_document()`,
+	"DROP TRIGGER IF EXISTS genres_search_document ON genres",
+	`CREATE TRIGGER genres_search_document AFTER UPDATE OF name ON genres
+		FOR EACH ROW EXECUTE FUNCTION names_search_document()`,
+	// * items from before the column existed
+	"SELECT refresh_search_document(id) FROM items WHERE search_document IS NULL",
+}
+
+// * the search document isn't part of Item, saving an item must never overwrite what the database keeps up to date
+func MigrateSearchDocuments(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range searchDocumentMigrations {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const searchSQL = `
+SELECT items.id,
+	ts_rank(items.search_document, query) AS rank,
+	ts_headline('english', items.title, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS title,
+	ts_headline('english', coalesce(items.description, ''), query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10') AS snippet
+FROM items,
+	websearch_to_tsquery('english', ?) AS query
+WHERE items.search_document @@ query
+ORDER BY rank DESC, items.id
+LIMIT ?`
+
+// * supports "quoted phrases", OR, AND, NOT and -word
+func (i *ItemRepositoryImpl) Search(query string, limit uint) ([]SearchResult, error) {
+	var rows []struct {
+		ID      uint
+		Rank    float64
+		Title   string
+		Snippet string
+	}
+	if err := i.db.Raw(searchSQL, websearchQuery(query), limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	ids := make([]uint, len(rows))
+	for in, row := range rows {
+		ids[in] = row.ID
+	}
+
 	var items []Item
-	if err := i.db.Preload("Authors").Preload("Genres").Preload("Kinds").Order("title "+order).Where("title LIKE ?", filter+"%").Limit(int(limit)).Find(&items).Error; err != nil {
+	if err := i.db.Preload("Authors").Preload("Genres").Preload("Kinds").Where("id IN ?", ids).Find(&items).Error; err != nil {
 		return nil, err
 	}
-	return items, nil
+
+	byID := make(map[uint]Item, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+
+	results := make([]SearchResult, 0, len(rows))
+	for _, row := range rows {
+		item, ok := byID[row.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{Item: item, Rank: row.Rank, Title: row.Title, Snippet: row.Snippet})
+	}
+	return results, nil
+}
+
+// * websearch_to_tsquery knows "or" and "-word" but treats "and" and "not" as stop words
+func websearchQuery(query string) string {
+	var (
+		terms   []string
+		negate  bool
+		inQuote bool
+	)
+
+	for _, field := range strings.Fields(query) {
+		if inQuote {
+			terms = append(terms, field)
+			inQuote = strings.Count(field, `"`)%2 == 0
+			continue
+		}
+
+		switch field {
+		case "AND":
+			continue
+		case "NOT":
+			negate = true
+			continue
+		}
+
+		if negate {
+			field = "-" + field
+			negate = false
+		}
+		terms = append(terms, field)
+		inQuote = strings.Count(field, `"`)%2 == 1
+	}
+
+	return strings.Join(terms, " ")
+}
+
+type catalogDimension string
+
+const (
+	byGenre        catalogDimension = "genre"
+	byKind         catalogDimension = "kind"
+	byAuthor       catalogDimension = "author"
+	byAvailability catalogDimension = "availability"
+	byYear         catalogDimension = "year"
+)
+
+const availableCopyExistsSQL = "EXISTS (SELECT 1 FROM copies WHERE copies.item_id = items.id AND copies.status = 'available')"
+
+// * skip leaves out the filter of the dimension that is being counted
+func applyCatalogFilter(db *gorm.DB, filter CatalogFilter, skip catalogDimension) *gorm.DB {
+	if len(filter.GenreIDs) > 0 && skip != byGenre {
+		db = db.Where("items.id IN (SELECT item_id FROM item_genres WHERE genre_id IN ?)", filter.GenreIDs)
+	}
+
+	if len(filter.KindIDs) > 0 && skip != byKind {
+		db = db.Where("items.id IN (SELECT item_id FROM item_kinds WHERE kind_id IN ?)", filter.KindIDs)
+	}
+
+	if len(filter.AuthorIDs) > 0 && skip != byAuthor {
+		db = db.Where("items.id IN (SELECT item_id FROM item_authors WHERE author_id IN ?)", filter.AuthorIDs)
+	}
+
+	if filter.Available != nil && skip != byAvailability {
+		if *filter.Available {
+			db = db.Where(availableCopyExistsSQL)
+		} else {
+			db = db.Where("NOT " + availableCopyExistsSQL)
+		}
+	}
+
+	if skip != byYear {
+		if filter.YearFrom > 0 {
+			db = db.Where("items.publication_year >= ?", filter.YearFrom)
+		}
+		if filter.YearTo > 0 {
+			db = db.Where("items.publication_year <= ?", filter.YearTo)
+		}
+	}
+
+	return db
+}
+
+// * the filter of the page request narrows the titles like it does for every other list of items
+func (i *ItemRepositoryImpl) Browse(filter CatalogFilter, req PageRequest) (*CatalogPage, error) {
+	items, err := paginate(applyCatalogFilter(i.db.Model(&Item{}).Where("items.title LIKE ?", req.Filter+"%"), filter, ""), req, itemsByTitle)
+	if err != nil {
+		return nil, err
+	}
+
+	page := CatalogPage{Page: *items, Facets: CatalogFacets{Years: []YearFacet{}}}
+	if page.Facets.Genres, err = i.countFacet(filter, byGenre, "item_genres", "genre_id", "genres"); err != nil {
+		return nil, err
+	}
+
+	if page.Facets.Kinds, err = i.countFacet(filter, byKind, "item_kinds", "kind_id", "kinds"); err != nil {
+		return nil, err
+	}
+
+	if page.Facets.Authors, err = i.countFacet(filter, byAuthor, "item_authors", "author_id", "authors"); err != nil {
+		return nil, err
+	}
+
+	if err := applyCatalogFilter(i.db.Table("items"), filter, byYear).
+		Select("items.publication_year AS year, COUNT(*) AS count").
+		Where("items.publication_year > 0").
+		Group("items.publication_year").Order("items.publication_year DESC").
+		Scan(&page.Facets.Years).Error; err != nil {
+		return nil, err
+	}
+
+	if err := applyCatalogFilter(i.db.Table("items"), filter, byAvailability).
+		Select("COUNT(*) FILTER (WHERE " + availableCopyExistsSQL + ") AS available, COUNT(*) FILTER (WHERE NOT " + availableCopyExistsSQL + ") AS unavailable").
+		Scan(&page.Facets.Availability).Error; err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// * join table and column names come from Browse, never from the request
+func (i *ItemRepositoryImpl) countFacet(filter CatalogFilter, dimension catalogDimension, joinTable, joinColumn, table string) ([]Facet, error) {
+	facets := []Facet{}
+
+	query := i.db.Table("items").
+		Select(fmt.Sprintf("%s.id, %s.name, COUNT(DISTINCT items.id) AS count", table, table)).
+		Joins(fmt.Sprintf("JOIN %s ON %s.item_id = items.id", joinTable, joinTable)).
+		Joins(fmt.Sprintf("JOIN %s ON %s.id = %s.%s", table, table, joinTable, joinColumn))
+
+	if err := applyCatalogFilter(query, filter, dimension).
+		Group(fmt.Sprintf("%s.id, %s.name", table, table)).
+		Order(fmt.Sprintf("count DESC, %s.name", table)).
+		Scan(&facets).Error; err != nil {
+		return nil, err
+	}
+	return facets, nil
 }
 
 func (i *ItemRepositoryImpl) GetByID(id uint) (*Item, error) {
 	var item Item
-	if err := i.db.Preload("Authors").Preload("Genres").Preload("Kinds").First(&item, id).Error; err != nil {
+	if err := i.db.Preload("Authors").Preload("Genres").Preload("Kinds").Preload("Copies").First(&item, id).Error; err != nil {
 		return nil, err
 	}
 	return &item, nil
@@ -136,15 +451,46 @@ func (i *ItemRepositoryImpl) GetItemsByKind(kindID uint) ([]Item, error) {
 }
 
 func (i *ItemRepositoryImpl) Update(item *Item) error {
-	return i.db.Save(item).Error
+	return i.db.Omit("Copies").Save(item).Error
+}
+
+// * holds the row until the transaction ends, circulation of the item waits for it meanwhile
+func (i *ItemRepositoryImpl) Lock(id uint) error {
+	var item Item
+	return i.db.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", id).Take(&item).Error
+}
+
+func (i *ItemRepositoryImpl) IsInCirculation(id uint) (bool, error) {
+	var count int64
+
+	if err := i.db.Model(&Loan{}).Where("item_id = ?", id).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	if err := i.db.Model(&Copy{}).Where("item_id = ? AND status = ?", id, CopyOnLoan).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	err := i.db.Model(&Hold{}).Where("item_id = ?", id).Count(&count).Error
+	return count > 0, err
 }
 
+// * only copies nobody has or waits for go along with the item, ErrItemInCirculation otherwise
 func (i *ItemRepositoryImpl) Delete(id uint) error {
 	var item Item
 	if err := i.db.Preload("Authors").Preload("Genres").First(&item, id).Error; err != nil {
 		return err
 	}
 
+	inCirculation, err := i.IsInCirculation(id)
+	if err != nil {
+		return err
+	}
+
+	if inCirculation {
+		return ErrItemInCirculation
+	}
+
 	for in := range item.Authors {
 		i.db.Model(&item.Authors[in]).Association("Items").Delete(&item)
 	}
@@ -153,6 +499,10 @@ func (i *ItemRepositoryImpl) Delete(id uint) error {
 		i.db.Model(&item.Genres[in]).Association("Items").Delete(&item)
 	}
 
+	if err := i.db.Where("item_id = ?", id).Delete(&Copy{}).Error; err != nil {
+		return err
+	}
+
 	if err := i.db.Delete(&item, id).Error; err != nil {
 		return err
 	}
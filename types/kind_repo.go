@@ -11,13 +11,14 @@ type Kind struct {
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 
-	Name  string `json:"name" binding:"required"`
-	Items []Item `gorm:"many2many:item_kinds" json:"items"`
+	Name      string `json:"name" binding:"required"`
+	DailyFine uint   `json:"dailyFine"` // * fine in cents for every day a loan of this kind is overdue
+	Items     []Item `gorm:"many2many:item_kinds" json:"items"`
 }
 
 type KindRepository interface {
 	Create(kind *Kind) error
-	GetAll(order, filter string, limit uint) ([]Kind, error)
+	GetAll(req PageRequest) (*Page[Kind], error)
 	GetByID(id uint) (*Kind, error)
 	Update(kind *Kind) error
 	Delete(id uint) error
@@ -35,12 +36,15 @@ func (k *kindRepositoryImpl) Create(kind *Kind) error {
 	return k.db.Create(kind).Error
 }
 
-func (k *kindRepositoryImpl) GetAll(order, filter string, limit uint) ([]Kind, error) {
-	var kinds []Kind
-	if err := k.db.Preload("Items").Order("name "+order).Where("name LIKE ?", filter+"%").Limit(int(limit)).Find(&kinds).Error; err != nil {
-		return nil, err
-	}
-	return kinds, nil
+var kindsByName = keyset[Kind, string, uint]{
+	keyColumn: "name",
+	idColumn:  "id",
+	preloads:  []string{"Items"},
+	values:    func(kind *Kind) (string, uint) { return kind.Name, kind.ID },
+}
+
+func (k *kindRepositoryImpl) GetAll(req PageRequest) (*Page[Kind], error) {
+	return paginate(k.db.Model(&Kind{}).Where("name LIKE ?", req.Filter+"%"), req, kindsByName)
 }
 
 func (k *kindRepositoryImpl) GetByID(id uint) (*Kind, error) {
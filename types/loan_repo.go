@@ -10,17 +10,52 @@ type Loan struct {
 	ID     uint   `gorm:"primarykey" json:"id"`
 	ItemID uint   `json:"itemID" binding:"required"`
 	UserID string `json:"userID" binding:"required"`
+	CopyID uint   `json:"copyID"` // * picked automatically when not given
 
 	CheckoutDate time.Time `json:"checkoutDate"` // * date of loan creation
 	ExpireDate   time.Time `json:"expireDate"`   // * date of loan expiration
-	RenewableOn  time.Time `json:"renewableOn"`  // ? 3 days before expiration date send notification
+	RenewableOn  time.Time `json:"renewableOn"`  // * 3 days before expiration date a reminder is sent
+	Renewals     uint      `json:"renewals"`     // * how many times the loan was prolonged
+	MaxRenewals  uint      `json:"maxRenewals"`  // * how many times the loan can be prolonged
+}
+
+type CheckoutRequest struct {
+	Barcode     string `json:"barcode" binding:"required"`
+	LibraryCard string `json:"libraryCard" binding:"required"`
+}
+
+type CheckinRequest struct {
+	Barcode string `json:"barcode" binding:"required"`
+}
+
+type CheckinDestination string
+
+const (
+	ToHoldShelf CheckinDestination = "hold_shelf"
+	ToStacks    CheckinDestination = "stacks"
+	ToTransit   CheckinDestination = "transit"
+)
+
+// * tells the staff member where the returned copy has to go, a copy arriving from another branch has no loan
+type CheckinResponse struct {
+	Loan          *Loan              `json:"loan,omitempty"`
+	Copy          Copy               `json:"copy"`
+	Destination   CheckinDestination `json:"destination"`
+	HoldID        *uint              `json:"holdID,omitempty"`
+	BranchID      *uint              `json:"branchID,omitempty"` // * where the copy is sent when in transit
+	ShelfLocation string             `json:"shelfLocation,omitempty"`
 }
 
 type LoanRepository interface {
 	Create(loan *Loan) error
 	GetByUserID(itemID string) ([]Loan, error)
 	GetByItemID(itemID uint) ([]Loan, error)
+	ListByUserID(userID string, req PageRequest) (*Page[Loan], error)
+	ListByItemID(itemID uint, req PageRequest) (*Page[Loan], error)
 	GetByID(id uint) (*Loan, error)
+	GetByCopyID(copyID uint) (*Loan, error)
+	GetOverdue(now time.Time) ([]Loan, error)
+	GetDueSoon(now time.Time) ([]Loan, error)
 	Update(loan *Loan) error
 	Delete(id uint) error
 }
@@ -39,7 +74,7 @@ func (l *LoanRepositoryImpl) Create(loan *Loan) error {
 
 func (l *LoanRepositoryImpl) GetByUserID(userID string) ([]Loan, error) {
 	var loans []Loan
-	if err := l.db.Find(&loans).Where("userID = ?", userID).Error; err != nil {
+	if err := l.db.Where("user_id = ?", userID).Find(&loans).Error; err != nil {
 		return nil, err
 	}
 	return loans, nil
@@ -47,12 +82,26 @@ func (l *LoanRepositoryImpl) GetByUserID(userID string) ([]Loan, error) {
 
 func (l *LoanRepositoryImpl) GetByItemID(itemID uint) ([]Loan, error) {
 	var loans []Loan
-	if err := l.db.Find(&loans).Where("itemID = ?", itemID).Error; err != nil {
+	if err := l.db.Where("item_id = ?", itemID).Find(&loans).Error; err != nil {
 		return nil, err
 	}
 	return loans, nil
 }
 
+var loansByCheckoutDate = keyset[Loan, time.Time, uint]{
+	keyColumn: "checkout_date",
+	idColumn:  "id",
+	values:    func(loan *Loan) (time.Time, uint) { return loan.CheckoutDate, loan.ID },
+}
+
+func (l *LoanRepositoryImpl) ListByUserID(userID string, req PageRequest) (*Page[Loan], error) {
+	return paginate(l.db.Model(&Loan{}).Where("user_id = ?", userID), req, loansByCheckoutDate)
+}
+
+func (l *LoanRepositoryImpl) ListByItemID(itemID uint, req PageRequest) (*Page[Loan], error) {
+	return paginate(l.db.Model(&Loan{}).Where("item_id = ?", itemID), req, loansByCheckoutDate)
+}
+
 func (l *LoanRepositoryImpl) GetByID(id uint) (*Loan, error) {
 	var loan Loan
 	if err := l.db.First(&loan, id).Error; err != nil {
@@ -61,6 +110,31 @@ func (l *LoanRepositoryImpl) GetByID(id uint) (*Loan, error) {
 	return &loan, nil
 }
 
+func (l *LoanRepositoryImpl) GetByCopyID(copyID uint) (*Loan, error) {
+	var loan Loan
+	if err := l.db.Where("copy_id = ?", copyID).First(&loan).Error; err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+func (l *LoanRepositoryImpl) GetOverdue(now time.Time) ([]Loan, error) {
+	var loans []Loan
+	if err := l.db.Where("expire_date < ?", now).Order("expire_date").Find(&loans).Error; err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
+// * loans that can already be renewed but haven't expired yet
+func (l *LoanRepositoryImpl) GetDueSoon(now time.Time) ([]Loan, error) {
+	var loans []Loan
+	if err := l.db.Where("renewable_on <= ? AND expire_date >= ?", now, now).Order("expire_date").Find(&loans).Error; err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
 func (l *LoanRepositoryImpl) Update(loan *Loan) error {
 	return l.db.Save(loan).Error
 }
@@ -0,0 +1,79 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * failed logins counted per client address or per username, kept in the database so restarts don't reset them
+type LoginThrottle struct {
+	Key       string    `gorm:"primaryKey" json:"key"` // * "ip:<address>" or "user:<username>"
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Failures      uint       `json:"failures"`
+	LastFailureAt time.Time  `gorm:"index" json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil,omitempty"`
+}
+
+type LoginThrottleRepository interface {
+	Get(key string) (*LoginThrottle, error)
+	GetLocked(now time.Time) ([]LoginThrottle, error)
+	RecordFailure(key string, now time.Time, window time.Duration) (*LoginThrottle, error)
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+	DeleteStale(now time.Time, window time.Duration) error
+}
+
+type LoginThrottleRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewLoginThrottleRepository(db *gorm.DB) LoginThrottleRepository {
+	return &LoginThrottleRepositoryImpl{db}
+}
+
+func (l *LoginThrottleRepositoryImpl) Get(key string) (*LoginThrottle, error) {
+	var throttle LoginThrottle
+	if err := l.db.Where("key = ?", key).First(&throttle).Error; err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (l *LoginThrottleRepositoryImpl) GetLocked(now time.Time) ([]LoginThrottle, error) {
+	var throttles []LoginThrottle
+	if err := l.db.Where("locked_until > ?", now).Order("locked_until DESC").Find(&throttles).Error; err != nil {
+		return nil, err
+	}
+	return throttles, nil
+}
+
+// * counts the failure in one statement so parallel attempts can't overwrite each other,
+// * failures older than window are forgotten and the count starts over
+func (l *LoginThrottleRepositoryImpl) RecordFailure(key string, now time.Time, window time.Duration) (*LoginThrottle, error) {
+	var throttle LoginThrottle
+	err := l.db.Raw(`INSERT INTO login_throttles (key, updated_at, failures, last_failure_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING *`, key, now, now, now.Add(-window)).Scan(&throttle).Error
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (l *LoginThrottleRepositoryImpl) Lock(key string, until time.Time) error {
+	return l.db.Model(&LoginThrottle{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (l *LoginThrottleRepositoryImpl) Delete(key string) error {
+	return l.db.Where("key = ?", key).Delete(&LoginThrottle{}).Error
+}
+
+// * forgets the failures older than window, entries that are still locked are kept
+func (l *LoginThrottleRepositoryImpl) DeleteStale(now time.Time, window time.Duration) error {
+	return l.db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-window), now).Delete(&LoginThrottle{}).Error
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxNotificationAttempts = 5
+
+type NotificationKind string
+
+const (
+	HoldAvailable NotificationKind = "hold_available"
+	LoanDueSoon   NotificationKind = "loan_due_soon"
+	LoanOverdue   NotificationKind = "loan_overdue"
+)
+
+// * outbox of messages to members, a message is kept until it was delivered
+type Notification struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	UserID    string           `gorm:"index" json:"userID"`
+	Kind      NotificationKind `json:"kind"`
+	DedupKey  string           `gorm:"uniqueIndex" json:"-"` // * the same event is never queued twice
+	Subject   string           `json:"subject"`
+	Body      string           `json:"body"`
+	SentAt    *time.Time       `gorm:"index" json:"sentAt"`
+	Attempts  uint             `json:"attempts"`
+	LastError string           `json:"lastError"`
+}
+
+type NotificationRepository interface {
+	Enqueue(notification *Notification) error
+	SendPending(limit int, send func(notification *Notification) error) (int, error)
+}
+
+type NotificationRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) NotificationRepository {
+	return &NotificationRepositoryImpl{db}
+}
+
+func (n *NotificationRepositoryImpl) Enqueue(notification *Notification) error {
+	return n.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "dedup_key"}},
+		DoNothing: true,
+	}).Create(notification).Error
+}
+
+// * every notification is locked while it is being sent, so concurrent dispatchers never send the same message twice
+func (n *NotificationRepositoryImpl) SendPending(limit int, send func(notification *Notification) error) (int, error) {
+	var (
+		sent   int
+		lastID uint
+	)
+
+	for i := 0; i < limit; i++ {
+		found := false
+
+		err := n.db.Transaction(func(tx *gorm.DB) error {
+			var notification Notification
+			result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("sent_at IS NULL AND attempts < ? AND id > ?", maxNotificationAttempts, lastID).
+				Order("id").Limit(1).Find(&notification)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+
+			found = true
+			lastID = notification.ID
+
+			if err := send(&notification); err != nil {
+				return tx.Model(&notification).Updates(map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": err.Error(),
+				}).Error
+			}
+
+			sent++
+			return tx.Model(&notification).Updates(map[string]interface{}{
+				"sent_at":    time.Now(),
+				"attempts":   gorm.Expr("attempts + 1"),
+				"last_error": "",
+			}).Error
+		})
+
+		if err != nil {
+			return sent, err
+		}
+
+		if !found {
+			break
+		}
+	}
+
+	return sent, nil
+}
@@ -0,0 +1,182 @@
+package types
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// * list endpoints read it from the query string, older clients can still send it as json
+type PageRequest struct {
+	Order     Order  `form:"order" json:"order"`
+	Filter    string `form:"filter" json:"filter"`
+	Limit     uint   `form:"limit" json:"limit"`
+	Cursor    string `form:"cursor" json:"cursor"`
+	WithTotal bool   `form:"total" json:"total"` // * counting is skipped unless asked for
+}
+
+// * the standard envelope of every list endpoint
+type Page[T any] struct {
+	Data  []T    `json:"data"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Total *int64 `json:"total,omitempty"`
+}
+
+// * points at the row the page starts after, backward cursors read the rows before it
+type cursor[K any, I any] struct {
+	Key      K    `json:"k"`
+	ID       I    `json:"i"`
+	Backward bool `json:"b,omitempty"`
+}
+
+// * describes how a list is sorted, key is the sort column and id breaks ties
+type keyset[T any, K any, I any] struct {
+	keyColumn string
+	idColumn  string
+	preloads  []string
+	values    func(row *T) (K, I)
+}
+
+func (p *PageRequest) Validate() error {
+	switch Order(strings.ToUpper(string(p.Order))) {
+	case "", ASC:
+		p.Order = ASC
+	case DESC:
+		p.Order = DESC
+	default:
+		return fmt.Errorf("order must be either ASC or DESC")
+	}
+
+	if p.Limit == 0 {
+		p.Limit = defaultPageLimit
+	}
+
+	if p.Limit > maxPageLimit {
+		return fmt.Errorf("limit can't be more than %d", maxPageLimit)
+	}
+
+	return nil
+}
+
+// * keeps the cursors of the page while converting its rows
+func MapPage[T any, U any](page *Page[T], convert func(row *T) U) *Page[U] {
+	mapped := Page[U]{Data: make([]U, len(page.Data)), Next: page.Next, Prev: page.Prev, Total: page.Total}
+	for i := range page.Data {
+		mapped.Data[i] = convert(&page.Data[i])
+	}
+	return &mapped
+}
+
+func encodeCursor[K any, I any](c cursor[K, I]) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor[K any, I any](value string) (*cursor[K, I], error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor[K, I]
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// * keyset pagination over db, which must already hold the filters of the list
+func paginate[T any, K any, I any](db *gorm.DB, req PageRequest, ks keyset[T, K, I]) (*Page[T], error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	base := db.Session(&gorm.Session{})
+	page := Page[T]{Data: []T{}}
+
+	if req.WithTotal {
+		var total int64
+		if err := base.Model(new(T)).Count(&total).Error; err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	var after *cursor[K, I]
+	if req.Cursor != "" {
+		decoded, err := decodeCursor[K, I](req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+	}
+
+	backward := after != nil && after.Backward
+
+	// * backward pages are read in reverse and flipped afterwards
+	ascending := req.Order == ASC
+	if backward {
+		ascending = !ascending
+	}
+
+	direction, comparison := "ASC", ">"
+	if !ascending {
+		direction, comparison = "DESC", "<"
+	}
+
+	query := base
+	for _, association := range ks.preloads {
+		query = query.Preload(association)
+	}
+
+	query = query.Order(fmt.Sprintf("%s %s, %s %s", ks.keyColumn, direction, ks.idColumn, direction))
+	if after != nil {
+		query = query.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", ks.keyColumn, ks.idColumn, comparison), after.Key, after.ID)
+	}
+
+	var rows []T
+	if err := query.Limit(int(req.Limit) + 1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	hasMore := len(rows) > int(req.Limit)
+	if hasMore {
+		rows = rows[:req.Limit]
+	}
+
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if len(rows) == 0 {
+		return &page, nil
+	}
+	page.Data = rows
+
+	firstKey, firstID := ks.values(&rows[0])
+	lastKey, lastID := ks.values(&rows[len(rows)-1])
+
+	// * moving forward there is always something behind a cursor, moving backward always something ahead
+	if backward || hasMore {
+		page.Next = encodeCursor(cursor[K, I]{Key: lastKey, ID: lastID})
+	}
+	if (backward && hasMore) || (!backward && after != nil) {
+		page.Prev = encodeCursor(cursor[K, I]{Key: firstKey, ID: firstID, Backward: true})
+	}
+
+	return &page, nil
+}
@@ -0,0 +1,76 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * only the sha256 of the emailed token is stored
+type PasswordResetToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	UserID    string     `gorm:"index" json:"userID"`
+	TokenHash string     `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+type PasswordResetRepository interface {
+	Create(token *PasswordResetToken) error
+	Consume(hash string, now time.Time) (*PasswordResetToken, error)
+	InvalidateByUserID(userID string, now time.Time) error
+	DeleteExpired(now time.Time) error
+}
+
+type PasswordResetRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) PasswordResetRepository {
+	return &PasswordResetRepositoryImpl{db}
+}
+
+func (p *PasswordResetRepositoryImpl) Create(token *PasswordResetToken) error {
+	return p.db.Create(token).Error
+}
+
+// * marks the token as used, a token can only be consumed once even by concurrent requests
+func (p *PasswordResetRepositoryImpl) Consume(hash string, now time.Time) (*PasswordResetToken, error) {
+	var token PasswordResetToken
+	if err := p.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	result := p.db.Model(&PasswordResetToken{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("reset token was already used")
+	}
+
+	token.UsedAt = &now
+	return &token, nil
+}
+
+// * a new token or a successful reset makes the older tokens useless
+func (p *PasswordResetRepositoryImpl) InvalidateByUserID(userID string, now time.Time) error {
+	return p.db.Model(&PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", userID).Update("used_at", now).Error
+}
+
+func (p *PasswordResetRepositoryImpl) DeleteExpired(now time.Time) error {
+	return p.db.Where("expires_at < ?", now).Delete(&PasswordResetToken{}).Error
+}
@@ -0,0 +1,52 @@
+package types
+
+type Permission string
+
+const (
+	UsersRead           Permission = "users.read"
+	UsersManage         Permission = "users.manage"
+	RolesManage         Permission = "roles.manage"
+	SecurityManage      Permission = "security.manage" // * sessions, 2FA resets and lockouts of other users
+	APIKeysManage       Permission = "apikeys.manage"
+	AuditRead           Permission = "audit.read"
+	CatalogWrite        Permission = "catalog.write"
+	CirculationCheckout Permission = "circulation.checkout"
+	HoldsRead           Permission = "holds.read"
+	HoldsPlace          Permission = "holds.place"
+	HoldsManage         Permission = "holds.manage" // * cancel the holds of other users
+	LoansRead           Permission = "loans.read"
+	LoansRenew          Permission = "loans.renew"
+	FinesRead           Permission = "fines.read"
+	FinesCollect        Permission = "fines.collect"
+	FinesWaive          Permission = "fines.waive"
+	PolicyManage        Permission = "policy.manage" // * the circulation rules of patron categories and kinds
+	CalendarManage      Permission = "calendar.manage"
+	BranchesManage      Permission = "branches.manage"
+)
+
+var AllPermissions = []Permission{
+	UsersRead, UsersManage, RolesManage, SecurityManage, APIKeysManage, AuditRead,
+	CatalogWrite, CirculationCheckout,
+	HoldsRead, HoldsPlace, HoldsManage,
+	LoansRead, LoansRenew,
+	FinesRead, FinesCollect, FinesWaive,
+	PolicyManage, CalendarManage, BranchesManage,
+}
+
+// * the read permissions cover the records of every user, everybody can read their own without them
+var StaffReadPermissions = []Permission{UsersRead, HoldsRead, LoansRead, FinesRead}
+
+// * what the old Member and Moderator ranks were allowed to do, Admin gets everything
+var (
+	MemberPermissions    = []Permission{HoldsPlace, LoansRenew}
+	ModeratorPermissions = append(append(append([]Permission{}, MemberPermissions...), StaffReadPermissions...), UsersManage, CatalogWrite, CirculationCheckout, HoldsManage, FinesCollect, FinesWaive)
+)
+
+func (p Permission) IsValid() bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * only the sha256 of the code is stored
+type RecoveryCode struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	UserID   string     `gorm:"index" json:"userID"`
+	CodeHash string     `gorm:"index" json:"-"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
+
+type RecoveryCodeRepository interface {
+	Replace(userID string, hashes []string) error
+	Consume(userID, hash string, now time.Time) (bool, error)
+	CountUnused(userID string) (int64, error)
+	DeleteByUserID(userID string) error
+}
+
+type RecoveryCodeRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &RecoveryCodeRepositoryImpl{db}
+}
+
+// * new codes make all the older ones useless
+func (r *RecoveryCodeRepositoryImpl) Replace(userID string, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// * reports whether an unused code matched, the code can't be used again afterwards
+func (r *RecoveryCodeRepositoryImpl) Consume(userID, hash string, now time.Time) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *RecoveryCodeRepositoryImpl) CountUnused(userID string) (int64, error) {
+	var count int64
+	if err := r.db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *RecoveryCodeRepositoryImpl) DeleteByUserID(userID string) error {
+	return r.db.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
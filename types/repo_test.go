@@ -1,6 +1,7 @@
 package types
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -21,7 +22,18 @@ func setupTestDB() *gorm.DB {
 		log.Fatalf("failed to connect to test database: %v", err)
 	}
 
-	db.AutoMigrate(&Author{}, &Genre{}, &Kind{}, &User{}, &Hold{}, &Loan{}, &Item{})
+	db.AutoMigrate(&Role{})
+	db.FirstOrCreate(&Role{ID: MemberRoleID, Name: "member", Permissions: MemberPermissions, Builtin: true})
+	db.AutoMigrate(&Branch{})
+	db.FirstOrCreate(&Branch{ID: MainBranchID, Name: "main"})
+	db.Exec("SELECT setval(pg_get_serial_sequence('branches', 'id'), (SELECT MAX(id) FROM branches))")
+	db.AutoMigrate(&Author{}, &Genre{}, &Kind{}, &User{}, &Hold{}, &Loan{}, &Item{}, &Fine{}, &Notification{}, &Copy{}, &Session{}, &PasswordResetToken{}, &RecoveryCode{}, &LoginThrottle{}, &APIKey{}, &Identity{}, &AuditEntry{}, &CirculationRule{}, &OpeningHours{}, &Closure{})
+	if err := MigrateSearchDocuments(db); err != nil {
+		log.Fatalf("failed to set up the search documents: %v", err)
+	}
+	if err := MigrateRuleCells(db); err != nil {
+		log.Fatalf("failed to index the circulation rule cells: %v", err)
+	}
 
 	return db
 }
@@ -75,7 +87,7 @@ func TestAuthorRepository(t *testing.T) {
 	})
 
 	t.Run("GetAllAuthors", func(t *testing.T) {
-		authors, err := repo.GetAll("asc", "", 1000)
+		authors, err := repo.GetAll(PageRequest{Order: ASC, Limit: 100})
 		assert.NoError(t, err)
 		assert.NotNil(t, authors)
 	})
@@ -155,7 +167,7 @@ func TestGenreRepository(t *testing.T) {
 	})
 
 	t.Run("GetAllGenre", func(t *testing.T) {
-		genre, err := repo.GetAll("asc", "", 1000)
+		genre, err := repo.GetAll(PageRequest{Order: ASC, Limit: 100})
 		assert.NoError(t, err)
 		assert.NotNil(t, genre)
 	})
@@ -234,7 +246,7 @@ func TestKindRepository(t *testing.T) {
 	})
 
 	t.Run("GetAllKind", func(t *testing.T) {
-		kind, err := repo.GetAll("asc", "", 1000)
+		kind, err := repo.GetAll(PageRequest{Order: ASC, Limit: 100})
 		assert.NoError(t, err)
 		assert.NotNil(t, kind)
 	})
@@ -318,7 +330,7 @@ func TestUserRepository(t *testing.T) {
 	})
 
 	t.Run("GetAllUser", func(t *testing.T) {
-		user, err := repo.GetAll()
+		user, err := repo.GetAll(PageRequest{})
 		assert.NoError(t, err)
 		assert.NotNil(t, user)
 	})
@@ -578,6 +590,22 @@ func TestLoanRepository(t *testing.T) {
 		}()
 	})
 
+	t.Run("GetLoanByCopyID", func(t *testing.T) {
+		loan.CopyID = 42
+		err := repo.Create(loan)
+		assert.NoError(t, err)
+
+		foundLoan, err := repo.GetByCopyID(42)
+		assert.NoError(t, err)
+		assert.Equal(t, loan.ID, foundLoan.ID)
+
+		defer func() {
+			loan.CopyID = 0
+			err := repo.Delete(loan.ID)
+			assert.NoError(t, err)
+		}()
+	})
+
 	t.Run("UpdateLoan", func(t *testing.T) {
 		err := repo.Create(loan)
 		assert.NoError(t, err)
@@ -652,11 +680,10 @@ func TestItemRepository(t *testing.T) {
 	assert.NotEqual(t, 0, author.ID)
 
 	item := &Item{
-		Title:    "TestTitle",
-		Authors:  []Author{{ID: author.ID}},
-		Genres:   []Genre{{ID: genre.ID}},
-		Kinds:    []Kind{{ID: kind.ID}},
-		Quantity: 5,
+		Title:   "TestTitle",
+		Authors: []Author{{ID: author.ID}},
+		Genres:  []Genre{{ID: genre.ID}},
+		Kinds:   []Kind{{ID: kind.ID}},
 	}
 
 	t.Run("CreateItem", func(t *testing.T) {
@@ -677,11 +704,91 @@ func TestItemRepository(t *testing.T) {
 	})
 
 	t.Run("GetAllItems", func(t *testing.T) {
-		item, err := repo.GetAll("asc", "", 1000)
+		item, err := repo.GetAll(PageRequest{Order: ASC, Limit: 100})
 		assert.NoError(t, err)
 		assert.NotNil(t, item)
 	})
 
+	t.Run("SearchItems", func(t *testing.T) {
+		dune := &Item{Title: "Dune", Description: "A desert planet and the spice melange.", Authors: []Author{{ID: author.ID}}}
+		other := &Item{Title: "Foundation", Description: "The fall of a galactic empire."}
+
+		assert.NoError(t, repo.Create(dune))
+		assert.NoError(t, repo.Create(other))
+
+		results, err := repo.Search("spice OR empire", 10)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+
+		results, err = repo.Search(`"desert planet" NOT empire`, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, dune.ID, results[0].Item.ID)
+			assert.Contains(t, results[0].Snippet, "<mark>desert</mark>")
+		}
+
+		// * authors are searchable too
+		results, err = repo.Search("testauthor", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, dune.ID, results[0].Item.ID)
+		}
+
+		defer func() {
+			assert.NoError(t, repo.DisassociateAuthor(dune, author))
+			assert.NoError(t, repo.Delete(dune.ID))
+			assert.NoError(t, repo.Delete(other.ID))
+		}()
+	})
+
+	t.Run("BrowseItems", func(t *testing.T) {
+		copyRepo := NewCopyRepository(set)
+
+		older := &Item{Title: "TestOlder", PublicationYear: 1965, Genres: []Genre{{ID: genre.ID}}}
+		newer := &Item{Title: "TestNewer", PublicationYear: 2001}
+
+		assert.NoError(t, repo.Create(older))
+		assert.NoError(t, repo.Create(newer))
+
+		cp := &Copy{ItemID: older.ID, Barcode: "TEST-BROWSE-0001", Status: CopyAvailable}
+		assert.NoError(t, copyRepo.Create(cp))
+
+		available := true
+		page, err := repo.Browse(CatalogFilter{GenreIDs: []uint{genre.ID}, Available: &available}, PageRequest{Limit: 10, WithTotal: true})
+		assert.NoError(t, err)
+		if assert.NotNil(t, page.Total) {
+			assert.Equal(t, int64(1), *page.Total)
+		}
+		if assert.Len(t, page.Data, 1) {
+			assert.Equal(t, older.ID, page.Data[0].ID)
+		}
+
+		// * the genre facet ignores the genre filter itself
+		if assert.Len(t, page.Facets.Genres, 1) {
+			assert.Equal(t, genre.ID, page.Facets.Genres[0].ID)
+			assert.Equal(t, int64(1), page.Facets.Genres[0].Count)
+		}
+		assert.Equal(t, int64(1), page.Facets.Availability.Available)
+
+		page, err = repo.Browse(CatalogFilter{YearFrom: 2000}, PageRequest{Filter: "TestNewer", Limit: 10})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Data, 1) {
+			assert.Equal(t, newer.ID, page.Data[0].ID)
+		}
+
+		// * an empty result still has every facet as a list
+		page, err = repo.Browse(CatalogFilter{YearFrom: 3000}, PageRequest{})
+		assert.NoError(t, err)
+		assert.NotNil(t, page.Facets.Years)
+		assert.Empty(t, page.Facets.Years)
+
+		defer func() {
+			assert.NoError(t, repo.DisassociateGenre(older, genre))
+			assert.NoError(t, repo.Delete(older.ID))
+			assert.NoError(t, repo.Delete(newer.ID))
+		}()
+	})
+
 	t.Run("GetItemsByAuthorID", func(t *testing.T) {
 		item, err := repo.GetItemsByAuthor(author.ID)
 		assert.NoError(t, err)
@@ -707,7 +814,7 @@ func TestItemRepository(t *testing.T) {
 		foundItem, err := repo.GetByID(item.ID)
 		assert.NoError(t, err)
 		assert.NotNil(t, foundItem)
-		assert.Equal(t, item.Quantity, foundItem.Quantity)
+		assert.Equal(t, item.Title, foundItem.Title)
 
 		defer func() {
 			err := repo.Delete(item.ID)
@@ -719,13 +826,13 @@ func TestItemRepository(t *testing.T) {
 		err := repo.Create(item)
 		assert.NoError(t, err)
 
-		item.Quantity = 6
+		item.Description = "UpdatedDescription"
 		err = repo.Update(item)
 		assert.NoError(t, err)
 
 		updatedItem, err := repo.GetByID(item.ID)
 		assert.NoError(t, err)
-		assert.Equal(t, uint(6), updatedItem.Quantity)
+		assert.Equal(t, "UpdatedDescription", updatedItem.Description)
 
 		defer func() {
 			err := repo.Delete(item.ID)
@@ -737,6 +844,15 @@ func TestItemRepository(t *testing.T) {
 		err := repo.Create(item)
 		assert.NoError(t, err)
 
+		cp := &Copy{ItemID: item.ID, Barcode: "TEST-DELETE-0001", Status: CopyOnLoan}
+		assert.NoError(t, NewCopyRepository(set).Create(cp))
+
+		// * the copy is out, the item has to stay until it's returned
+		assert.ErrorIs(t, repo.Delete(item.ID), ErrItemInCirculation)
+
+		cp.Status = CopyAvailable
+		assert.NoError(t, NewCopyRepository(set).Update(cp))
+
 		err = repo.Delete(item.ID)
 		assert.NoError(t, err)
 
@@ -754,3 +870,904 @@ func TestItemRepository(t *testing.T) {
 		assert.NoError(t, kindErr)
 	}()
 }
+
+func TestFineRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewFineRepository(set)
+
+	userID := "test_fine_user"
+	loanID := uint(4242)
+
+	charge := &Fine{UserID: userID, LoanID: &loanID, Type: Charge, Amount: 150}
+	payment := &Fine{UserID: userID, Type: Payment, Amount: 100}
+
+	t.Run("CreateFine", func(t *testing.T) {
+		err := repo.Create(charge)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 0, charge.ID)
+
+		err = repo.Create(payment)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 0, payment.ID)
+	})
+
+	t.Run("GetFinesByUserID", func(t *testing.T) {
+		fines, err := repo.GetByUserID(userID)
+		assert.NoError(t, err)
+		assert.Len(t, fines, 2)
+	})
+
+	t.Run("GetChargeByLoanID", func(t *testing.T) {
+		foundCharge, err := repo.GetChargeByLoanID(loanID)
+		assert.NoError(t, err)
+		assert.Equal(t, charge.ID, foundCharge.ID)
+	})
+
+	t.Run("GetBalance", func(t *testing.T) {
+		balance, err := repo.GetBalance(userID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(50), balance)
+	})
+
+	t.Run("UpdateFine", func(t *testing.T) {
+		charge.Amount = 300
+		err := repo.Update(charge)
+		assert.NoError(t, err)
+
+		balance, err := repo.GetBalance(userID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(200), balance)
+	})
+
+	defer func() {
+		err := set.Where("user_id = ?", userID).Delete(&Fine{}).Error
+		assert.NoError(t, err)
+	}()
+}
+
+func TestNotificationRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewNotificationRepository(set)
+
+	notification := &Notification{UserID: "test_notification_user", Kind: HoldAvailable, DedupKey: "test:notification", Subject: "TestSubject"}
+
+	t.Run("EnqueueNotification", func(t *testing.T) {
+		err := repo.Enqueue(notification)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 0, notification.ID)
+
+		duplicate := &Notification{UserID: notification.UserID, Kind: HoldAvailable, DedupKey: notification.DedupKey}
+		err = repo.Enqueue(duplicate)
+		assert.NoError(t, err)
+
+		var count int64
+		set.Model(&Notification{}).Where("dedup_key = ?", notification.DedupKey).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("SendPendingNotifications", func(t *testing.T) {
+		var sent []uint
+		_, err := repo.SendPending(100, func(n *Notification) error {
+			sent = append(sent, n.ID)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Contains(t, sent, notification.ID)
+
+		sent = nil
+		_, err = repo.SendPending(100, func(n *Notification) error {
+			sent = append(sent, n.ID)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.NotContains(t, sent, notification.ID)
+	})
+
+	defer func() {
+		err := set.Where("dedup_key = ?", notification.DedupKey).Delete(&Notification{}).Error
+		assert.NoError(t, err)
+	}()
+}
+
+func TestCopyRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewCopyRepository(set)
+	itemRepo := NewItemRepository(set)
+
+	item := &Item{Title: "TestTitle"}
+
+	itemErr := itemRepo.Create(item)
+	assert.NoError(t, itemErr)
+	assert.NotEqual(t, 0, item.ID)
+
+	cp := &Copy{ItemID: item.ID, Barcode: "TEST-COPY-0001", Status: CopyOnLoan}
+	available := &Copy{ItemID: item.ID, Barcode: "TEST-COPY-0002", Status: CopyAvailable}
+
+	t.Run("CreateCopy", func(t *testing.T) {
+		err := repo.Create(cp)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 0, cp.ID)
+
+		err = repo.Create(available)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 0, available.ID)
+	})
+
+	t.Run("GetCopiesByItemID", func(t *testing.T) {
+		copies, err := repo.GetByItemID(item.ID)
+		assert.NoError(t, err)
+		assert.Len(t, copies, 2)
+	})
+
+	t.Run("GetCopyByBarcode", func(t *testing.T) {
+		foundCopy, err := repo.GetByBarcode(cp.Barcode)
+		assert.NoError(t, err)
+		assert.Equal(t, cp.ID, foundCopy.ID)
+	})
+
+	t.Run("GetFirstAvailableCopy", func(t *testing.T) {
+		foundCopy, err := repo.GetFirstAvailable(item.ID, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, available.ID, foundCopy.ID)
+
+		mainBranch := MainBranchID
+		foundCopy, err = repo.GetFirstAvailable(item.ID, &mainBranch)
+		assert.NoError(t, err)
+		assert.Equal(t, available.ID, foundCopy.ID)
+
+		otherBranch := MainBranchID + 1000
+		_, err = repo.GetFirstAvailable(item.ID, &otherBranch)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("UpdateCopy", func(t *testing.T) {
+		cp.Status = CopyLost
+		err := repo.Update(cp)
+		assert.NoError(t, err)
+
+		updatedCopy, err := repo.GetByID(cp.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, CopyLost, updatedCopy.Status)
+	})
+
+	t.Run("DeleteCopy", func(t *testing.T) {
+		err := repo.Delete(cp.ID)
+		assert.NoError(t, err)
+
+		deletedCopy, err := repo.GetByID(cp.ID)
+		assert.Error(t, err)
+		assert.Nil(t, deletedCopy)
+	})
+
+	defer func() {
+		itemErr := itemRepo.Delete(item.ID)
+		assert.NoError(t, itemErr)
+	}()
+}
+
+func TestPagination(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewGenreRepository(set)
+
+	genres := []*Genre{{Name: "TestPageA"}, {Name: "TestPageB"}, {Name: "TestPageC"}}
+	for _, genre := range genres {
+		assert.NoError(t, repo.Create(genre))
+	}
+
+	req := PageRequest{Filter: "TestPage", Limit: 2, WithTotal: true}
+
+	first, err := repo.GetAll(req)
+	assert.NoError(t, err)
+	assert.Len(t, first.Data, 2)
+	assert.Equal(t, "TestPageA", first.Data[0].Name)
+	assert.Equal(t, int64(3), *first.Total)
+	assert.Empty(t, first.Prev)
+	assert.NotEmpty(t, first.Next)
+
+	t.Run("NextPage", func(t *testing.T) {
+		req.Cursor = first.Next
+		second, err := repo.GetAll(req)
+		assert.NoError(t, err)
+		if assert.Len(t, second.Data, 1) {
+			assert.Equal(t, "TestPageC", second.Data[0].Name)
+		}
+		assert.Empty(t, second.Next)
+		assert.NotEmpty(t, second.Prev)
+
+		req.Cursor = second.Prev
+		back, err := repo.GetAll(req)
+		assert.NoError(t, err)
+		if assert.Len(t, back.Data, 2) {
+			assert.Equal(t, "TestPageA", back.Data[0].Name)
+			assert.Equal(t, "TestPageB", back.Data[1].Name)
+		}
+		assert.Empty(t, back.Prev)
+	})
+
+	t.Run("DescendingOrder", func(t *testing.T) {
+		page, err := repo.GetAll(PageRequest{Filter: "TestPage", Order: DESC, Limit: 2})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Data, 2) {
+			assert.Equal(t, "TestPageC", page.Data[0].Name)
+		}
+		assert.Nil(t, page.Total)
+	})
+
+	t.Run("InvalidCursor", func(t *testing.T) {
+		_, err := repo.GetAll(PageRequest{Cursor: "not a cursor"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	defer func() {
+		for _, genre := range genres {
+			assert.NoError(t, repo.Delete(genre.ID))
+		}
+	}()
+}
+
+func TestSessionRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewSessionRepository(set)
+	now := time.Now()
+
+	session := &Session{
+		ID:               "test_session",
+		UserID:           "test_session_user",
+		RefreshTokenHash: "test_refresh_hash",
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(time.Hour),
+	}
+	expired := &Session{
+		ID:               "test_expired_session",
+		UserID:           "test_session_user",
+		RefreshTokenHash: "test_expired_hash",
+		ExpiresAt:        now.Add(-time.Hour),
+	}
+
+	t.Run("CreateSession", func(t *testing.T) {
+		assert.NoError(t, repo.Create(session))
+		assert.NoError(t, repo.Create(expired))
+	})
+
+	t.Run("GetSessionByRefreshTokenHash", func(t *testing.T) {
+		found, err := repo.GetByRefreshTokenHash("test_refresh_hash")
+		assert.NoError(t, err)
+		assert.Equal(t, session.ID, found.ID)
+	})
+
+	t.Run("RotateRefreshToken", func(t *testing.T) {
+		session.PreviousTokenHash = session.RefreshTokenHash
+		session.RefreshTokenHash = "test_rotated_hash"
+		rotated, err := repo.Rotate(session, "test_refresh_hash")
+		assert.NoError(t, err)
+		assert.True(t, rotated)
+
+		found, err := repo.GetByPreviousTokenHash("test_refresh_hash")
+		assert.NoError(t, err)
+		assert.Equal(t, session.ID, found.ID)
+
+		// * the same token can't be rotated twice
+		session.RefreshTokenHash = "test_rotated_again_hash"
+		rotated, err = repo.Rotate(session, "test_refresh_hash")
+		assert.NoError(t, err)
+		assert.False(t, rotated)
+		session.RefreshTokenHash = "test_rotated_hash"
+	})
+
+	t.Run("GetActiveSessionsByUserID", func(t *testing.T) {
+		sessions, err := repo.GetActiveByUserID("test_session_user", now)
+		assert.NoError(t, err)
+		if assert.Len(t, sessions, 1) {
+			assert.Equal(t, session.ID, sessions[0].ID)
+		}
+	})
+
+	t.Run("RevokeSession", func(t *testing.T) {
+		assert.NoError(t, repo.RevokeAllByUserID("test_session_user", now))
+
+		found, err := repo.GetByID(session.ID)
+		assert.NoError(t, err)
+		assert.False(t, found.IsActive(now))
+	})
+
+	t.Run("DeleteExpiredSessions", func(t *testing.T) {
+		assert.NoError(t, repo.DeleteExpired(now))
+
+		_, err := repo.GetByID(expired.ID)
+		assert.Error(t, err)
+	})
+
+	defer func() {
+		assert.NoError(t, repo.DeleteExpired(now.Add(2*time.Hour)))
+	}()
+}
+
+func TestPasswordResetRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewPasswordResetRepository(set)
+	now := time.Now()
+
+	token := &PasswordResetToken{UserID: "test_reset_user", TokenHash: "test_reset_hash", ExpiresAt: now.Add(time.Hour)}
+	expired := &PasswordResetToken{UserID: "test_reset_user", TokenHash: "test_expired_reset_hash", ExpiresAt: now.Add(-time.Hour)}
+
+	t.Run("CreateToken", func(t *testing.T) {
+		assert.NoError(t, repo.Create(token))
+		assert.NoError(t, repo.Create(expired))
+	})
+
+	t.Run("ConsumeTokenOnce", func(t *testing.T) {
+		consumed, err := repo.Consume("test_reset_hash", now)
+		assert.NoError(t, err)
+		assert.Equal(t, token.ID, consumed.ID)
+
+		_, err = repo.Consume("test_reset_hash", now)
+		assert.Error(t, err)
+	})
+
+	t.Run("ExpiredTokenCantBeConsumed", func(t *testing.T) {
+		_, err := repo.Consume("test_expired_reset_hash", now)
+		assert.Error(t, err)
+	})
+
+	defer func() {
+		assert.NoError(t, repo.DeleteExpired(now.Add(2*time.Hour)))
+	}()
+}
+
+func TestRecoveryCodeRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewRecoveryCodeRepository(set)
+	now := time.Now()
+
+	t.Run("ReplaceCodes", func(t *testing.T) {
+		assert.NoError(t, repo.Replace("test_2fa_user", []string{"test_old_code"}))
+		assert.NoError(t, repo.Replace("test_2fa_user", []string{"test_code_1", "test_code_2"}))
+
+		count, err := repo.CountUnused("test_2fa_user")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("ConsumeCodeOnce", func(t *testing.T) {
+		used, err := repo.Consume("test_2fa_user", "test_code_1", now)
+		assert.NoError(t, err)
+		assert.True(t, used)
+
+		used, err = repo.Consume("test_2fa_user", "test_code_1", now)
+		assert.NoError(t, err)
+		assert.False(t, used)
+
+		used, err = repo.Consume("test_2fa_user", "test_old_code", now)
+		assert.NoError(t, err)
+		assert.False(t, used)
+	})
+
+	defer func() {
+		assert.NoError(t, repo.DeleteByUserID("test_2fa_user"))
+	}()
+}
+
+func TestLoginThrottleRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewLoginThrottleRepository(set)
+	now := time.Now()
+
+	t.Run("RecordFailures", func(t *testing.T) {
+		_, err := repo.RecordFailure("user:test_throttle", now, time.Hour)
+		assert.NoError(t, err)
+
+		throttle, err := repo.RecordFailure("user:test_throttle", now, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(2), throttle.Failures)
+	})
+
+	t.Run("ForgetFailuresOutsideTheWindow", func(t *testing.T) {
+		throttle, err := repo.RecordFailure("user:test_throttle", now.Add(2*time.Hour), time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(1), throttle.Failures)
+	})
+
+	t.Run("LockAndListLocked", func(t *testing.T) {
+		assert.NoError(t, repo.Lock("user:test_throttle", now.Add(3*time.Hour)))
+
+		locked, err := repo.GetLocked(now)
+		assert.NoError(t, err)
+
+		keys := make([]string, len(locked))
+		for i, throttle := range locked {
+			keys[i] = throttle.Key
+		}
+		assert.Contains(t, keys, "user:test_throttle")
+	})
+
+	t.Run("DeleteStaleKeepsLockedEntries", func(t *testing.T) {
+		assert.NoError(t, repo.DeleteStale(now.Add(150*time.Minute), time.Minute))
+		_, err := repo.Get("user:test_throttle")
+		assert.NoError(t, err)
+	})
+
+	defer func() {
+		assert.NoError(t, repo.Delete("user:test_throttle"))
+	}()
+}
+
+func TestRoleRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewRoleRepository(set)
+	userRepo := NewUserRepository(set)
+
+	role := &Role{Name: "test_cataloguer", Permissions: []Permission{CatalogWrite}}
+	user := &User{ID: "test_role_user_id", Username: "test_role_user", Email: "test_role_user@example.com", LibraryCard: "test_role_card"}
+
+	t.Run("CreateRole", func(t *testing.T) {
+		assert.NoError(t, repo.Create(role))
+		assert.NotZero(t, role.ID)
+	})
+
+	t.Run("UsersAreMembersByDefault", func(t *testing.T) {
+		assert.NoError(t, userRepo.Create(user))
+
+		fetched, err := userRepo.GetByID(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, MemberRoleID, fetched.RoleID)
+		assert.True(t, fetched.Can(HoldsPlace))
+		assert.False(t, fetched.Can(CatalogWrite))
+	})
+
+	t.Run("AssignRole", func(t *testing.T) {
+		fetched, err := userRepo.GetByID(user.ID)
+		assert.NoError(t, err)
+
+		fetched.RoleID = role.ID
+		assert.NoError(t, userRepo.Update(fetched))
+
+		fetched, err = userRepo.GetByID(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, role.ID, fetched.RoleID)
+		assert.True(t, fetched.Can(CatalogWrite))
+		assert.False(t, fetched.Can(HoldsPlace))
+
+		count, err := repo.CountUsers(role.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	defer func() {
+		assert.NoError(t, set.Where("id = ?", user.ID).Delete(&User{}).Error)
+		assert.NoError(t, repo.Delete(role.ID))
+	}()
+}
+
+func TestAPIKeyRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewAPIKeyRepository(set)
+	now := time.Now()
+
+	key := &APIKey{Name: "test_kiosk", UserID: "test_kiosk_user", KeyHash: "test_kiosk_hash", Permissions: []Permission{CirculationCheckout}}
+
+	t.Run("CreateKey", func(t *testing.T) {
+		assert.NoError(t, repo.Create(key))
+	})
+
+	t.Run("GetKeyByHash", func(t *testing.T) {
+		fetched, err := repo.GetByHash("test_kiosk_hash")
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID, fetched.ID)
+		assert.Equal(t, []Permission{CirculationCheckout}, fetched.Permissions)
+		assert.True(t, fetched.IsActive(now))
+	})
+
+	t.Run("RevokeKey", func(t *testing.T) {
+		assert.NoError(t, repo.Revoke(key.ID, now))
+
+		fetched, err := repo.GetByID(key.ID)
+		assert.NoError(t, err)
+		assert.False(t, fetched.IsActive(now))
+	})
+
+	defer func() {
+		assert.NoError(t, set.Delete(&APIKey{}, key.ID).Error)
+	}()
+}
+
+func TestAuditRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	store := NewStore(set)
+	actor := "test_audit_actor"
+
+	t.Run("DiffKeepsOnlyChangedFields", func(t *testing.T) {
+		before := Role{ID: 7, Name: "clerk", Permissions: []Permission{HoldsRead}}
+		after := Role{ID: 7, Name: "clerk", Permissions: []Permission{HoldsRead, LoansRead}, UpdatedAt: time.Now()}
+
+		changes := AuditDiff(before, after)
+		assert.Len(t, changes, 1)
+		assert.Contains(t, changes, "permissions")
+
+		assert.Contains(t, AuditDiff(nil, after), "name")
+		assert.Nil(t, AuditDiff(before, nil)["name"].After)
+	})
+
+	t.Run("RecordsWithTheChange", func(t *testing.T) {
+		err := store.Transaction(func(tx *Store) error {
+			return tx.Audit.Record(&AuditEntry{ActorID: actor, Action: AuditRoleCreated, TargetType: "role", TargetID: "1", Changes: AuditChanges{"name": {After: "clerk"}}})
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("RollsBackWithTheChange", func(t *testing.T) {
+		err := store.Transaction(func(tx *Store) error {
+			if err := tx.Audit.Record(&AuditEntry{ActorID: actor, Action: AuditRoleDeleted, TargetType: "role", TargetID: "1"}); err != nil {
+				return err
+			}
+			return errors.New("the change failed")
+		})
+		assert.Error(t, err)
+
+		page, err := store.Audit.List(AuditFilter{ActorID: actor, Action: string(AuditRoleDeleted)}, PageRequest{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Data)
+	})
+
+	t.Run("FiltersByActionPrefix", func(t *testing.T) {
+		page, err := store.Audit.List(AuditFilter{ActorID: actor, Action: "role"}, PageRequest{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.Data, 1) {
+			assert.Equal(t, "clerk", page.Data[0].Changes["name"].After)
+		}
+
+		page, err = store.Audit.List(AuditFilter{ActorID: actor, Action: "rol"}, PageRequest{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Data)
+	})
+
+	t.Run("ExportsInBatches", func(t *testing.T) {
+		var exported []AuditEntry
+		err := store.Audit.Export(AuditFilter{ActorID: actor}, func(entries []AuditEntry) error {
+			exported = append(exported, entries...)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, exported, 1)
+	})
+
+	defer func() {
+		assert.NoError(t, set.Where("actor_id = ?", actor).Delete(&AuditEntry{}).Error)
+	}()
+}
+
+func TestCirculationRuleRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewCirculationRuleRepository(set)
+
+	kind := &Kind{Name: "test_rule_kind"}
+	assert.NoError(t, set.Create(kind).Error)
+
+	loans, loanDays := uint(5), uint(7)
+	patronRule := &CirculationRule{PatronCategory: "test_child", MaxLoans: &loans}
+	kindRule := &CirculationRule{PatronCategory: "test_child", KindID: &kind.ID, LoanDays: &loanDays}
+
+	t.Run("CreateRules", func(t *testing.T) {
+		assert.NoError(t, repo.Create(patronRule))
+		assert.NoError(t, repo.Create(kindRule))
+	})
+
+	t.Run("GetRuleByCell", func(t *testing.T) {
+		found, err := repo.GetByCell("test_child", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, patronRule.ID, found.ID)
+
+		found, err = repo.GetByCell("test_child", &kind.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, kindRule.ID, found.ID)
+
+		_, err = repo.GetByCell("test_adult", nil)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+
+	t.Run("RefusesASecondRuleForTheSameCell", func(t *testing.T) {
+		err := repo.Create(&CirculationRule{PatronCategory: "test_child", LoanDays: &loanDays})
+		assert.ErrorIs(t, err, ErrRuleCellTaken)
+
+		err = repo.Create(&CirculationRule{PatronCategory: "test_child", KindID: &kind.ID, MaxRenewals: &loans})
+		assert.ErrorIs(t, err, ErrRuleCellTaken)
+	})
+
+	t.Run("DeletingTheKindDeletesItsRules", func(t *testing.T) {
+		assert.NoError(t, set.Delete(&Kind{}, kind.ID).Error)
+
+		_, err := repo.GetByID(kindRule.ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("ValidateRule", func(t *testing.T) {
+		zero := uint(0)
+		assert.Error(t, (&CirculationRule{KindID: &kind.ID, MaxLoans: &loans}).Validate())
+		assert.Error(t, (&CirculationRule{LoanDays: &zero}).Validate())
+		assert.Error(t, (&CirculationRule{LoanDays: &loanDays, RenewalWindowDays: &loans, PickupDays: &zero}).Validate())
+
+		rule := &CirculationRule{PatronCategory: " Child ", LoanDays: &loanDays}
+		assert.NoError(t, rule.Validate())
+		assert.Equal(t, "child", rule.PatronCategory)
+	})
+
+	defer func() {
+		assert.NoError(t, repo.Delete(patronRule.ID))
+	}()
+}
+
+func TestCalendarRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewCalendarRepository(set)
+
+	march := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	christmas := &Closure{UID: "test_christmas", Name: "christmas", StartDate: time.Date(2000, 12, 24, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2000, 12, 26, 0, 0, 0, 0, time.UTC), Yearly: true}
+	renovation := &Closure{UID: "test_renovation", Kind: TemporaryClosure, Name: "renovation", StartDate: march, EndDate: march.AddDate(0, 0, 2)}
+
+	t.Run("ReplaceHours", func(t *testing.T) {
+		assert.NoError(t, repo.ReplaceHours([]OpeningHours{
+			{Weekday: time.Monday, Opens: "09:00", Closes: "18:00"},
+			{Weekday: time.Sunday, Opens: "10:00", Closes: "14:00"},
+		}))
+		assert.NoError(t, repo.ReplaceHours([]OpeningHours{{Weekday: time.Saturday, Opens: "10:00", Closes: "15:00"}}))
+
+		hours, err := repo.GetHours()
+		assert.NoError(t, err)
+		if assert.Len(t, hours, 1) {
+			assert.Equal(t, time.Saturday, hours[0].Weekday)
+		}
+	})
+
+	t.Run("CreateClosures", func(t *testing.T) {
+		assert.NoError(t, christmas.Validate())
+		assert.NoError(t, repo.CreateClosure(christmas))
+		assert.NoError(t, renovation.Validate())
+		assert.NoError(t, repo.CreateClosure(renovation))
+
+		found, err := repo.GetClosureByUID("test_renovation")
+		assert.NoError(t, err)
+		assert.Equal(t, renovation.ID, found.ID)
+	})
+
+	t.Run("GetClosuresKeepsYearlyOnesInEveryRange", func(t *testing.T) {
+		from := march.AddDate(0, 0, 3)
+		closures, err := repo.GetClosures(ClosureFilter{From: &from})
+		assert.NoError(t, err)
+		assert.Len(t, closures, 1)
+
+		to := march.AddDate(0, 0, 1)
+		closures, err = repo.GetClosures(ClosureFilter{To: &to})
+		assert.NoError(t, err)
+		assert.Len(t, closures, 2)
+	})
+
+	t.Run("ValidateClosure", func(t *testing.T) {
+		assert.Error(t, (&Closure{Name: "backwards", StartDate: march, EndDate: march.AddDate(0, 0, -1)}).Validate())
+		assert.Error(t, (&Closure{Name: "too long", StartDate: march, EndDate: march.AddDate(1, 0, 0), Yearly: true}).Validate())
+		assert.Error(t, (&Closure{Name: "unknown", Kind: "strike", StartDate: march}).Validate())
+
+		single := &Closure{Name: "single day", StartDate: march.Add(15 * time.Hour)}
+		assert.NoError(t, single.Validate())
+		assert.Equal(t, march, single.StartDate)
+		assert.Equal(t, march, single.EndDate)
+		assert.Equal(t, HolidayClosure, single.Kind)
+	})
+
+	t.Run("ValidateOpeningHours", func(t *testing.T) {
+		assert.NoError(t, (&OpeningHours{Weekday: time.Monday, Opens: "09:00", Closes: "18:00"}).Validate())
+		assert.Error(t, (&OpeningHours{Weekday: time.Monday, Opens: "18:00", Closes: "09:00"}).Validate())
+		assert.Error(t, (&OpeningHours{Weekday: 7, Opens: "09:00", Closes: "18:00"}).Validate())
+		assert.Error(t, (&OpeningHours{Weekday: time.Monday, Opens: "9am", Closes: "18:00"}).Validate())
+	})
+
+	defer func() {
+		assert.NoError(t, repo.ReplaceHours(nil))
+		assert.NoError(t, repo.DeleteClosure(christmas.ID))
+		assert.NoError(t, repo.DeleteClosure(renovation.ID))
+	}()
+}
+
+func TestBranchRepository(t *testing.T) {
+	set := setupTestDB()
+
+	defer func() {
+		if sqlDB, err := set.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				t.Errorf("error closing test database: %v", err)
+			}
+		} else {
+			t.Errorf("error getting underlying database connection: %v", err)
+		}
+	}()
+
+	repo := NewBranchRepository(set)
+
+	branch := &Branch{Name: "test_branch", Address: "Main street 1"}
+
+	t.Run("CreateBranch", func(t *testing.T) {
+		assert.NoError(t, repo.Create(branch))
+
+		found, err := repo.GetByName("test_branch")
+		assert.NoError(t, err)
+		assert.Equal(t, branch.ID, found.ID)
+	})
+
+	t.Run("TheMainBranchIsThere", func(t *testing.T) {
+		found, err := repo.GetByID(MainBranchID)
+		assert.NoError(t, err)
+		assert.Equal(t, MainBranchID, found.ID)
+	})
+
+	t.Run("BranchWithCopiesIsInUse", func(t *testing.T) {
+		inUse, err := repo.IsInUse(branch.ID)
+		assert.NoError(t, err)
+		assert.False(t, inUse)
+
+		item := &Item{Title: "test_branch_item"}
+		assert.NoError(t, set.Omit("Copies").Create(item).Error)
+		cp := &Copy{ItemID: item.ID, Barcode: "TEST-BRANCH-1", Status: CopyAvailable, HomeBranchID: MainBranchID, BranchID: branch.ID}
+		assert.NoError(t, set.Create(cp).Error)
+
+		inUse, err = repo.IsInUse(branch.ID)
+		assert.NoError(t, err)
+		assert.True(t, inUse)
+
+		assert.NoError(t, set.Delete(cp).Error)
+		assert.NoError(t, set.Delete(item).Error)
+	})
+
+	t.Run("UpdateBranch", func(t *testing.T) {
+		branch.Address = "Side street 2"
+		assert.NoError(t, repo.Update(branch))
+
+		found, err := repo.GetByID(branch.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Side street 2", found.Address)
+	})
+
+	defer func() {
+		assert.NoError(t, repo.Delete(branch.ID))
+	}()
+}
@@ -0,0 +1,140 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * the built in roles keep the ids of the old Member/Moderator/Admin ranks
+const (
+	MemberRoleID    uint = 1
+	ModeratorRoleID uint = 2
+	AdminRoleID     uint = 3
+)
+
+// * a named set of permissions, users get exactly one
+type Role struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Name             string       `gorm:"unique" json:"name"`
+	Description      string       `json:"description"`
+	Permissions      []Permission `gorm:"serializer:json" json:"permissions"`
+	RequireTwoFactor bool         `json:"requireTwoFactor"` // * holders must enable 2FA before they can use any of the permissions
+	Builtin          bool         `json:"builtin"`
+}
+
+type RoleRequest struct {
+	Name             string       `json:"name" binding:"required"`
+	Description      string       `json:"description"`
+	Permissions      []Permission `json:"permissions"`
+	RequireTwoFactor bool         `json:"requireTwoFactor"`
+}
+
+type AssignRoleRequest struct {
+	RoleID uint `json:"roleID" binding:"required"`
+}
+
+type AssignCategoryRequest struct {
+	Category string `json:"category" binding:"required"`
+}
+
+type RoleRepository interface {
+	Create(role *Role) error
+	GetAll() ([]Role, error)
+	GetByID(id uint) (*Role, error)
+	GetByName(name string) (*Role, error)
+	Update(role *Role) error
+	Delete(id uint) error
+	CountUsers(id uint) (int64, error)
+}
+
+type RoleRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &RoleRepositoryImpl{db}
+}
+
+func (r *Role) HasPermission(permission Permission) bool {
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Role) RemovePermissions(permissions ...Permission) {
+	kept := []Permission{}
+	for _, p := range r.Permissions {
+		removed := false
+		for _, permission := range permissions {
+			if p == permission {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, p)
+		}
+	}
+	r.Permissions = kept
+}
+
+func (r *Role) ValidatePermissions() error {
+	for _, p := range r.Permissions {
+		if !p.IsValid() {
+			return fmt.Errorf("unknown permission %q", p)
+		}
+	}
+	return nil
+}
+
+func (r *RoleRepositoryImpl) Create(role *Role) error {
+	return r.db.Create(role).Error
+}
+
+func (r *RoleRepositoryImpl) GetAll() ([]Role, error) {
+	var roles []Role
+	if err := r.db.Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *RoleRepositoryImpl) GetByID(id uint) (*Role, error) {
+	var role Role
+	if err := r.db.Where("id = ?", id).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RoleRepositoryImpl) GetByName(name string) (*Role, error) {
+	var role Role
+	if err := r.db.Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RoleRepositoryImpl) Update(role *Role) error {
+	return r.db.Save(role).Error
+}
+
+func (r *RoleRepositoryImpl) Delete(id uint) error {
+	return r.db.Delete(&Role{}, id).Error
+}
+
+func (r *RoleRepositoryImpl) CountUsers(id uint) (int64, error) {
+	var count int64
+	if err := r.db.Model(&User{}).Where("role_id = ?", id).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
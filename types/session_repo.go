@@ -0,0 +1,129 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// * a login on one device, the refresh token is rotated on every use and only its hash is stored
+type Session struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	UserID            string     `gorm:"index" json:"userID"`
+	RefreshTokenHash  string     `gorm:"uniqueIndex" json:"-"`
+	PreviousTokenHash string     `gorm:"index" json:"-"` // * presenting it again means the token was stolen
+	UserAgent         string     `json:"userAgent"`
+	IP                string     `json:"ip"`
+	LastUsedAt        time.Time  `json:"lastUsedAt"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+	RevokedAt         *time.Time `json:"revokedAt,omitempty"`
+}
+
+type SessionResponse struct {
+	Session
+	Current bool `json:"current"`
+}
+
+// * handed to clients that asked for the tokens instead of cookies
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"` // * seconds until the access token expires
+	RefreshToken string `json:"refreshToken"`
+}
+
+// * clients without cookies send the refresh token in the body
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type SessionRepository interface {
+	Create(session *Session) error
+	GetByID(id string) (*Session, error)
+	GetByRefreshTokenHash(hash string) (*Session, error)
+	GetByPreviousTokenHash(hash string) (*Session, error)
+	GetActiveByUserID(userID string, now time.Time) ([]Session, error)
+	Rotate(session *Session, presentedHash string) (bool, error)
+	Revoke(id string, now time.Time) error
+	RevokeAllByUserID(userID string, now time.Time) error
+	DeleteExpired(now time.Time) error
+}
+
+type SessionRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &SessionRepositoryImpl{db}
+}
+
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+func (s *SessionRepositoryImpl) Create(session *Session) error {
+	return s.db.Create(session).Error
+}
+
+func (s *SessionRepositoryImpl) GetByID(id string) (*Session, error) {
+	var session Session
+	if err := s.db.Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *SessionRepositoryImpl) GetByRefreshTokenHash(hash string) (*Session, error) {
+	var session Session
+	if err := s.db.Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *SessionRepositoryImpl) GetByPreviousTokenHash(hash string) (*Session, error) {
+	var session Session
+	if err := s.db.Where("previous_token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *SessionRepositoryImpl) GetActiveByUserID(userID string, now time.Time) ([]Session, error) {
+	var sessions []Session
+	if err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// * swaps the refresh token only while the presented one is still current, of two requests with the same token
+// * only one succeeds and the other finds nothing to rotate
+func (s *SessionRepositoryImpl) Rotate(session *Session, presentedHash string) (bool, error) {
+	result := s.db.Model(&Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, presentedHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  session.RefreshTokenHash,
+			"previous_token_hash": session.PreviousTokenHash,
+			"last_used_at":        session.LastUsedAt,
+			"user_agent":          session.UserAgent,
+			"ip":                  session.IP,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *SessionRepositoryImpl) Revoke(id string, now time.Time) error {
+	return s.db.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", now).Error
+}
+
+func (s *SessionRepositoryImpl) RevokeAllByUserID(userID string, now time.Time) error {
+	return s.db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error
+}
+
+// * revoked sessions are kept until they would have expired so that reused tokens are still recognized
+func (s *SessionRepositoryImpl) DeleteExpired(now time.Time) error {
+	return s.db.Where("expires_at < ?", now).Delete(&Session{}).Error
+}
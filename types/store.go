@@ -0,0 +1,60 @@
+package types
+
+import "gorm.io/gorm"
+
+// * every repository over the same connection, so that changes spanning several of them can share one transaction
+type Store struct {
+	db *gorm.DB
+
+	Users          UserRepository
+	Roles          RoleRepository
+	Items          ItemRepository
+	Kinds          KindRepository
+	Copies         CopyRepository
+	Holds          HoldRepository
+	Loans          LoanRepository
+	Fines          FineRepository
+	Rules          CirculationRuleRepository
+	Calendar       CalendarRepository
+	Branches       BranchRepository
+	Notifications  NotificationRepository
+	Sessions       SessionRepository
+	APIKeys        APIKeyRepository
+	RecoveryCodes  RecoveryCodeRepository
+	LoginThrottles LoginThrottleRepository
+	Audit          AuditRepository
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db:             db,
+		Users:          NewUserRepository(db),
+		Roles:          NewRoleRepository(db),
+		Items:          NewItemRepository(db),
+		Kinds:          NewKindRepository(db),
+		Copies:         NewCopyRepository(db),
+		Holds:          NewHoldRepository(db),
+		Loans:          NewLoanRepository(db),
+		Fines:          NewFineRepository(db),
+		Rules:          NewCirculationRuleRepository(db),
+		Calendar:       NewCalendarRepository(db),
+		Branches:       NewBranchRepository(db),
+		Notifications:  NewNotificationRepository(db),
+		Sessions:       NewSessionRepository(db),
+		APIKeys:        NewAPIKeyRepository(db),
+		RecoveryCodes:  NewRecoveryCodeRepository(db),
+		LoginThrottles: NewLoginThrottleRepository(db),
+		Audit:          NewAuditRepository(db),
+	}
+}
+
+// * fn gets a store whose repositories all run in one transaction, an error rolls back everything it did
+func (s *Store) Transaction(fn func(tx *Store) error) error {
+	// * a store put together by hand, like one over the fakes of a test, has no connection to open a transaction on
+	if s.db == nil {
+		return fn(s)
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(NewStore(tx))
+	})
+}
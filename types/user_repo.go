@@ -7,19 +7,28 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type UserRole uint
+// * what a new patron tells about themselves, roles, verification and two-factor settings are never taken from it
+type RegisterRequest struct {
+	LibraryCard string `json:"libraryCard"`
+	Category    string `json:"category"`
+	BranchID    *uint  `json:"branchID"` // * left empty the patron belongs to the branch of the staff registering them
 
-const (
-	Admin     UserRole = 3
-	Moderator UserRole = 2
-	Member    UserRole = 1
-)
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	Username    string `json:"username" binding:"required"`
+	Email       string `json:"email" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+	PhoneNumber string `json:"phoneNumber"`
+	Address     string `json:"address"`
+}
 
 type LoginRequest struct {
-	Username string
-	Password string
+	Username     string
+	Password     string
+	ReturnTokens bool `json:"returnTokens"` // * for clients that send a bearer token instead of cookies
 }
 
 type User struct {
@@ -27,9 +36,14 @@ type User struct {
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 
-	LibraryCard string   `gorm:"unique" json:"libraryCard"`
-	Verified    string   `json:"verified"`
-	Role        UserRole `json:"role"`
+	LibraryCard string `gorm:"unique" json:"libraryCard"`
+	Verified    string `json:"verified"`                      // * RFC3339 time of the email confirmation, empty until then
+	RoleID      uint   `gorm:"index;default:1" json:"roleID"` // * members unless told otherwise
+	Role        *Role  `json:"role,omitempty"`
+	Category    string `gorm:"index;default:adult" json:"category"` // * patron category the circulation rules are chosen by
+
+	BranchID *uint   `gorm:"index" json:"branchID"` // * home branch of a patron, staff only work at this one
+	Branch   *Branch `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
 
 	FirstName   string `json:"firstName"`
 	LastName    string `json:"lastName"`
@@ -38,6 +52,10 @@ type User struct {
 	Password    string `json:"password"`
 	PhoneNumber string `json:"phoneNumber"`
 	Address     string `json:"address"`
+
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totpEnabled"`
+	TOTPLastStep int64  `json:"-"` // * codes of this step and earlier can't be used again
 }
 
 type UserResponse struct {
@@ -45,9 +63,13 @@ type UserResponse struct {
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 
-	LibraryCard string   `json:"libraryCard"`
-	Verified    string   `json:"verified"`
-	Role        UserRole `json:"role"`
+	LibraryCard string       `json:"libraryCard"`
+	Verified    string       `json:"verified"`
+	RoleID      uint         `json:"roleID"`
+	Role        string       `json:"role"`
+	Permissions []Permission `json:"permissions"`
+	Category    string       `json:"category"`
+	BranchID    *uint        `json:"branchID"`
 
 	FirstName      string `json:"firstName"`
 	LastName       string `json:"lastName"`
@@ -56,6 +78,23 @@ type UserResponse struct {
 	PasswordExists bool   `json:"password"`
 	PhoneNumber    string `json:"phoneNumber"`
 	Address        string `json:"address"`
+	TOTPEnabled    bool   `json:"totpEnabled"`
+}
+
+// * the second login step, Code is either a TOTP code or a recovery code
+type TwoFactorLoginRequest struct {
+	Token        string `json:"token" binding:"required"`
+	Code         string `json:"code" binding:"required"`
+	ReturnTokens bool   `json:"returnTokens"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // * otpauth:// provisioning URI for the QR code
 }
 
 type ChangePasswordRequest struct {
@@ -65,9 +104,10 @@ type ChangePasswordRequest struct {
 
 type UserRepository interface {
 	Create(user *User) error
-	GetAll() ([]User, error)
+	GetAll(req PageRequest) (*Page[User], error)
 	GetByID(id string) (*User, error)
 	GetByUniqueField(field string, value string) (*User, error)
+	Lock(id string) error
 	Update(user *User) error
 	Delete(id string) error
 }
@@ -97,6 +137,15 @@ func (u *User) HashPassword(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
+func (u *User) IsVerified() bool {
+	return u.Verified != ""
+}
+
+// * the role must be loaded, the repository preloads it
+func (u *User) Can(permission Permission) bool {
+	return u.Role != nil && u.Role.HasPermission(permission)
+}
+
 func (u *User) IsValidEmail(email string) bool {
 	pattern := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
 	regex := regexp.MustCompile(pattern)
@@ -108,31 +157,44 @@ func (u *User) ConvertToUserResponse() *UserResponse {
 		ID:             u.ID,
 		CreatedAt:      u.CreatedAt,
 		UpdatedAt:      u.UpdatedAt,
-		Role:           u.Role,
+		Verified:       u.Verified,
+		RoleID:         u.RoleID,
+		Category:       u.Category,
+		BranchID:       u.BranchID,
 		FirstName:      u.FirstName,
 		LastName:       u.LastName,
 		Username:       u.Username,
 		Email:          u.Email,
 		PasswordExists: u.Password != "",
+		TOTPEnabled:    u.TOTPEnabled,
+	}
+
+	if u.Role != nil {
+		userResponse.Role = u.Role.Name
+		userResponse.Permissions = u.Role.Permissions
 	}
 	return &userResponse
 }
 
+// * the role is only ever changed through RoleID, saving the loaded role along would undo that
 func (ur *UserRepositoryImpl) Create(user *User) error {
-	return ur.db.Create(user).Error
+	return ur.db.Omit("Role").Create(user).Error
 }
 
-func (ur *UserRepositoryImpl) GetAll() ([]User, error) {
-	var users []User
-	if err := ur.db.Find(&users).Error; err != nil {
-		return nil, err
-	}
-	return users, nil
+var usersByUsername = keyset[User, string, string]{
+	keyColumn: "username",
+	idColumn:  "id",
+	preloads:  []string{"Role"},
+	values:    func(user *User) (string, string) { return user.Username, user.ID },
+}
+
+func (ur *UserRepositoryImpl) GetAll(req PageRequest) (*Page[User], error) {
+	return paginate(ur.db.Model(&User{}).Where("username LIKE ?", req.Filter+"%"), req, usersByUsername)
 }
 
 func (ur *UserRepositoryImpl) GetByID(id string) (*User, error) {
 	var user User
-	if err := ur.db.Where("id = ?", id).First(&user).Error; err != nil {
+	if err := ur.db.Preload("Role").Where("id = ?", id).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -140,20 +202,22 @@ func (ur *UserRepositoryImpl) GetByID(id string) (*User, error) {
 
 func (ur *UserRepositoryImpl) GetByUniqueField(field string, value string) (*User, error) {
 	var user User
-	if err := ur.db.Where(field+" = ?", value).First(&user).Error; err != nil {
+	if err := ur.db.Preload("Role").Where(field+" = ?", value).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (ur *UserRepositoryImpl) Update(user *User) error {
-	return ur.db.Save(user).Error
+// * holds the row until the transaction ends, so the limits of the user are counted by one operation at a time
+func (ur *UserRepositoryImpl) Lock(id string) error {
+	var user User
+	return ur.db.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", id).Take(&user).Error
 }
 
-func (ur *UserRepositoryImpl) Delete(id string) error {
-	return ur.db.Delete(&User{}, id).Error
+func (ur *UserRepositoryImpl) Update(user *User) error {
+	return ur.db.Omit("Role").Save(user).Error
 }
 
-func CheckPrivilege(userRole UserRole, privilegeRequired UserRole) bool {
-	return userRole >= privilegeRequired
+func (ur *UserRepositoryImpl) Delete(id string) error {
+	return ur.db.Where("id = ?", id).Delete(&User{}).Error
 }
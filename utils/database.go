@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/gimtwi/go-library-project/circulation"
 	"github.com/gimtwi/go-library-project/types"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -27,6 +28,205 @@ func ConnectDB() {
 }
 
 func MigrateDB() {
-	DB.AutoMigrate(&types.User{}, &types.Item{}, &types.Author{}, &types.Genre{}, &types.Hold{}, &types.Loan{})
+	DB.AutoMigrate(&types.Role{})
+	seedRoles()
+	// * api keys came right after members lost the staff reads, a database that has them went through it already
+	if !DB.Migrator().HasTable(&types.APIKey{}) {
+		migrateMemberReads()
+	}
+	migrateUserRole()
+
+	DB.AutoMigrate(&types.Branch{})
+	seedMainBranch()
+
+	hadMaxRenewals := DB.Migrator().HasColumn(&types.Loan{}, "max_renewals")
+
+	DB.AutoMigrate(&types.User{}, &types.Item{}, &types.Author{}, &types.Genre{}, &types.Hold{}, &types.Loan{}, &types.Fine{}, &types.Notification{}, &types.Copy{}, &types.Session{}, &types.PasswordResetToken{}, &types.RecoveryCode{}, &types.LoginThrottle{}, &types.APIKey{}, &types.Identity{}, &types.AuditEntry{}, &types.CirculationRule{}, &types.OpeningHours{}, &types.Closure{})
+	protectAuditLog()
+	uniqueRuleCells()
+	migrateSearchDocuments()
+	migrateItemQuantity()
+	if !hadMaxRenewals {
+		migrateMaxRenewals()
+	}
 	fmt.Println("database migration completed successfully!")
 }
+
+// * creates the built in roles, the admin role is brought up to every known permission on each start
+func seedRoles() {
+	builtin := []types.Role{
+		{ID: types.MemberRoleID, Name: "member", Description: "patrons of the library", Permissions: types.MemberPermissions, Builtin: true},
+		{ID: types.ModeratorRoleID, Name: "moderator", Description: "librarians at the desk", Permissions: types.ModeratorPermissions, Builtin: true},
+		{ID: types.AdminRoleID, Name: "admin", Description: "full access", Permissions: types.AllPermissions, Builtin: true},
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, role := range builtin {
+			if err := tx.Where("id = ?", role.ID).FirstOrCreate(&role).Error; err != nil {
+				return err
+			}
+		}
+
+		var admin types.Role
+		if err := tx.Where("id = ?", types.AdminRoleID).First(&admin).Error; err != nil {
+			return err
+		}
+
+		admin.Permissions = types.AllPermissions
+		if err := tx.Save(&admin).Error; err != nil {
+			return err
+		}
+
+		// * the ids were set by hand, the sequence has to catch up before custom roles are created
+		return tx.Exec("SELECT setval(pg_get_serial_sequence('roles', 'id'), (SELECT MAX(id) FROM roles))").Error
+	})
+
+	if err != nil {
+		log.Fatalf("failed to seed roles: %v", err)
+	}
+}
+
+// * the copies and holds of the single building before there were branches are given to the main one
+func seedMainBranch() {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		branch := types.Branch{ID: types.MainBranchID, Name: "main"}
+		if err := tx.Where("id = ?", branch.ID).FirstOrCreate(&branch).Error; err != nil {
+			return err
+		}
+
+		// * the id was set by hand, the sequence has to catch up before other branches are created
+		return tx.Exec("SELECT setval(pg_get_serial_sequence('branches', 'id'), (SELECT MAX(id) FROM branches))").Error
+	})
+
+	if err != nil {
+		log.Fatalf("failed to seed the main branch: %v", err)
+	}
+}
+
+// * the audit log is append only, the database refuses to change or remove its entries whoever asks
+func protectAuditLog() {
+	err := DB.Exec(`CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit entries can not be changed';
+		END;
+		$$ LANGUAGE plpgsql`).Error
+	if err == nil {
+		err = DB.Exec("DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries").Error
+	}
+	if err == nil {
+		err = DB.Exec(`CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_entries
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_entries_append_only()`).Error
+	}
+
+	if err != nil {
+		log.Fatalf("failed to protect the audit log: %v", err)
+	}
+}
+
+func uniqueRuleCells() {
+	if err := types.MigrateRuleCells(DB); err != nil {
+		log.Fatalf("failed to index the circulation rule cells: %v", err)
+	}
+}
+
+func migrateSearchDocuments() {
+	if err := types.MigrateSearchDocuments(DB); err != nil {
+		log.Fatalf("failed to set up the search documents: %v", err)
+	}
+}
+
+// * users used to keep their rank in the role column, the ranks are the ids of the built in roles
+func migrateUserRole() {
+	if !DB.Migrator().HasColumn(&types.User{}, "role") || DB.Migrator().HasColumn(&types.User{}, "role_id") {
+		return
+	}
+
+	if err := DB.Migrator().RenameColumn(&types.User{}, "role", "role_id"); err != nil {
+		log.Fatalf("failed to migrate user roles: %v", err)
+	}
+}
+
+// * members used to be able to read the records of everybody, later changes to the role are left alone
+func migrateMemberReads() {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var member types.Role
+		if err := tx.Where("id = ?", types.MemberRoleID).First(&member).Error; err != nil {
+			return err
+		}
+
+		member.RemovePermissions(types.StaffReadPermissions...)
+		return tx.Save(&member).Error
+	})
+
+	if err != nil {
+		log.Fatalf("failed to take the staff read permissions from members: %v", err)
+	}
+}
+
+// * loans from before the renewal limit was kept on the loan get the limit the library is configured with
+func migrateMaxRenewals() {
+	err := DB.Model(&types.Loan{}).Where("1 = 1").Update("max_renewals", circulation.PolicyFromEnv().MaxRenewals).Error
+	if err != nil {
+		log.Fatalf("failed to backfill the renewal limit of loans: %v", err)
+	}
+}
+
+// * items used to only keep a quantity, every unit of it becomes a copy with a placeholder barcode,
+// * every open loan gets one of them lent out
+func migrateItemQuantity() {
+	if !DB.Migrator().HasColumn(&types.Item{}, "quantity") {
+		return
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var items []struct {
+			ID       uint
+			Quantity uint
+		}
+		if err := tx.Table("items").Select("id, quantity").Scan(&items).Error; err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			var loans []types.Loan
+			if err := tx.Where("item_id = ? AND copy_id = 0", item.ID).Order("id").Find(&loans).Error; err != nil {
+				return err
+			}
+
+			// * the quantity was the whole stock, loans never went over it but a copy is owed to each of them anyway
+			copies := item.Quantity
+			if uint(len(loans)) > copies {
+				copies = uint(len(loans))
+			}
+
+			for n := uint(1); n <= copies; n++ {
+				cp := types.Copy{
+					ItemID:  item.ID,
+					Barcode: fmt.Sprintf("LEGACY-%d-%d", item.ID, n),
+					Status:  types.CopyAvailable,
+				}
+
+				lent := int(n) <= len(loans)
+				if lent {
+					cp.Status = types.CopyOnLoan
+				}
+
+				if err := tx.Create(&cp).Error; err != nil {
+					return err
+				}
+
+				if lent {
+					if err := tx.Model(&types.Loan{}).Where("id = ?", loans[n-1].ID).Update("copy_id", cp.ID).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return tx.Migrator().DropColumn(&types.Item{}, "quantity")
+	})
+
+	if err != nil {
+		log.Fatalf("failed to migrate item quantities to copies: %v", err)
+	}
+}
@@ -48,7 +48,7 @@ func CreateDefaultAdmin(db *gorm.DB) {
 			ID:       uuid.NewString(),
 			Username: config.Database.Username,
 			Password: config.Database.HashedAdminPassword,
-			Role:     types.Admin,
+			RoleID:   types.AdminRoleID,
 		}
 		if err := db.Create(&admin).Error; err != nil {
 			log.Fatal("error creating default admin user:", err)